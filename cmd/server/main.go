@@ -11,8 +11,13 @@ import (
 	"time"
 
 	"github.com/spf13/viper"
+	"github.com/wureny/FluxGo/internal/adaptive"
+	"github.com/wureny/FluxGo/internal/algorithms"
+	"github.com/wureny/FluxGo/internal/breaker"
 	"github.com/wureny/FluxGo/internal/gateway"
 	"github.com/wureny/FluxGo/internal/limiter"
+	"github.com/wureny/FluxGo/internal/shedder"
+	"github.com/wureny/FluxGo/internal/store/redisstore"
 	"github.com/wureny/FluxGo/pkg/client"
 )
 
@@ -29,15 +34,140 @@ type Config struct {
 	Gateway struct {
 		ListenAddr string            `mapstructure:"listen_addr"`
 		Targets    map[string]string `mapstructure:"targets"`
+		Redis      struct {
+			Addr     string `mapstructure:"addr"`
+			Password string `mapstructure:"password"`
+			DB       int    `mapstructure:"db"`
+		} `mapstructure:"redis"`
+		Adaptive map[string]struct {
+			Algorithm        string  `mapstructure:"algorithm"`
+			InitialLimit     int64   `mapstructure:"initial_limit"`
+			MinLimit         int64   `mapstructure:"min_limit"`
+			MaxLimit         int64   `mapstructure:"max_limit"`
+			LatencyThreshold string  `mapstructure:"latency_threshold"`
+			BackoffRatio     float64 `mapstructure:"backoff_ratio"`
+			Smoothing        float64 `mapstructure:"smoothing"`
+		} `mapstructure:"adaptive"`
+		Breakers map[string]struct {
+			FailureThreshold int    `mapstructure:"failure_threshold"`
+			OpenTimeout      string `mapstructure:"open_timeout"`
+			HalfOpenProbes   int    `mapstructure:"half_open_probes"`
+			Timeout          string `mapstructure:"timeout"`
+		} `mapstructure:"breakers"`
+		Shedding struct {
+			MaxInFlight     int64    `mapstructure:"max_in_flight"`
+			MaxGoroutines   int      `mapstructure:"max_goroutines"`
+			MaxLatency      string   `mapstructure:"max_latency"`
+			LatencyInterval string   `mapstructure:"latency_interval"`
+			Exempt          []string `mapstructure:"exempt"`
+		} `mapstructure:"shedding"`
+		// JWT验证器 (名称 -> 配置)，供key来源为jwt的规则使用
+		JWT map[string]struct {
+			Algorithm string `mapstructure:"algorithm"`
+			Secret    string `mapstructure:"secret"`
+			PublicKey string `mapstructure:"public_key"`
+			Issuer    string `mapstructure:"issuer"`
+			Audience  string `mapstructure:"audience"`
+			Leeway    string `mapstructure:"leeway"`
+		} `mapstructure:"jwt"`
 	} `mapstructure:"gateway"`
 
 	DefaultRules map[string]struct {
+		// 规则路径，为空时使用规则的名称。同一路径按方法或主机配置多个规则时需要设置
+		Path string `mapstructure:"path"`
+		// 适用的请求方法和主机，为空时不限制
+		Methods []string `mapstructure:"methods"`
+		Host    string   `mapstructure:"host"`
+
 		Algorithm  string `mapstructure:"algorithm"`
 		WindowSize string `mapstructure:"window_size"`
 		Limit      int64  `mapstructure:"limit"`
+		// 突发容量和初始填充比例，仅令牌桶和漏桶支持
+		Burst       int64    `mapstructure:"burst"`
+		InitialFill *float64 `mapstructure:"initial_fill"`
+		// 预热时长，仅令牌桶支持
+		WarmUp string `mapstructure:"warm_up"`
+		// 所有key合计的上限，仅并发限流和公平分配支持
+		GlobalLimit int64 `mapstructure:"global_limit"`
+		// 计数草图的精度和统计请求量最大的key数量，仅计数草图支持
+		SketchWidth int `mapstructure:"sketch_width"`
+		SketchDepth int `mapstructure:"sketch_depth"`
+		TopK        int `mapstructure:"top_k"`
+		// 日历周期和时区，仅日历配额支持
+		Period   string `mapstructure:"period"`
+		Location string `mapstructure:"location"`
+		Limits   []struct {
+			WindowSize  string   `mapstructure:"window_size"`
+			Limit       int64    `mapstructure:"limit"`
+			Burst       int64    `mapstructure:"burst"`
+			InitialFill *float64 `mapstructure:"initial_fill"`
+			WarmUp      string   `mapstructure:"warm_up"`
+			Period      string   `mapstructure:"period"`
+			Location    string   `mapstructure:"location"`
+			SketchWidth int      `mapstructure:"sketch_width"`
+			SketchDepth int      `mapstructure:"sketch_depth"`
+			TopK        int      `mapstructure:"top_k"`
+		} `mapstructure:"limits"`
+		Store    string `mapstructure:"store"`
+		MaxKeys  int    `mapstructure:"max_keys"`
+		MaxDelay string `mapstructure:"max_delay"`
+		// 状态存储不可用时的处理策略：open(默认)放行请求或closed拒绝请求
+		StoreFailure string `mapstructure:"store_failure"`
+		// 需要归还配额的响应状态码类别，如"5xx"
+		RefundOn []string `mapstructure:"refund_on"`
+		// 路径匹配多个具体路径时key的范围：pattern(默认)或path
+		KeyScope string `mapstructure:"key_scope"`
+		// 限流key的来源，为空时使用客户端IP
+		Key KeyConfig `mapstructure:"key"`
+		// 是否省略X-RateLimit配额响应头，省略后每个请求少查询一次存储
+		OmitHeaders bool `mapstructure:"omit_headers"`
+		// 请求优先级，低优先级的请求不能使用为高优先级保留的容量
+		Priority struct {
+			Source  string `mapstructure:"source"`
+			Header  string `mapstructure:"header"`
+			Classes []struct {
+				Name    string   `mapstructure:"name"`
+				Values  []string `mapstructure:"values"`
+				Reserve float64  `mapstructure:"reserve"`
+			} `mapstructure:"classes"`
+			DefaultReserve float64 `mapstructure:"default_reserve"`
+		} `mapstructure:"priority"`
+		Cost struct {
+			Source string `mapstructure:"source"`
+			Value  int64  `mapstructure:"value"`
+			Header string `mapstructure:"header"`
+			Unit   int64  `mapstructure:"unit"`
+			Max    int64  `mapstructure:"max"`
+		} `mapstructure:"cost"`
 	} `mapstructure:"default_rules"`
 }
 
+// KeyConfig 限流key的配置，组合和回退可以嵌套
+type KeyConfig struct {
+	Source   string      `mapstructure:"source"`
+	Name     string      `mapstructure:"name"`
+	Verifier string      `mapstructure:"verifier"`
+	Parts    []KeyConfig `mapstructure:"parts"`
+	Fallback *KeyConfig  `mapstructure:"fallback"`
+}
+
+// toKey 转换为规则的key配置
+func (k KeyConfig) toKey() limiter.Key {
+	key := limiter.Key{
+		Source:   limiter.KeySource(k.Source),
+		Name:     k.Name,
+		Verifier: k.Verifier,
+	}
+	for _, part := range k.Parts {
+		key.Parts = append(key.Parts, part.toKey())
+	}
+	if k.Fallback != nil {
+		fallback := k.Fallback.toKey()
+		key.Fallback = &fallback
+	}
+	return key
+}
+
 func main() {
 	flag.Parse()
 
@@ -47,10 +177,87 @@ func main() {
 		log.Fatalf("加载配置失败: %v", err)
 	}
 
+	// 各目标前缀的自适应并发限流配置
+	adaptiveConfigs := make(map[string]adaptive.Config)
+	for prefix, a := range config.Gateway.Adaptive {
+		var threshold time.Duration
+		if a.LatencyThreshold != "" {
+			d, err := time.ParseDuration(a.LatencyThreshold)
+			if err != nil {
+				log.Fatalf("解析延迟阈值失败: prefix=%s, error=%v", prefix, err)
+			}
+			threshold = d
+		}
+		adaptiveConfigs[prefix] = adaptive.Config{
+			Algorithm:        adaptive.Algorithm(a.Algorithm),
+			InitialLimit:     a.InitialLimit,
+			MinLimit:         a.MinLimit,
+			MaxLimit:         a.MaxLimit,
+			LatencyThreshold: threshold,
+			BackoffRatio:     a.BackoffRatio,
+			Smoothing:        a.Smoothing,
+		}
+	}
+
+	// 各目标前缀的熔断配置
+	breakerConfigs := make(map[string]breaker.Config)
+	for prefix, b := range config.Gateway.Breakers {
+		breakerConfigs[prefix] = breaker.Config{
+			FailureThreshold: b.FailureThreshold,
+			OpenTimeout:      parseBreakerDuration(prefix, b.OpenTimeout),
+			HalfOpenProbes:   b.HalfOpenProbes,
+			Timeout:          parseBreakerDuration(prefix, b.Timeout),
+		}
+	}
+
+	// 过载保护配置
+	shedding := config.Gateway.Shedding
+	sheddingConfig := shedder.Config{
+		MaxInFlight:     shedding.MaxInFlight,
+		MaxGoroutines:   shedding.MaxGoroutines,
+		MaxLatency:      parseSheddingDuration(shedding.MaxLatency),
+		LatencyInterval: parseSheddingDuration(shedding.LatencyInterval),
+		Exempt:          shedding.Exempt,
+	}
+
+	// JWT验证器配置
+	jwtConfigs := make(map[string]limiter.JWTConfig)
+	for name, j := range config.Gateway.JWT {
+		// 示例配置中的占位密钥，任何人都可以用它签发token
+		if j.Secret == "change-me" {
+			log.Fatalf("JWT密钥不能使用示例中的占位值: name=%s", name)
+		}
+		var leeway time.Duration
+		if j.Leeway != "" {
+			var err error
+			leeway, err = time.ParseDuration(j.Leeway)
+			if err != nil {
+				log.Fatalf("解析JWT时钟偏差失败: name=%s, error=%v", name, err)
+			}
+		}
+		jwtConfigs[name] = limiter.JWTConfig{
+			Algorithm: j.Algorithm,
+			Secret:    j.Secret,
+			PublicKey: j.PublicKey,
+			Issuer:    j.Issuer,
+			Audience:  j.Audience,
+			Leeway:    leeway,
+		}
+	}
+
 	// 创建网关
 	gw, err := gateway.New(gateway.Config{
 		ListenAddr: config.Gateway.ListenAddr,
 		Targets:    config.Gateway.Targets,
+		Redis: redisstore.Config{
+			Addr:     config.Gateway.Redis.Addr,
+			Password: config.Gateway.Redis.Password,
+			DB:       config.Gateway.Redis.DB,
+		},
+		Adaptive: adaptiveConfigs,
+		Breakers: breakerConfigs,
+		Shedding: sheddingConfig,
+		JWT:      jwtConfigs,
 	})
 	if err != nil {
 		log.Fatalf("创建网关失败: %v", err)
@@ -74,20 +281,106 @@ func main() {
 	})
 
 	// 设置默认限流规则
-	for path, rule := range config.DefaultRules {
-		windowSize, err := time.ParseDuration(rule.WindowSize)
-		if err != nil {
-			log.Fatalf("解析窗口大小失败: path=%s, error=%v", path, err)
+	for name, rule := range config.DefaultRules {
+		path := name
+		if rule.Path != "" {
+			path = rule.Path
+		}
+
+		var windowSize time.Duration
+		if rule.WindowSize != "" {
+			windowSize, err = time.ParseDuration(rule.WindowSize)
+			if err != nil {
+				log.Fatalf("解析窗口大小失败: path=%s, error=%v", path, err)
+			}
+		}
+
+		warmUp := parseWarmUp(path, rule.WarmUp)
+
+		// 多个限流配置需要同时满足
+		var limits []algorithms.Config
+		for _, l := range rule.Limits {
+			var d time.Duration
+			if l.WindowSize != "" {
+				d, err = time.ParseDuration(l.WindowSize)
+				if err != nil {
+					log.Fatalf("解析窗口大小失败: path=%s, error=%v", path, err)
+				}
+			}
+			limits = append(limits, algorithms.Config{
+				WindowSize:  d,
+				Limit:       l.Limit,
+				Burst:       l.Burst,
+				InitialFill: l.InitialFill,
+				WarmUp:      parseWarmUp(path, l.WarmUp),
+				Period:      algorithms.Period(l.Period),
+				Location:    l.Location,
+				SketchWidth: l.SketchWidth,
+				SketchDepth: l.SketchDepth,
+				TopK:        l.TopK,
+			})
+		}
+
+		// 整形模式的最大排队时间
+		var maxDelay time.Duration
+		if rule.MaxDelay != "" {
+			maxDelay, err = time.ParseDuration(rule.MaxDelay)
+			if err != nil {
+				log.Fatalf("解析最大排队时间失败: path=%s, error=%v", path, err)
+			}
+		}
+
+		// 优先级类别
+		var classes []limiter.PriorityClass
+		for _, class := range rule.Priority.Classes {
+			classes = append(classes, limiter.PriorityClass{
+				Name:    class.Name,
+				Values:  class.Values,
+				Reserve: class.Reserve,
+			})
 		}
 
 		// 添加重试逻辑
 		var setRuleErr error
 		for i := 0; i < 3; i++ { // 最多重试3次
 			setRuleErr = c.SetRule(client.RuleConfig{
-				Path:       path,
-				Algorithm:  limiter.Algorithm(rule.Algorithm),
-				WindowSize: windowSize,
-				Limit:      rule.Limit,
+				Path:         path,
+				Algorithm:    limiter.Algorithm(rule.Algorithm),
+				WindowSize:   windowSize,
+				Limit:        rule.Limit,
+				Burst:        rule.Burst,
+				InitialFill:  rule.InitialFill,
+				GlobalLimit:  rule.GlobalLimit,
+				WarmUp:       warmUp,
+				Period:       algorithms.Period(rule.Period),
+				Location:     rule.Location,
+				Limits:       limits,
+				Store:        limiter.StoreType(rule.Store),
+				StoreFailure: algorithms.FailurePolicy(rule.StoreFailure),
+				MaxKeys:      rule.MaxKeys,
+				MaxDelay:     maxDelay,
+				RefundOn:     rule.RefundOn,
+				SketchWidth:  rule.SketchWidth,
+				SketchDepth:  rule.SketchDepth,
+				TopK:         rule.TopK,
+				KeyScope:     limiter.KeyScope(rule.KeyScope),
+				Methods:      rule.Methods,
+				Host:         rule.Host,
+				Key:          rule.Key.toKey(),
+				OmitHeaders:  rule.OmitHeaders,
+				Priority: limiter.Priority{
+					Source:         limiter.PrioritySource(rule.Priority.Source),
+					Header:         rule.Priority.Header,
+					Classes:        classes,
+					DefaultReserve: rule.Priority.DefaultReserve,
+				},
+				Cost: limiter.Cost{
+					Source: limiter.CostSource(rule.Cost.Source),
+					Value:  rule.Cost.Value,
+					Header: rule.Cost.Header,
+					Unit:   rule.Cost.Unit,
+					Max:    rule.Cost.Max,
+				},
 			})
 			if setRuleErr == nil {
 				break
@@ -95,11 +388,11 @@ func main() {
 			time.Sleep(time.Second) // 重试前等待1秒
 		}
 		if setRuleErr != nil {
-			log.Fatalf("设置默认规则失败: path=%s, error=%v", path, err)
+			log.Fatalf("设置默认规则失败: path=%s, error=%v", path, setRuleErr)
 		}
 
-		log.Printf("设置默认规则: path=%s, algorithm=%s, window_size=%s, limit=%d",
-			path, rule.Algorithm, rule.WindowSize, rule.Limit)
+		log.Printf("设置默认规则: path=%s, methods=%v, host=%s, algorithm=%s, window_size=%s, limit=%d, limits=%d, store=%s",
+			path, rule.Methods, rule.Host, rule.Algorithm, rule.WindowSize, rule.Limit, len(limits), rule.Store)
 	}
 
 	// 优雅关闭
@@ -135,3 +428,39 @@ func loadConfig(file string, config interface{}) error {
 	}
 	return nil
 }
+
+// 解析预热时长，为空时不预热
+func parseWarmUp(path string, s string) time.Duration {
+	if s == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		log.Fatalf("解析预热时长失败: path=%s, error=%v", path, err)
+	}
+	return d
+}
+
+// parseBreakerDuration 解析熔断配置中的时长，为空时返回0
+func parseBreakerDuration(prefix string, s string) time.Duration {
+	if s == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		log.Fatalf("解析熔断时长失败: prefix=%s, error=%v", prefix, err)
+	}
+	return d
+}
+
+// parseSheddingDuration 解析过载保护的时长配置，为空时返回0
+func parseSheddingDuration(s string) time.Duration {
+	if s == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		log.Fatalf("解析过载保护时长失败: %v", err)
+	}
+	return d
+}
@@ -42,7 +42,7 @@ func main() {
 		},
 		{
 			path:      "/api/v1/orders",
-			algorithm: limiter.SlidingWindow,
+			algorithm: limiter.FixedWindow,
 			window:    time.Second,
 			limit:     10,
 		},
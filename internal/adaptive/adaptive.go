@@ -0,0 +1,190 @@
+package adaptive
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// rttWindow Gradient计算长期平均延迟使用的请求数
+const rttWindow = 600
+
+// Algorithm 自适应算法类型
+type Algorithm string
+
+const (
+	// AIMD 加性增、乘性减：请求成功时限制加1，出错或延迟超过阈值时按比例缩小
+	AIMD Algorithm = "aimd"
+	// Gradient 梯度算法：按长期平均延迟与本次延迟的比值调整限制，延迟上升时收缩
+	Gradient Algorithm = "gradient"
+)
+
+// Config 自适应并发限流配置
+type Config struct {
+	// 算法，为空时使用AIMD
+	Algorithm Algorithm
+	// 初始并发限制，为0时等于MinLimit和20中较大的值
+	InitialLimit int64
+	// 并发限制的下限，为0时为1
+	MinLimit int64
+	// 并发限制的上限，为0时为1000
+	MaxLimit int64
+	// 延迟超过该值视为过载，为0时只按错误判断。仅AIMD使用
+	LatencyThreshold time.Duration
+	// 过载时限制缩小的比例，必须在0到1之间，为0时为0.9
+	BackoffRatio float64
+	// 每次调整时新限制的权重，为0时为0.2。仅Gradient使用
+	Smoothing float64
+}
+
+// Snapshot 自适应限流器的当前状态
+type Snapshot struct {
+	// 当前计算出的并发限制
+	Limit int64
+	// 在途请求数
+	InFlight int64
+	// 长期平均延迟，仅Gradient使用
+	RTT time.Duration
+}
+
+// Limiter 根据上游响应延迟和错误率自动调整并发限制的限流器
+// 请求转发前调用Acquire占用配额，响应结束后调用Release上报延迟和结果
+type Limiter struct {
+	mu sync.Mutex
+	// 配置信息
+	config Config
+	// 当前并发限制
+	limit float64
+	// 在途请求数
+	inFlight int64
+	// 长期平均延迟
+	rtt float64
+}
+
+// NewLimiter 创建一个新的自适应并发限流器，算法未知或上下限、缩小比例无效时返回错误
+func NewLimiter(config Config) (*Limiter, error) {
+	switch config.Algorithm {
+	case "":
+		config.Algorithm = AIMD
+	case AIMD, Gradient:
+	default:
+		return nil, fmt.Errorf("unsupported adaptive algorithm: %s", config.Algorithm)
+	}
+	if config.MinLimit <= 0 {
+		config.MinLimit = 1
+	}
+	if config.MaxLimit <= 0 {
+		config.MaxLimit = 1000
+	}
+	if config.InitialLimit <= 0 {
+		config.InitialLimit = 20
+		if config.MinLimit > config.InitialLimit {
+			config.InitialLimit = config.MinLimit
+		}
+	}
+	if config.BackoffRatio == 0 {
+		config.BackoffRatio = 0.9
+	}
+	if config.Smoothing <= 0 {
+		config.Smoothing = 0.2
+	}
+	if config.MinLimit > config.MaxLimit {
+		return nil, fmt.Errorf("invalid adaptive config: min limit %d exceeds max limit %d", config.MinLimit, config.MaxLimit)
+	}
+	if config.BackoffRatio <= 0 || config.BackoffRatio >= 1 {
+		return nil, fmt.Errorf("invalid adaptive config: backoff ratio must be between 0 and 1")
+	}
+
+	l := &Limiter{config: config}
+	l.limit = l.clamp(float64(config.InitialLimit))
+	return l, nil
+}
+
+// Acquire 占用一个在途请求配额，当前限制已满时返回false
+func (l *Limiter) Acquire() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.inFlight >= int64(l.limit) {
+		return false
+	}
+	l.inFlight++
+	return true
+}
+
+// Release 归还配额，并根据本次请求的延迟和是否出错调整限制
+func (l *Limiter) Release(rtt time.Duration, dropped bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// 按释放前的在途请求数（包含本次请求）判断是否跑满了限制
+	inFlight := l.inFlight
+	l.inFlight--
+
+	switch l.config.Algorithm {
+	case Gradient:
+		l.gradient(float64(rtt), inFlight, dropped)
+	default:
+		l.aimd(rtt, inFlight, dropped)
+	}
+}
+
+// Cancel 归还配额但不调整限制，用于客户端断开等无法反映上游状态的情况
+func (l *Limiter) Cancel() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+}
+
+// Snapshot 返回当前状态
+func (l *Limiter) Snapshot() Snapshot {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return Snapshot{
+		Limit:    int64(l.limit),
+		InFlight: l.inFlight,
+		RTT:      time.Duration(l.rtt),
+	}
+}
+
+// aimd 出错或超时时按比例缩小，否则加1
+func (l *Limiter) aimd(rtt time.Duration, inFlight int64, dropped bool) {
+	if dropped || (l.config.LatencyThreshold > 0 && rtt > l.config.LatencyThreshold) {
+		l.limit = l.clamp(l.limit * l.config.BackoffRatio)
+		return
+	}
+	// 在途请求不到限制的一半时说明流量本身不大，成功不代表上游还能承受更多
+	if float64(inFlight)*2 < l.limit {
+		return
+	}
+	l.limit = l.clamp(l.limit + 1)
+}
+
+// gradient 按长期平均延迟与本次延迟的比值调整限制
+func (l *Limiter) gradient(rtt float64, inFlight int64, dropped bool) {
+	if dropped {
+		l.limit = l.clamp(l.limit * l.config.BackoffRatio)
+		return
+	}
+
+	// 长期平均延迟按最近约rttWindow个请求计算，跟随上游的正常延迟缓慢变化
+	if l.rtt == 0 {
+		l.rtt = rtt
+	} else {
+		l.rtt += (rtt - l.rtt) * 2 / (rttWindow + 1)
+	}
+	if float64(inFlight)*2 < l.limit || rtt <= 0 {
+		return
+	}
+
+	// 延迟上升时梯度小于1，最多一次减半；留出平方根大小的排队空间用于探测更高的限制
+	gradient := math.Max(0.5, math.Min(1, l.rtt/rtt))
+	next := l.limit*gradient + math.Sqrt(l.limit)
+	l.limit = l.clamp(l.limit*(1-l.config.Smoothing) + next*l.config.Smoothing)
+}
+
+// clamp 将限制限定在上下限之间
+func (l *Limiter) clamp(limit float64) float64 {
+	return math.Max(float64(l.config.MinLimit), math.Min(float64(l.config.MaxLimit), limit))
+}
@@ -0,0 +1,71 @@
+package algorithms
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock 时钟接口，算法通过它获取当前时间
+type Clock interface {
+	// Now 返回当前时间
+	Now() time.Time
+}
+
+// Clocked 可以返回所用时钟的限流器
+type Clocked interface {
+	// Clock 返回限流器使用的时钟
+	Clock() Clock
+}
+
+// ClockOf 返回限流器使用的时钟，限流器没有实现Clocked接口时返回系统时钟
+func ClockOf(l RateLimiter) Clock {
+	if c, ok := l.(Clocked); ok {
+		return c.Clock()
+	}
+	return RealClock()
+}
+
+// realClock 使用系统时间的时钟
+type realClock struct{}
+
+// Now 实现Clock接口
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// RealClock 返回使用系统时间的时钟
+func RealClock() Clock {
+	return realClock{}
+}
+
+// ManualClock 手动推进的时钟，用于在测试中精确控制时间
+type ManualClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewManualClock 创建一个停在now的手动时钟
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+// Now 实现Clock接口
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance 将时钟向前推进d
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Set 将时钟设置为now
+func (c *ManualClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
@@ -0,0 +1,113 @@
+package composite
+
+import (
+	"context"
+	"time"
+
+	"github.com/wureny/FluxGo/internal/algorithms"
+)
+
+// CompositeLimiter 组合多个限流器，请求需要同时通过所有限流器才被允许
+// 例如同时限制每秒10个请求和每天5000个请求
+type CompositeLimiter struct {
+	// 按顺序检查的限流器
+	limiters []algorithms.RateLimiter
+}
+
+// NewLimiter 创建组合限流器，子限流器应该实现Refunder接口，
+// 否则某个限流器拒绝时无法归还之前限流器已经扣减的配额
+func NewLimiter(limiters ...algorithms.RateLimiter) *CompositeLimiter {
+	return &CompositeLimiter{
+		limiters: limiters,
+	}
+}
+
+// Allow 实现RateLimiter接口
+func (l *CompositeLimiter) Allow(ctx context.Context, key string) (bool, time.Duration) {
+	return l.AllowN(ctx, key, 1)
+}
+
+// AllowN 实现RateLimiter接口
+// 依次向每个限流器扣减配额，某个限流器拒绝时归还之前已经扣减的配额，并返回该限流器的等待时间
+func (l *CompositeLimiter) AllowN(ctx context.Context, key string, n int64) (bool, time.Duration) {
+	for i, limiter := range l.limiters {
+		allowed, wait := limiter.AllowN(ctx, key, n)
+		if !allowed {
+			l.refund(ctx, l.limiters[:i], key, n)
+			return false, wait
+		}
+	}
+	return true, 0
+}
+
+// Status 实现RateLimiter接口
+// 剩余配额最少的限流器决定容量和剩余配额，等待时间和恢复时间取所有限流器中最长的
+func (l *CompositeLimiter) Status(ctx context.Context, key string) algorithms.Status {
+	var status algorithms.Status
+	for i, limiter := range l.limiters {
+		s := limiter.Status(ctx, key)
+		if i == 0 || s.Remaining < status.Remaining {
+			status.Limit = s.Limit
+			status.Remaining = s.Remaining
+		}
+		if s.Wait > status.Wait {
+			status.Wait = s.Wait
+		}
+		if s.Reset.After(status.Reset) {
+			status.Reset = s.Reset
+		}
+	}
+	return status
+}
+
+// AllowNReserved 实现Prioritizer接口，每个限流器都按reserve比例保留容量
+// 不支持保留容量的限流器按AllowN判断
+func (l *CompositeLimiter) AllowNReserved(ctx context.Context, key string, n int64, reserve float64) (bool, time.Duration) {
+	for i, limiter := range l.limiters {
+		var allowed bool
+		var wait time.Duration
+		if p, ok := limiter.(algorithms.Prioritizer); ok {
+			allowed, wait = p.AllowNReserved(ctx, key, n, reserve)
+		} else {
+			allowed, wait = limiter.AllowN(ctx, key, n)
+		}
+		if !allowed {
+			l.refund(ctx, l.limiters[:i], key, n)
+			return false, wait
+		}
+	}
+	return true, 0
+}
+
+// RefundN 实现Refunder接口，向所有限流器归还配额
+func (l *CompositeLimiter) RefundN(ctx context.Context, key string, n int64) {
+	l.refund(ctx, l.limiters, key, n)
+}
+
+// refund 向指定的限流器归还配额
+func (l *CompositeLimiter) refund(ctx context.Context, limiters []algorithms.RateLimiter, key string, n int64) {
+	for _, limiter := range limiters {
+		if r, ok := limiter.(algorithms.Refunder); ok {
+			r.RefundN(ctx, key, n)
+		}
+	}
+}
+
+// Clock 实现Clocked接口，返回第一个限流器使用的时钟
+func (l *CompositeLimiter) Clock() algorithms.Clock {
+	if len(l.limiters) == 0 {
+		return algorithms.RealClock()
+	}
+	return algorithms.ClockOf(l.limiters[0])
+}
+
+// Close 实现RateLimiter接口
+func (l *CompositeLimiter) Close() error {
+	var firstErr error
+	for _, limiter := range l.limiters {
+		if err := limiter.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
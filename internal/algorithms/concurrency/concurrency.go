@@ -0,0 +1,329 @@
+package concurrency
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/wureny/FluxGo/internal/algorithms"
+	"github.com/wureny/FluxGo/internal/store"
+)
+
+// globalKey 保存所有key合计在途请求的key
+const globalKey = "*"
+
+// acquireScript 占用在途请求配额的Redis实现
+// 租约保存在有序集合中，成员为"租约ID#序号"，每个单位一个成员，分数为到期时间
+// ARGV: 当前时间(微秒), 租约时长(微秒), 最大在途请求数, 占用的单位数, 租约ID
+// 返回: {是否允许, 最长需要等待的微秒数}
+var acquireScript = store.NewScript(`
+local now = tonumber(ARGV[1])
+local lease = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+local n = tonumber(ARGV[4])
+local id = ARGV[5]
+
+-- 清理到期的租约
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+
+local count = redis.call('ZCARD', KEYS[1])
+if count + n > limit then
+	-- 请求完成时会提前释放，租约到期是最长的等待时间
+	local index = count + n - limit - 1
+	local oldest = redis.call('ZRANGE', KEYS[1], index, index, 'WITHSCORES')
+	return {0, tonumber(oldest[2]) - now}
+end
+
+for i = 1, n do
+	redis.call('ZADD', KEYS[1], now + lease, id .. '#' .. i)
+end
+redis.call('PEXPIRE', KEYS[1], math.ceil(lease / 1e3) + 1)
+return {1, 0}
+`)
+
+// releaseScript 释放租约的Redis实现
+// ARGV: 当前时间(微秒), 租约ID, 释放的单位数
+// 返回: {在途请求数}
+var releaseScript = store.NewScript(`
+local n = tonumber(ARGV[3])
+
+-- 到期的租约已经不占配额，先清理再释放
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+
+for i = 1, n do
+	redis.call('ZREM', KEYS[1], ARGV[2] .. '#' .. i)
+end
+return {redis.call('ZCARD', KEYS[1])}
+`)
+
+// statusScript 查询在途请求配额的Redis实现，不修改状态
+// ARGV: 当前时间(微秒), 最大在途请求数
+// 返回: {剩余的单位数, 距离所有租约到期的微秒数, 需要等待的微秒数}
+var statusScript = store.NewScript(`
+local now = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+
+-- 跳过到期的租约，结果为成员和到期时间交替的列表
+local leases = redis.call('ZRANGEBYSCORE', KEYS[1], '(' .. ARGV[1], '+inf', 'WITHSCORES')
+local count = #leases / 2
+if count == 0 then
+	return {limit, 0, 0}
+end
+
+local reset = tonumber(leases[#leases]) - now
+if count < limit then
+	return {limit - count, reset, 0}
+end
+return {0, reset, tonumber(leases[(count - limit + 1) * 2]) - now}
+`)
+
+// lease 在途请求占用的一个单位的配额
+type lease struct {
+	// 租约ID，同一个请求占用的所有单位共享
+	id string
+	// 到期时间
+	deadline time.Time
+}
+
+// ConcurrencyLimiter 实现按在途请求数限流的限流器
+// Limit为每个key的最大在途请求数，GlobalLimit为所有key合计的最大在途请求数；
+// 通过AcquireN放行的请求完成后需要用返回的租约ID调用ReleaseN释放配额。WindowSize为租约时长，
+// 网关异常退出等原因没有释放的配额以及通过AllowN占用的配额在租约到期后自动释放
+type ConcurrencyLimiter struct {
+	// 状态存储
+	store store.Store
+	// 时钟
+	clock algorithms.Clock
+	// 配置信息
+	config algorithms.Config
+	// 存储执行失败时的处理策略
+	failure algorithms.FailurePolicy
+}
+
+// NewLimiter 创建一个新的并发限流器
+func NewLimiter(config algorithms.Config, opts ...algorithms.Option) *ConcurrencyLimiter {
+	o := algorithms.NewOptions(opts...)
+	return &ConcurrencyLimiter{
+		store:   o.Store,
+		clock:   o.Clock,
+		config:  config,
+		failure: o.Failure,
+	}
+}
+
+// Allow 实现RateLimiter接口
+func (l *ConcurrencyLimiter) Allow(ctx context.Context, key string) (bool, time.Duration) {
+	return l.AllowN(ctx, key, 1)
+}
+
+// AllowN 实现RateLimiter接口，占用key的n个在途请求配额
+// 不返回租约ID，占用的配额在租约到期后释放。需要在请求完成时释放配额应使用AcquireN
+func (l *ConcurrencyLimiter) AllowN(ctx context.Context, key string, n int64) (bool, time.Duration) {
+	_, allowed, wait := l.AcquireN(ctx, key, n, 0)
+	return allowed, wait
+}
+
+// AllowNReserved 实现Prioritizer接口，等价于不返回租约ID的AcquireN
+func (l *ConcurrencyLimiter) AllowNReserved(ctx context.Context, key string, n int64, reserve float64) (bool, time.Duration) {
+	_, allowed, wait := l.AcquireN(ctx, key, n, reserve)
+	return allowed, wait
+}
+
+// AcquireN 实现Releaser接口，key的配额和全局配额都按reserve比例保留
+// 全局配额为所有key共享，保留后低优先级的客户端不能占满高优先级客户端的在途请求数。
+// 拒绝时返回租约到期的时间，这是最长的等待时间，请求完成时配额会提前释放
+func (l *ConcurrencyLimiter) AcquireN(ctx context.Context, key string, n int64, reserve float64) (string, bool, time.Duration) {
+	limit := l.config.Limit - l.config.Reserved(reserve)
+	globalLimit := l.config.GlobalLimit
+	if globalLimit > 0 {
+		globalLimit -= int64(math.Round(float64(globalLimit) * reserve))
+		if globalLimit <= 0 {
+			return "", false, 0
+		}
+	}
+	if n > limit || (globalLimit > 0 && n > globalLimit) {
+		return "", false, 0
+	}
+
+	id := newLeaseID()
+	now := l.clock.Now()
+	allowed, wait := l.acquire(ctx, key, id, n, limit, now)
+	if !allowed || globalLimit <= 0 {
+		return id, allowed, wait
+	}
+
+	// 全局配额不足时归还key的配额
+	allowed, wait = l.acquire(ctx, globalKey, id, n, globalLimit, now)
+	if !allowed {
+		l.release(ctx, key, id, n, now)
+	}
+	return id, allowed, wait
+}
+
+// ReleaseN 实现Releaser接口
+func (l *ConcurrencyLimiter) ReleaseN(ctx context.Context, key string, lease string, n int64) {
+	now := l.clock.Now()
+	l.release(ctx, key, lease, n, now)
+	if l.config.GlobalLimit > 0 {
+		l.release(ctx, globalKey, lease, n, now)
+	}
+}
+
+// InFlight 实现Releaser接口
+func (l *ConcurrencyLimiter) InFlight(ctx context.Context, key string) (int64, int64) {
+	now := l.clock.Now()
+	count := l.release(ctx, key, "", 0, now)
+	global := int64(0)
+	if l.config.GlobalLimit > 0 {
+		global = l.release(ctx, globalKey, "", 0, now)
+	}
+	return count, global
+}
+
+// Status 实现RateLimiter接口，设置了GlobalLimit时剩余的配额同时受全局配额限制
+// 等待时间按租约到期计算，请求完成时配额会提前释放
+func (l *ConcurrencyLimiter) Status(ctx context.Context, key string) algorithms.Status {
+	now := l.clock.Now()
+	status := l.status(ctx, key, l.config.Limit, now)
+	if l.config.GlobalLimit <= 0 {
+		return status
+	}
+
+	global := l.status(ctx, globalKey, l.config.GlobalLimit, now)
+	if global.Remaining < status.Remaining {
+		status.Remaining = global.Remaining
+	}
+	if global.Wait > status.Wait {
+		status.Wait = global.Wait
+	}
+	return status
+}
+
+// status 返回key在limit下的配额状态
+func (l *ConcurrencyLimiter) status(ctx context.Context, key string, limit int64, now time.Time) algorithms.Status {
+	res, err := l.store.Exec(ctx, key, store.Op{
+		Script: statusScript,
+		Args:   []interface{}{now.UnixMicro(), limit},
+		Apply: func(state interface{}) (interface{}, time.Time, []int64) {
+			leases := active(state, now)
+			count := int64(len(leases))
+			if count == 0 {
+				return nil, time.Time{}, []int64{limit, 0, 0}
+			}
+
+			// 只读取状态，保持原有的租约
+			last := leases[count-1].deadline
+			reset := store.Micros(last.Sub(now))
+			if count < limit {
+				return state, last, []int64{limit - count, reset, 0}
+			}
+			return state, last, []int64{0, reset, store.Micros(leases[count-limit].deadline.Sub(now))}
+		},
+	})
+	if err != nil {
+		log.Printf("并发限流查询状态失败: key=%s, error=%v", key, err)
+		return algorithms.Status{Limit: limit, Remaining: limit, Reset: now}
+	}
+
+	return algorithms.Status{
+		Limit:     limit,
+		Remaining: res[0],
+		Reset:     now.Add(store.Duration(res[1])),
+		Wait:      store.Duration(res[2]),
+	}
+}
+
+// acquire 为key占用n个单位的配额，每个单位保存一条租约ID为id的租约
+func (l *ConcurrencyLimiter) acquire(ctx context.Context, key string, id string, n int64, limit int64, now time.Time) (bool, time.Duration) {
+	res, err := l.store.Exec(ctx, key, store.Op{
+		Script: acquireScript,
+		Args:   []interface{}{now.UnixMicro(), l.config.WindowSize.Microseconds(), limit, n, id},
+		Apply: func(state interface{}) (interface{}, time.Time, []int64) {
+			leases := active(state, now)
+
+			count := int64(len(leases))
+			if count+n > limit {
+				// 请求完成时会提前释放，租约到期是最长的等待时间
+				wait := leases[count+n-limit-1].deadline.Sub(now)
+				return leases, leases[count-1].deadline, []int64{0, store.Micros(wait)}
+			}
+
+			deadline := now.Add(l.config.WindowSize)
+			for i := int64(0); i < n; i++ {
+				leases = append(leases, lease{id: id, deadline: deadline})
+			}
+			return leases, deadline, []int64{1, 0}
+		},
+	})
+	if err != nil {
+		return l.failure.Allows("并发限流", key, err), 0
+	}
+
+	return res[0] == 1, store.Duration(res[1])
+}
+
+// release 释放key中租约ID为id的n个单位的配额，返回剩余的在途请求数
+func (l *ConcurrencyLimiter) release(ctx context.Context, key string, id string, n int64, now time.Time) int64 {
+	res, err := l.store.Exec(ctx, key, store.Op{
+		Script: releaseScript,
+		Args:   []interface{}{now.UnixMicro(), id, n},
+		Apply: func(state interface{}) (interface{}, time.Time, []int64) {
+			leases := active(state, now)
+			kept := leases[:0]
+			released := int64(0)
+			for _, lease := range leases {
+				if lease.id == id && released < n {
+					released++
+					continue
+				}
+				kept = append(kept, lease)
+			}
+			if len(kept) == 0 {
+				return nil, time.Time{}, []int64{0}
+			}
+			return kept, kept[len(kept)-1].deadline, []int64{int64(len(kept))}
+		},
+	})
+	if err != nil {
+		log.Printf("并发限流释放配额失败: key=%s, error=%v", key, err)
+		return 0
+	}
+
+	return res[0]
+}
+
+// Clock 实现Clocked接口
+func (l *ConcurrencyLimiter) Clock() algorithms.Clock {
+	return l.clock
+}
+
+// Close 实现RateLimiter接口
+func (l *ConcurrencyLimiter) Close() error {
+	return l.store.Close()
+}
+
+// active 返回尚未到期的租约
+func active(state interface{}, now time.Time) []lease {
+	leases, _ := state.([]lease)
+	valid := make([]lease, 0, len(leases))
+	for _, lease := range leases {
+		if lease.deadline.After(now) {
+			valid = append(valid, lease)
+		}
+	}
+	return valid
+}
+
+// newLeaseID 生成随机的租约ID
+func newLeaseID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		// 无法读取随机数时退化为按时间生成，只影响ID的唯一性
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,484 @@
+package countmin
+
+import (
+	"context"
+	"hash/fnv"
+	"log"
+	"math"
+	"time"
+
+	"github.com/wureny/FluxGo/internal/algorithms"
+	"github.com/wureny/FluxGo/internal/store"
+)
+
+const (
+	// 默认每行的计数器数量
+	defaultWidth = 2048
+	// 默认行数
+	defaultDepth = 4
+	// 默认统计的key数量
+	defaultTopK = 10
+
+	// 每行计数器数量的上限，两个窗口的草图最多占用2*MaxWidth*MaxDepth个计数器
+	MaxWidth = 1 << 16
+	// 行数的上限
+	MaxDepth = 16
+	// 统计的key数量的上限
+	MaxTopK = 1000
+)
+
+// sketchKey 保存当前窗口计数草图的key，所有key共用同一个草图
+const sketchKey = "*"
+
+// 相邻两个窗口的计数草图，每个窗口depth行、每行width个计数器，按行连续保存
+type sketch struct {
+	start    time.Time // 当前窗口的起始时间
+	current  []int64   // 当前窗口的计数器
+	previous []int64   // 上一个窗口的计数器
+}
+
+// sketchLua 滚动窗口和读取估算值，供各脚本共用
+// 当前窗口的草图保存在KEYS[1]的hash中：start为窗口的起始时间，c<i>为第i个计数器，
+// 计数器的序号由调用方按key的哈希值计算后传入。窗口切换时整个hash改名为KEYS[1]:prev，
+// 作为上一个窗口的草图，不需要复制计数器
+var sketchLua = `
+local prev = KEYS[1] .. ':prev'
+
+-- 滚动到now所在的窗口，当前窗口的草图变为上一个窗口的草图
+local function roll(now, window)
+	local start = now - now % window
+	local last = tonumber(redis.call('HGET', KEYS[1], 'start'))
+	if last == start then
+		return start
+	end
+
+	if last == start - window then
+		redis.call('RENAME', KEYS[1], prev)
+		-- 上一个窗口的计数在当前窗口结束后不再有影响
+		redis.call('PEXPIRE', prev, math.ceil((start + window - now) / 1e3) + 1)
+	else
+		redis.call('DEL', KEYS[1], prev)
+	end
+	redis.call('HSET', KEYS[1], 'start', start)
+	return start
+end
+
+-- 读取hash中各行对应计数器的最小值
+local function estimate(hash, fields)
+	local values = redis.call('HMGET', hash, unpack(fields))
+	local est
+	for _, v in ipairs(values) do
+		local c = tonumber(v) or 0
+		if est == nil or c < est then
+			est = c
+		end
+	end
+	return est
+end
+
+-- 读取key在now所在窗口和上一个窗口的估算计数
+local function counts(now, window, idx)
+	local start = now - now % window
+	local last = tonumber(redis.call('HGET', KEYS[1], 'start'))
+	if last ~= start and last ~= start - window then
+		return start, 0, 0
+	end
+
+	local fields = {}
+	for _, i in ipairs(idx) do
+		table.insert(fields, 'c' .. i)
+	end
+	if last ~= start then
+		return start, 0, estimate(KEYS[1], fields)
+	end
+	return start, estimate(KEYS[1], fields), estimate(prev, fields)
+end
+`
+
+// windowScript 计数草图限流的Redis实现
+// ARGV: 当前时间(微秒), 窗口大小(微秒), 窗口内允许的最大请求数, 请求消耗的单位数, 计数器序号...
+// 返回: {是否允许, 需要等待的微秒数}
+var windowScript = store.NewScript(sketchLua + `
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+local n = tonumber(ARGV[4])
+local idx = {unpack(ARGV, 5)}
+
+local start = roll(now, window)
+local _, current, previous = counts(now, window, idx)
+
+-- 上一个窗口的计数按与滑动窗口的重叠比例加权
+local weight = 1 - (now - start) / window
+if previous * weight + current + n <= limit then
+	for _, i in ipairs(idx) do
+		redis.call('HINCRBY', KEYS[1], 'c' .. i, n)
+	end
+	-- 两个窗口之后计数不再有影响，可以过期
+	redis.call('PEXPIRE', KEYS[1], math.ceil((start + 2 * window - now) / 1e3) + 1)
+	return {1, 0}
+end
+
+-- 计算估算值降到限制以内的时间
+local at
+if current + n <= limit then
+	at = start + window * (1 - (limit - current - n) / previous)
+else
+	at = start + window + window * (1 - (limit - n) / current)
+end
+return {0, math.ceil(at - now)}
+`)
+
+// refundScript 扣减key对应计数器的Redis实现，优先扣减当前窗口
+// ARGV: 当前时间(微秒), 窗口大小(微秒), 归还的单位数, 计数器序号...
+// 返回: {是否归还}
+var refundScript = store.NewScript(sketchLua + `
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+local idx = {unpack(ARGV, 4)}
+
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return {0}
+end
+
+local start = roll(now, window)
+local _, current, previous = counts(now, window, idx)
+local fromCurrent = math.min(current, n)
+local fromPrevious = math.min(previous, n - fromCurrent)
+for _, i in ipairs(idx) do
+	if fromCurrent > 0 then
+		local c = tonumber(redis.call('HGET', KEYS[1], 'c' .. i)) or 0
+		redis.call('HSET', KEYS[1], 'c' .. i, math.max(0, c - fromCurrent))
+	end
+	if fromPrevious > 0 then
+		local p = tonumber(redis.call('HGET', prev, 'c' .. i)) or 0
+		redis.call('HSET', prev, 'c' .. i, math.max(0, p - fromPrevious))
+	end
+end
+redis.call('PEXPIRE', KEYS[1], math.ceil((start + 2 * window - now) / 1e3) + 1)
+return {1}
+`)
+
+// statusScript 查询估算计数的Redis实现，不修改状态
+// ARGV: 当前时间(微秒), 窗口大小(微秒), 窗口内允许的最大请求数, 计数器序号...
+// 返回: {剩余的单位数, 距离计数不再有影响的微秒数, 需要等待的微秒数}
+var statusScript = store.NewScript(sketchLua + `
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+local idx = {unpack(ARGV, 4)}
+
+local start, current, previous = counts(now, window, idx)
+
+-- 当前窗口的计数在下一个窗口结束后不再有影响，上一个窗口的计数在当前窗口结束后不再有影响
+local reset = 0
+if current > 0 then
+	reset = start + 2 * window - now
+elseif previous > 0 then
+	reset = start + window - now
+end
+
+local weight = 1 - (now - start) / window
+local estimate = previous * weight + current
+if estimate + 1 <= limit then
+	return {math.floor(limit - estimate), reset, 0}
+end
+
+-- 计算估算值降到限制以内的时间，与放行1个单位时的计算一致
+local at
+if current + 1 <= limit then
+	at = start + window * (1 - (limit - current - 1) / previous)
+else
+	at = start + window + window * (1 - (limit - 1) / current)
+end
+return {math.max(0, math.floor(limit - estimate)), reset, math.ceil(at - now)}
+`)
+
+// CountMinLimiter 实现基于计数草图（count-min sketch）的限流器
+// 与滑动窗口计数相同，按上一个窗口与滑动窗口的重叠比例加权估算窗口内的请求数，
+// 但所有key共用当前和上一个窗口两个固定大小的草图，内存占用与key的数量无关，适合有大量不同客户端的接口。
+// 草图只会高估计数，哈希冲突的key可能被提前限流，SketchWidth和SketchDepth控制精度与内存的取舍。
+// 同时用Space-Saving算法统计请求量最大的TopK个key
+type CountMinLimiter struct {
+	// 状态存储
+	store store.Store
+	// 时钟
+	clock algorithms.Clock
+	// 配置信息
+	config algorithms.Config
+	// 存储执行失败时的处理策略
+	failure algorithms.FailurePolicy
+	// 每行的计数器数量
+	width int
+	// 行数
+	depth int
+	// 请求量最大的key的统计
+	tracker *tracker
+}
+
+// NewLimiter 创建一个新的计数草图限流器
+func NewLimiter(config algorithms.Config, opts ...algorithms.Option) *CountMinLimiter {
+	o := algorithms.NewOptions(opts...)
+	l := &CountMinLimiter{
+		store:   o.Store,
+		clock:   o.Clock,
+		config:  config,
+		failure: o.Failure,
+		width:   config.SketchWidth,
+		depth:   config.SketchDepth,
+	}
+	if l.width <= 0 {
+		l.width = defaultWidth
+	}
+	if l.depth <= 0 {
+		l.depth = defaultDepth
+	}
+	topK := config.TopK
+	if topK <= 0 {
+		topK = defaultTopK
+	}
+	l.tracker = newTracker(topK, config.WindowSize)
+	return l
+}
+
+// Allow 实现RateLimiter接口
+func (l *CountMinLimiter) Allow(ctx context.Context, key string) (bool, time.Duration) {
+	return l.AllowN(ctx, key, 1)
+}
+
+// AllowN 实现RateLimiter接口
+func (l *CountMinLimiter) AllowN(ctx context.Context, key string, n int64) (bool, time.Duration) {
+	return l.allow(ctx, key, n, l.config.Limit)
+}
+
+// AllowNReserved 实现Prioritizer接口，按扣除保留容量后的限制判断
+func (l *CountMinLimiter) AllowNReserved(ctx context.Context, key string, n int64, reserve float64) (bool, time.Duration) {
+	return l.allow(ctx, key, n, l.config.Limit-l.config.Reserved(reserve))
+}
+
+// allow 按limit判断消耗n个单位的请求是否允许通过
+func (l *CountMinLimiter) allow(ctx context.Context, key string, n int64, limit int64) (bool, time.Duration) {
+	now := l.clock.Now()
+	// 被拒绝的请求同样计入请求量统计
+	l.tracker.record(key, n, now)
+	if n > limit {
+		return false, 0
+	}
+
+	idx := l.index(key)
+	res, err := l.store.Exec(ctx, sketchKey, store.Op{
+		Script: windowScript,
+		Args:   append([]interface{}{now.UnixMicro(), l.config.WindowSize.Microseconds(), limit, n}, idx...),
+		Apply: func(state interface{}) (interface{}, time.Time, []int64) {
+			s := l.roll(state, now)
+			// 两个窗口之后计数不再有影响
+			expireAt := s.start.Add(2 * l.config.WindowSize)
+			_, current, previous := l.counts(s, idx, now)
+
+			// 上一个窗口的计数按与滑动窗口的重叠比例加权
+			weight := 1 - float64(now.Sub(s.start))/float64(l.config.WindowSize)
+			threshold := float64(limit)
+			if float64(previous)*weight+float64(current+n) <= threshold {
+				for _, i := range idx {
+					s.current[i.(int)] += n
+				}
+				return s, expireAt, []int64{1, 0}
+			}
+
+			// 计算估算值降到限制以内的时间
+			var at time.Time
+			if current+n <= limit {
+				ratio := 1 - (threshold-float64(current+n))/float64(previous)
+				at = s.start.Add(time.Duration(ratio * float64(l.config.WindowSize)))
+			} else {
+				ratio := 1 - (threshold-float64(n))/float64(current)
+				at = s.start.Add(l.config.WindowSize + time.Duration(ratio*float64(l.config.WindowSize)))
+			}
+			return s, expireAt, []int64{0, store.Micros(at.Sub(now))}
+		},
+	})
+	if err != nil {
+		return l.failure.Allows("计数草图", key, err), 0
+	}
+
+	return res[0] == 1, store.Duration(res[1])
+}
+
+// RefundN 实现Refunder接口，优先扣减当前窗口的计数，不足时扣减上一个窗口
+// 扣减量不超过key的估算计数，避免扣减与其共用计数器的其他key的计数
+func (l *CountMinLimiter) RefundN(ctx context.Context, key string, n int64) {
+	now := l.clock.Now()
+	idx := l.index(key)
+	_, err := l.store.Exec(ctx, sketchKey, store.Op{
+		Script: refundScript,
+		Args:   append([]interface{}{now.UnixMicro(), l.config.WindowSize.Microseconds(), n}, idx...),
+		Apply: func(state interface{}) (interface{}, time.Time, []int64) {
+			if state == nil {
+				return nil, time.Time{}, []int64{0}
+			}
+
+			s := l.roll(state, now)
+			_, current, previous := l.counts(s, idx, now)
+			fromCurrent := min(current, n)
+			fromPrevious := min(previous, n-fromCurrent)
+			for _, i := range idx {
+				s.current[i.(int)] = max(0, s.current[i.(int)]-fromCurrent)
+				s.previous[i.(int)] = max(0, s.previous[i.(int)]-fromPrevious)
+			}
+			return s, s.start.Add(2 * l.config.WindowSize), []int64{1}
+		},
+	})
+	if err != nil {
+		log.Printf("计数草图归还配额失败: key=%s, error=%v", key, err)
+	}
+}
+
+// Status 实现RateLimiter接口
+func (l *CountMinLimiter) Status(ctx context.Context, key string) algorithms.Status {
+	now := l.clock.Now()
+	idx := l.index(key)
+	res, err := l.store.Exec(ctx, sketchKey, store.Op{
+		Script: statusScript,
+		Args:   append([]interface{}{now.UnixMicro(), l.config.WindowSize.Microseconds(), l.config.Limit}, idx...),
+		Apply: func(state interface{}) (interface{}, time.Time, []int64) {
+			// 只读取状态，保持原有的失效时间
+			var expireAt time.Time
+			s, exists := state.(*sketch)
+			if exists {
+				expireAt = s.start.Add(2 * l.config.WindowSize)
+			}
+			start, current, previous := l.counts(s, idx, now)
+
+			// 当前窗口的计数在下一个窗口结束后不再有影响，上一个窗口的计数在当前窗口结束后不再有影响
+			var reset int64
+			if current > 0 {
+				reset = store.Micros(start.Add(2 * l.config.WindowSize).Sub(now))
+			} else if previous > 0 {
+				reset = store.Micros(start.Add(l.config.WindowSize).Sub(now))
+			}
+
+			weight := 1 - float64(now.Sub(start))/float64(l.config.WindowSize)
+			limit := float64(l.config.Limit)
+			estimate := float64(previous)*weight + float64(current)
+			remaining := int64(math.Max(0, math.Floor(limit-estimate)))
+			if estimate+1 <= limit {
+				return state, expireAt, []int64{remaining, reset, 0}
+			}
+
+			// 计算估算值降到限制以内的时间，与放行1个单位时的计算一致
+			var at time.Time
+			if current+1 <= l.config.Limit {
+				ratio := 1 - (limit-float64(current+1))/float64(previous)
+				at = start.Add(time.Duration(ratio * float64(l.config.WindowSize)))
+			} else {
+				ratio := 1 - (limit-1)/float64(current)
+				at = start.Add(l.config.WindowSize + time.Duration(ratio*float64(l.config.WindowSize)))
+			}
+			return state, expireAt, []int64{remaining, reset, store.Micros(at.Sub(now))}
+		},
+	})
+	if err != nil {
+		log.Printf("计数草图查询状态失败: key=%s, error=%v", key, err)
+		return algorithms.Status{Limit: l.config.Limit, Remaining: l.config.Limit, Reset: now}
+	}
+
+	return algorithms.Status{
+		Limit:     l.config.Limit,
+		Remaining: res[0],
+		Reset:     now.Add(store.Duration(res[1])),
+		Wait:      store.Duration(res[2]),
+	}
+}
+
+// TopK 实现HeavyHitterReporter接口
+// 请求量在本网关实例内统计，使用Redis存储时不包括其他网关副本的请求
+func (l *CountMinLimiter) TopK(ctx context.Context) []algorithms.HeavyHitter {
+	return l.tracker.report(l.clock.Now())
+}
+
+// Clock 实现Clocked接口
+func (l *CountMinLimiter) Clock() algorithms.Clock {
+	return l.clock
+}
+
+// Close 实现RateLimiter接口
+func (l *CountMinLimiter) Close() error {
+	return l.store.Close()
+}
+
+// index 返回key在每一行对应的计数器序号
+// 使用FNV-1a的双重哈希，各网关副本计算的序号相同，可以共享Redis中的草图
+func (l *CountMinLimiter) index(key string) []interface{} {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := uint32(sum), uint32(sum>>32)|1
+
+	idx := make([]interface{}, l.depth)
+	for row := range idx {
+		idx[row] = row*l.width + int((h1+uint32(row)*h2)%uint32(l.width))
+	}
+	return idx
+}
+
+// roll 返回滚动到now所在窗口的草图，不存在时新建
+func (l *CountMinLimiter) roll(state interface{}, now time.Time) *sketch {
+	start := l.windowStart(now)
+	s, exists := state.(*sketch)
+
+	switch {
+	case !exists:
+		size := l.width * l.depth
+		return &sketch{start: start, current: make([]int64, size), previous: make([]int64, size)}
+	case s.start.Equal(start):
+	case s.start.Add(l.config.WindowSize).Equal(start):
+		// 复用上一个窗口的计数器，避免每个窗口重新分配
+		s.current, s.previous = s.previous, s.current
+		clear(s.current)
+		s.start = start
+	default:
+		clear(s.current)
+		clear(s.previous)
+		s.start = start
+	}
+	return s
+}
+
+// counts 返回now所在窗口的起始时间，以及key在该窗口和上一个窗口的估算计数，不修改草图
+func (l *CountMinLimiter) counts(s *sketch, idx []interface{}, now time.Time) (time.Time, int64, int64) {
+	start := l.windowStart(now)
+	if s == nil {
+		return start, 0, 0
+	}
+
+	var current, previous []int64
+	switch {
+	case s.start.Equal(start):
+		current, previous = s.current, s.previous
+	case s.start.Add(l.config.WindowSize).Equal(start):
+		previous = s.current
+	default:
+		return start, 0, 0
+	}
+	return start, estimate(current, idx), estimate(previous, idx)
+}
+
+// windowStart 返回now所在窗口的起始时间，窗口按Unix时间对齐，与Redis实现保持一致
+func (l *CountMinLimiter) windowStart(now time.Time) time.Time {
+	n := now.UnixNano()
+	return time.Unix(0, n-n%int64(l.config.WindowSize))
+}
+
+// estimate 返回各行对应计数器的最小值，即计数的估算值
+func estimate(counters []int64, idx []interface{}) int64 {
+	if counters == nil {
+		return 0
+	}
+
+	var est int64 = math.MaxInt64
+	for _, i := range idx {
+		est = min(est, counters[i.(int)])
+	}
+	return est
+}
@@ -0,0 +1,167 @@
+package countmin
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/wureny/FluxGo/internal/algorithms"
+)
+
+// Space-Saving算法的计数
+type counter struct {
+	count int64 // 估算的请求单位数
+	err   int64 // 估算值可能多出的上限，即替换时被淘汰的计数
+}
+
+// entry 一个key的计数，保存在按计数排列的最小堆中
+type entry struct {
+	counter
+	key   string
+	index int // 在堆中的位置
+}
+
+// counters 按计数排列的最小堆，堆顶为计数最小的key
+type counters []*entry
+
+// Len 实现heap.Interface接口
+func (h counters) Len() int { return len(h) }
+
+// Less 实现heap.Interface接口
+func (h counters) Less(i, j int) bool { return h[i].count < h[j].count }
+
+// Swap 实现heap.Interface接口
+func (h counters) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+// Push 实现heap.Interface接口
+func (h *counters) Push(x interface{}) {
+	e := x.(*entry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+// Pop 实现heap.Interface接口
+func (h *counters) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+// tracker 用Space-Saving算法统计请求量最大的key
+// 每个窗口最多保存k个计数，满了以后新key替换计数最小的key并继承其计数，
+// 请求量超过窗口内总量1/k的key一定会被统计到。与草图相同按相邻两个窗口加权估算滑动窗口内的请求量
+// 计数保存在进程内存中，不经过状态存储，草图保存在Redis时每个网关副本也只统计自己收到的请求
+// 计数按最小堆排列，每次记录的开销为O(log k)
+type tracker struct {
+	mu sync.Mutex
+	// 统计的key数量
+	k int
+	// 窗口大小
+	window time.Duration
+	// 当前窗口的起始时间
+	start time.Time
+	// 当前窗口的计数
+	current map[string]*entry
+	// 当前窗口的计数按计数排列的最小堆
+	heap counters
+	// 上一个窗口的计数
+	previous map[string]*entry
+}
+
+// newTracker 创建统计k个key的tracker
+func newTracker(k int, window time.Duration) *tracker {
+	return &tracker{
+		k:       k,
+		window:  window,
+		current: make(map[string]*entry, k),
+		heap:    make(counters, 0, k),
+	}
+}
+
+// record 记录key的n个单位的请求
+func (t *tracker) record(key string, n int64, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.roll(now)
+
+	if e, exists := t.current[key]; exists {
+		e.count += n
+		heap.Fix(&t.heap, e.index)
+		return
+	}
+	if len(t.heap) < t.k {
+		e := &entry{counter: counter{count: n}, key: key}
+		heap.Push(&t.heap, e)
+		t.current[key] = e
+		return
+	}
+
+	// 替换计数最小的key，即堆顶
+	e := t.heap[0]
+	delete(t.current, e.key)
+	e.key, e.counter = key, counter{count: e.count + n, err: e.count}
+	t.current[key] = e
+	heap.Fix(&t.heap, 0)
+}
+
+// report 返回滑动窗口内请求量最大的key，按估算值从大到小排列
+func (t *tracker) report(now time.Time) []algorithms.HeavyHitter {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.roll(now)
+
+	// 上一个窗口的计数按与滑动窗口的重叠比例加权
+	weight := 1 - float64(now.Sub(t.start))/float64(t.window)
+	merged := make(map[string]counter, len(t.current)+len(t.previous))
+	for k, c := range t.previous {
+		merged[k] = counter{
+			count: int64(math.Round(float64(c.count) * weight)),
+			err:   int64(math.Round(float64(c.err) * weight)),
+		}
+	}
+	for k, c := range t.current {
+		m := merged[k]
+		merged[k] = counter{count: m.count + c.count, err: m.err + c.err}
+	}
+
+	hitters := make([]algorithms.HeavyHitter, 0, len(merged))
+	for k, c := range merged {
+		if c.count > 0 {
+			hitters = append(hitters, algorithms.HeavyHitter{Key: k, Count: c.count, Error: c.err})
+		}
+	}
+	sort.Slice(hitters, func(i, j int) bool {
+		if hitters[i].Count != hitters[j].Count {
+			return hitters[i].Count > hitters[j].Count
+		}
+		return hitters[i].Key < hitters[j].Key
+	})
+	if len(hitters) > t.k {
+		hitters = hitters[:t.k]
+	}
+	return hitters
+}
+
+// roll 滚动到now所在的窗口
+func (t *tracker) roll(now time.Time) {
+	n := now.UnixNano()
+	start := time.Unix(0, n-n%int64(t.window))
+	switch {
+	case t.start.Equal(start):
+		return
+	case t.start.Add(t.window).Equal(start):
+		t.previous = t.current
+	default:
+		t.previous = nil
+	}
+	t.current = make(map[string]*entry, t.k)
+	t.heap = make(counters, 0, t.k)
+	t.start = start
+}
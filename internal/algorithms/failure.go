@@ -0,0 +1,29 @@
+package algorithms
+
+import "log"
+
+// FailurePolicy 状态存储执行失败时的处理策略
+type FailurePolicy string
+
+const (
+	// FailOpen 放行请求，避免限流组件故障导致业务整体不可用
+	FailOpen FailurePolicy = "open"
+	// FailClosed 拒绝请求，用于必须严格执行配额的场景，例如按调用次数计费的接口
+	FailClosed FailurePolicy = "closed"
+)
+
+// Allows 记录存储执行失败的原因，并按策略返回是否放行请求，为空时等价于FailOpen
+// name为日志中的限流器名称
+func (p FailurePolicy) Allows(name string, key string, err error) bool {
+	if p == FailClosed {
+		log.Printf("%s存储执行失败，拒绝请求: key=%s, error=%v", name, key, err)
+		return false
+	}
+	log.Printf("%s存储执行失败，放行请求: key=%s, error=%v", name, key, err)
+	return true
+}
+
+// Valid 判断是否为支持的策略
+func (p FailurePolicy) Valid() bool {
+	return p == "" || p == FailOpen || p == FailClosed
+}
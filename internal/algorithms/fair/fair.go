@@ -0,0 +1,369 @@
+package fair
+
+import (
+	"context"
+	"log"
+	"math"
+	"time"
+
+	"github.com/wureny/FluxGo/internal/algorithms"
+	"github.com/wureny/FluxGo/internal/store"
+)
+
+// sharesKey 保存当前窗口用量的key，全局上限需要在一次原子操作中看到所有key的合计用量
+const sharesKey = "*"
+
+// window 一个窗口内各key的用量
+type window struct {
+	start  time.Time        // 窗口的起始时间
+	counts map[string]int64 // 窗口内放行过请求的key的用量
+	total  int64            // 所有key的合计用量
+}
+
+// shares 当前和上一个窗口的用量
+type shares struct {
+	current  window
+	previous window // 上一个窗口，只用于判断key是否活跃
+	carried  int    // 当前窗口中上一个窗口也活跃的key数
+}
+
+// summary 判断一个key需要的汇总信息
+type summary struct {
+	total  int64 // 当前窗口所有key的合计用量
+	active int   // 活跃key数，包括正在判断的key
+	mine   int64 // 正在判断的key在当前窗口的用量
+}
+
+// sharesLua 读取用量和计算公平份额，供各脚本共用
+// 当前窗口的用量保存在KEYS[1]的hash中：start为窗口的起始时间，total为合计用量，active为放行过请求的key数，
+// carried为其中上一个窗口也活跃的key数，c:<key>为各key的用量。窗口切换时整个hash改名为KEYS[1]:prev，
+// 作为上一个窗口的用量，因此每次判断只读写固定数量的字段
+var sharesLua = `
+local prev = KEYS[1] .. ':prev'
+
+-- 读取now所在窗口的汇总，不修改状态
+-- 返回窗口起始时间、合计用量、活跃key数（包括key自己）、key的用量、key是否已经在当前窗口，以及窗口切换的方式
+local function load(now, window, key)
+	local start = now - now % window
+	local state = redis.call('HMGET', KEYS[1], 'start', 'total', 'active', 'carried', 'c:' .. key)
+	local last = tonumber(state[1])
+
+	if last == start then
+		local mine = tonumber(state[5])
+		local previous = tonumber(redis.call('HGET', prev, 'active')) or 0
+		local active = tonumber(state[3]) + previous - tonumber(state[4])
+		if mine == nil and redis.call('HEXISTS', prev, 'c:' .. key) == 0 then
+			active = active + 1
+		end
+		return start, tonumber(state[2]), active, mine or 0, mine ~= nil, nil
+	end
+
+	if last == start - window then
+		-- 当前窗口的用量将成为上一个窗口的用量
+		local active = tonumber(state[3])
+		if state[5] == false then
+			active = active + 1
+		end
+		return start, 0, active, 0, false, 'rename'
+	end
+	return start, 0, 1, 0, false, 'reset'
+end
+
+-- key还可以使用的单位数
+-- 每个活跃key保证可以使用global/活跃key数，其他key的合计用量低于它们合计的保证份额时，差额不能被借用
+local function available(total, active, mine, limit, global)
+	local share = global / active
+	local owed = math.max(0, share * (active - 1) - (total - mine))
+	return math.min(limit - mine, global - total, math.max(share - mine, global - total - owed))
+end
+`
+
+// allowScript 公平分配的Redis实现
+// ARGV: 当前时间(微秒), 窗口大小(微秒), 每个key的上限, 全局上限, 请求的key, 请求消耗的单位数
+// 返回: {是否允许, 需要等待的微秒数}
+var allowScript = store.NewScript(sharesLua + `
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+local global = tonumber(ARGV[4])
+local key = ARGV[5]
+local n = tonumber(ARGV[6])
+
+local start, total, active, mine, seen, roll = load(now, window, key)
+if n > available(total, active, mine, limit, global) then
+	-- 被拒绝的请求不修改状态，被拒绝的key不会成为活跃key
+	return {0, start + window - now}
+end
+
+if roll == 'rename' then
+	redis.call('RENAME', KEYS[1], prev)
+	redis.call('PEXPIRE', prev, math.ceil((start + window - now) / 1e3) + 1)
+elseif roll == 'reset' then
+	redis.call('DEL', KEYS[1], prev)
+end
+if roll then
+	redis.call('HSET', KEYS[1], 'start', start, 'total', 0, 'active', 0, 'carried', 0)
+end
+
+if not seen then
+	redis.call('HINCRBY', KEYS[1], 'active', 1)
+	if redis.call('HEXISTS', prev, 'c:' .. key) == 1 then
+		redis.call('HINCRBY', KEYS[1], 'carried', 1)
+	end
+end
+redis.call('HINCRBY', KEYS[1], 'c:' .. key, n)
+redis.call('HINCRBY', KEYS[1], 'total', n)
+redis.call('PEXPIRE', KEYS[1], math.ceil((start + 2 * window - now) / 1e3) + 1)
+return {1, 0}
+`)
+
+// refundScript 扣减key在当前窗口用量的Redis实现
+// ARGV: 当前时间(微秒), 窗口大小(微秒), 归还的key, 归还的单位数
+// 返回: {是否归还}
+var refundScript = store.NewScript(sharesLua + `
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local key = ARGV[3]
+local n = tonumber(ARGV[4])
+
+-- 窗口已经切换时用量已经清零
+local start, total, active, mine, seen = load(now, window, key)
+if not seen then
+	return {0}
+end
+
+-- 归还后key仍然是活跃的
+local refunded = math.min(mine, n)
+redis.call('HINCRBY', KEYS[1], 'c:' .. key, -refunded)
+redis.call('HINCRBY', KEYS[1], 'total', -refunded)
+return {1}
+`)
+
+// statusScript 查询key可用单位数的Redis实现，不修改状态
+// ARGV: 当前时间(微秒), 窗口大小(微秒), 每个key的上限, 全局上限, 查询的key
+// 返回: {剩余的单位数, 距离窗口结束的微秒数, 需要等待的微秒数}
+var statusScript = store.NewScript(sharesLua + `
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+local global = tonumber(ARGV[4])
+local key = ARGV[5]
+
+local start, total, active, mine = load(now, window, key)
+local remaining = math.max(0, math.floor(available(total, active, mine, limit, global)))
+
+local reset = 0
+if total > 0 then
+	reset = start + window - now
+end
+if remaining < 1 then
+	return {0, reset, start + window - now}
+end
+return {remaining, reset, 0}
+`)
+
+// FairLimiter 实现在key之间公平分配全局上限的限流器
+// GlobalLimit为每个窗口所有key合计的上限，例如上游最多承受500 rps；Limit为每个key的上限，
+// 与GlobalLimit相同时不单独限制key。按最大最小公平分配：当前和上一个窗口内有请求的key为活跃key，
+// 每个活跃key保证可以使用GlobalLimit/活跃key数，其他key没有用满的份额可以被借用，
+// 因此单个重度客户端不能占满全局上限，没有竞争时又可以使用全部容量
+// 被拒绝的请求不计入用量，也不会让key成为活跃key，因此每个窗口的活跃key数不超过GlobalLimit
+// 窗口按Unix时间对齐，状态中维护合计用量和活跃key数，每次判断的开销与活跃key数无关，
+// 其他key还没有用到的保证份额按它们的合计用量估算
+type FairLimiter struct {
+	// 状态存储
+	store store.Store
+	// 时钟
+	clock algorithms.Clock
+	// 配置信息
+	config algorithms.Config
+	// 存储执行失败时的处理策略
+	failure algorithms.FailurePolicy
+}
+
+// NewLimiter 创建一个新的公平分配限流器
+func NewLimiter(config algorithms.Config, opts ...algorithms.Option) *FairLimiter {
+	o := algorithms.NewOptions(opts...)
+	return &FairLimiter{
+		store:   o.Store,
+		clock:   o.Clock,
+		config:  config,
+		failure: o.Failure,
+	}
+}
+
+// Allow 实现RateLimiter接口
+func (l *FairLimiter) Allow(ctx context.Context, key string) (bool, time.Duration) {
+	return l.AllowN(ctx, key, 1)
+}
+
+// AllowN 实现RateLimiter接口
+// 超过公平份额并且没有可以借用的容量时拒绝，等到下一个窗口重新分配
+func (l *FairLimiter) AllowN(ctx context.Context, key string, n int64) (bool, time.Duration) {
+	return l.allow(ctx, key, n, l.config.Limit, l.config.GlobalLimit)
+}
+
+// AllowNReserved 实现Prioritizer接口，key的上限和全局上限都按reserve比例保留
+func (l *FairLimiter) AllowNReserved(ctx context.Context, key string, n int64, reserve float64) (bool, time.Duration) {
+	globalLimit := l.config.GlobalLimit - int64(math.Round(float64(l.config.GlobalLimit)*reserve))
+	return l.allow(ctx, key, n, l.config.Limit-l.config.Reserved(reserve), globalLimit)
+}
+
+// allow 按key的上限limit和全局上限globalLimit判断消耗n个单位的请求是否允许通过
+func (l *FairLimiter) allow(ctx context.Context, key string, n int64, limit int64, globalLimit int64) (bool, time.Duration) {
+	if n > limit || n > globalLimit {
+		return false, 0
+	}
+	if n <= 0 {
+		// 不消耗单位的请求不会让key成为活跃key
+		return true, 0
+	}
+
+	now := l.clock.Now()
+	res, err := l.store.Exec(ctx, sharesKey, store.Op{
+		Script: allowScript,
+		Args:   []interface{}{now.UnixMicro(), l.config.WindowSize.Microseconds(), limit, globalLimit, key, n},
+		Apply: func(state interface{}) (interface{}, time.Time, []int64) {
+			s := l.roll(state, now)
+			if float64(n) > available(s.summarize(key), limit, globalLimit) {
+				// 被拒绝的请求不修改状态，被拒绝的key不会成为活跃key
+				end := s.current.start.Add(l.config.WindowSize)
+				return state, l.expireAt(state), []int64{0, store.Micros(end.Sub(now))}
+			}
+
+			if _, seen := s.current.counts[key]; !seen {
+				if _, active := s.previous.counts[key]; active {
+					s.carried++
+				}
+			}
+			s.current.counts[key] += n
+			s.current.total += n
+			return s, l.expireAt(s), []int64{1, 0}
+		},
+	})
+	if err != nil {
+		return l.failure.Allows("公平分配", key, err), 0
+	}
+
+	return res[0] == 1, store.Duration(res[1])
+}
+
+// RefundN 实现Refunder接口，扣减key在当前窗口的用量
+func (l *FairLimiter) RefundN(ctx context.Context, key string, n int64) {
+	now := l.clock.Now()
+	_, err := l.store.Exec(ctx, sharesKey, store.Op{
+		Script: refundScript,
+		Args:   []interface{}{now.UnixMicro(), l.config.WindowSize.Microseconds(), key, n},
+		Apply: func(state interface{}) (interface{}, time.Time, []int64) {
+			// 窗口已经切换时用量已经清零，保持原有状态
+			s := l.roll(state, now)
+			count, seen := s.current.counts[key]
+			if s != state || !seen {
+				return state, l.expireAt(state), []int64{0}
+			}
+
+			// 归还后key仍然是活跃的
+			refunded := min(count, n)
+			s.current.counts[key] -= refunded
+			s.current.total -= refunded
+			return s, l.expireAt(s), []int64{1}
+		},
+	})
+	if err != nil {
+		log.Printf("公平分配归还配额失败: key=%s, error=%v", key, err)
+	}
+}
+
+// Status 实现RateLimiter接口，剩余配额为key当前可以使用的单位数，包括可以借用的部分
+func (l *FairLimiter) Status(ctx context.Context, key string) algorithms.Status {
+	now := l.clock.Now()
+	capacity := min(l.config.Limit, l.config.GlobalLimit)
+	res, err := l.store.Exec(ctx, sharesKey, store.Op{
+		Script: statusScript,
+		Args:   []interface{}{now.UnixMicro(), l.config.WindowSize.Microseconds(), l.config.Limit, l.config.GlobalLimit, key},
+		Apply: func(state interface{}) (interface{}, time.Time, []int64) {
+			// 只读取状态，保持原有的失效时间
+			s := l.roll(state, now)
+			end := store.Micros(s.current.start.Add(l.config.WindowSize).Sub(now))
+
+			sum := s.summarize(key)
+			remaining := int64(math.Max(0, math.Floor(available(sum, l.config.Limit, l.config.GlobalLimit))))
+			var reset int64
+			if sum.total > 0 {
+				reset = end
+			}
+			if remaining < 1 {
+				return state, l.expireAt(state), []int64{0, reset, end}
+			}
+			return state, l.expireAt(state), []int64{remaining, reset, 0}
+		},
+	})
+	if err != nil {
+		log.Printf("公平分配查询状态失败: key=%s, error=%v", key, err)
+		return algorithms.Status{Limit: capacity, Remaining: capacity, Reset: now}
+	}
+
+	return algorithms.Status{
+		Limit:     capacity,
+		Remaining: res[0],
+		Reset:     now.Add(store.Duration(res[1])),
+		Wait:      store.Duration(res[2]),
+	}
+}
+
+// Clock 实现Clocked接口
+func (l *FairLimiter) Clock() algorithms.Clock {
+	return l.clock
+}
+
+// Close 实现RateLimiter接口
+func (l *FairLimiter) Close() error {
+	return l.store.Close()
+}
+
+// roll 返回now所在窗口的用量，窗口切换时当前窗口的用量变为上一个窗口的用量
+// 窗口没有切换时返回原有的状态，否则返回新的状态，原有的状态保持不变
+func (l *FairLimiter) roll(state interface{}, now time.Time) *shares {
+	// 窗口按Unix时间对齐，与Redis实现保持一致
+	n := now.UnixNano()
+	start := time.Unix(0, n-n%int64(l.config.WindowSize))
+	s, exists := state.(*shares)
+
+	switch {
+	case exists && s.current.start.Equal(start):
+		return s
+	case exists && s.current.start.Add(l.config.WindowSize).Equal(start):
+		return &shares{current: window{start: start, counts: make(map[string]int64)}, previous: s.current}
+	default:
+		return &shares{current: window{start: start, counts: make(map[string]int64)}}
+	}
+}
+
+// expireAt 返回状态的失效时间，当前窗口的用量在下一个窗口结束后不再需要
+func (l *FairLimiter) expireAt(state interface{}) time.Time {
+	s, exists := state.(*shares)
+	if !exists {
+		return time.Time{}
+	}
+	return s.current.start.Add(2 * l.config.WindowSize)
+}
+
+// summarize 返回判断key需要的汇总信息
+func (s *shares) summarize(key string) summary {
+	active := len(s.current.counts) + len(s.previous.counts) - s.carried
+	_, seen := s.current.counts[key]
+	if _, inPrevious := s.previous.counts[key]; !seen && !inPrevious {
+		active++
+	}
+	return summary{total: s.current.total, active: active, mine: s.current.counts[key]}
+}
+
+// available 返回key还可以使用的单位数
+// 每个活跃key保证可以使用globalLimit/活跃key数，其他key的合计用量低于它们合计的保证份额时，差额不能被借用
+func available(sum summary, limit int64, globalLimit int64) float64 {
+	share := float64(globalLimit) / float64(sum.active)
+	owed := math.Max(0, share*float64(sum.active-1)-float64(sum.total-sum.mine))
+	mine := float64(sum.mine)
+	free := float64(globalLimit - sum.total)
+	return math.Min(math.Min(float64(limit)-mine, free), math.Max(share-mine, free-owed))
+}
@@ -0,0 +1,238 @@
+package fixedwindow
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/wureny/FluxGo/internal/algorithms"
+	"github.com/wureny/FluxGo/internal/store"
+)
+
+// 窗口计数记录
+type windowCount struct {
+	count     int64     // 当前窗口的请求计数
+	timestamp time.Time // 窗口的起始时间
+}
+
+// windowScript 窗口计数的Redis实现
+// ARGV: 当前时间(微秒), 窗口大小(微秒), 窗口内允许的最大请求数, 请求消耗的单位数
+// 返回: {是否允许, 需要等待的微秒数}
+var windowScript = store.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+local n = tonumber(ARGV[4])
+
+local state = redis.call('HMGET', KEYS[1], 'count', 'start')
+local count = tonumber(state[1])
+local start = tonumber(state[2])
+
+-- 如果窗口不存在或已过期，创建新窗口
+if count == nil or now - start >= window then
+	redis.call('HSET', KEYS[1], 'count', n, 'start', now)
+	redis.call('PEXPIRE', KEYS[1], math.ceil(window / 1e3) + 1)
+	return {1, 0}
+end
+
+-- 计算当前请求数量是否超过限制
+if count + n > limit then
+	return {0, start + window - now}
+end
+
+redis.call('HINCRBY', KEYS[1], 'count', n)
+return {1, 0}
+`)
+
+// refundScript 扣减窗口计数的Redis实现
+// ARGV: 当前时间(微秒), 窗口大小(微秒), 归还的单位数
+// 返回: {是否归还}
+var refundScript = store.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+
+local state = redis.call('HMGET', KEYS[1], 'count', 'start')
+local count = tonumber(state[1])
+local start = tonumber(state[2])
+
+-- 窗口不存在或已过期时计数已经清零
+if count == nil or now - start >= window then
+	return {0}
+end
+
+redis.call('HSET', KEYS[1], 'count', math.max(0, count - n))
+return {1}
+`)
+
+// statusScript 查询窗口计数的Redis实现，不修改状态
+// ARGV: 当前时间(微秒), 窗口大小(微秒), 窗口内允许的最大请求数
+// 返回: {剩余的单位数, 距离窗口结束的微秒数, 需要等待的微秒数}
+var statusScript = store.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+
+local state = redis.call('HMGET', KEYS[1], 'count', 'start')
+local count = tonumber(state[1])
+local start = tonumber(state[2])
+
+-- 窗口不存在或已过期时等价于新key
+if count == nil or now - start >= window then
+	return {limit, 0, 0}
+end
+
+local remaining = math.max(0, limit - count)
+local reset = start + window - now
+if remaining == 0 then
+	return {0, reset, reset}
+end
+return {remaining, reset, 0}
+`)
+
+// FixedWindowLimiter 实现基于固定窗口计数的限流器
+// 窗口从key的第一个请求开始计时，窗口边界前后最多可能放行2倍Limit的请求
+type FixedWindowLimiter struct {
+	// 状态存储
+	store store.Store
+	// 时钟
+	clock algorithms.Clock
+	// 配置信息
+	config algorithms.Config
+	// 存储执行失败时的处理策略
+	failure algorithms.FailurePolicy
+}
+
+// NewLimiter 创建一个新的固定窗口计数限流器
+func NewLimiter(config algorithms.Config, opts ...algorithms.Option) *FixedWindowLimiter {
+	o := algorithms.NewOptions(opts...)
+	return &FixedWindowLimiter{
+		store:   o.Store,
+		clock:   o.Clock,
+		config:  config,
+		failure: o.Failure,
+	}
+}
+
+// Allow 实现RateLimiter接口
+func (l *FixedWindowLimiter) Allow(ctx context.Context, key string) (bool, time.Duration) {
+	return l.AllowN(ctx, key, 1)
+}
+
+// AllowN 实现RateLimiter接口
+func (l *FixedWindowLimiter) AllowN(ctx context.Context, key string, n int64) (bool, time.Duration) {
+	return l.allow(ctx, key, n, l.config.Limit)
+}
+
+// AllowNReserved 实现Prioritizer接口，按扣除保留容量后的限制判断
+func (l *FixedWindowLimiter) AllowNReserved(ctx context.Context, key string, n int64, reserve float64) (bool, time.Duration) {
+	return l.allow(ctx, key, n, l.config.Limit-l.config.Reserved(reserve))
+}
+
+// allow 按limit判断消耗n个单位的请求是否允许通过
+func (l *FixedWindowLimiter) allow(ctx context.Context, key string, n int64, limit int64) (bool, time.Duration) {
+	if n > limit {
+		return false, 0
+	}
+
+	now := l.clock.Now()
+	res, err := l.store.Exec(ctx, key, store.Op{
+		Script: windowScript,
+		Args:   []interface{}{now.UnixMicro(), l.config.WindowSize.Microseconds(), limit, n},
+		Apply: func(state interface{}) (interface{}, time.Time, []int64) {
+			window, exists := state.(windowCount)
+
+			// 如果窗口不存在或已过期，创建新窗口
+			if !exists || now.Sub(window.timestamp) >= l.config.WindowSize {
+				return windowCount{count: n, timestamp: now}, now.Add(l.config.WindowSize), []int64{1, 0}
+			}
+
+			// 计算当前请求数量是否超过限制
+			if window.count+n > limit {
+				waitDuration := window.timestamp.Add(l.config.WindowSize).Sub(now)
+				return window, window.timestamp.Add(l.config.WindowSize), []int64{0, store.Micros(waitDuration)}
+			}
+
+			// 更新计数
+			window.count += n
+			return window, window.timestamp.Add(l.config.WindowSize), []int64{1, 0}
+		},
+	})
+	if err != nil {
+		return l.failure.Allows("固定窗口计数", key, err), 0
+	}
+
+	return res[0] == 1, store.Duration(res[1])
+}
+
+// RefundN 实现Refunder接口，扣减当前窗口的计数
+func (l *FixedWindowLimiter) RefundN(ctx context.Context, key string, n int64) {
+	now := l.clock.Now()
+	_, err := l.store.Exec(ctx, key, store.Op{
+		Script: refundScript,
+		Args:   []interface{}{now.UnixMicro(), l.config.WindowSize.Microseconds(), n},
+		Apply: func(state interface{}) (interface{}, time.Time, []int64) {
+			window, exists := state.(windowCount)
+
+			// 窗口不存在或已过期时计数已经清零
+			if !exists || now.Sub(window.timestamp) >= l.config.WindowSize {
+				return nil, time.Time{}, []int64{0}
+			}
+
+			window.count -= n
+			if window.count < 0 {
+				window.count = 0
+			}
+			return window, window.timestamp.Add(l.config.WindowSize), []int64{1}
+		},
+	})
+	if err != nil {
+		log.Printf("固定窗口计数归还配额失败: key=%s, error=%v", key, err)
+	}
+}
+
+// Status 实现RateLimiter接口
+func (l *FixedWindowLimiter) Status(ctx context.Context, key string) algorithms.Status {
+	now := l.clock.Now()
+	res, err := l.store.Exec(ctx, key, store.Op{
+		Script: statusScript,
+		Args:   []interface{}{now.UnixMicro(), l.config.WindowSize.Microseconds(), l.config.Limit},
+		Apply: func(state interface{}) (interface{}, time.Time, []int64) {
+			window, exists := state.(windowCount)
+
+			// 窗口不存在或已过期时等价于新key
+			if !exists || now.Sub(window.timestamp) >= l.config.WindowSize {
+				return nil, time.Time{}, []int64{l.config.Limit, 0, 0}
+			}
+
+			end := window.timestamp.Add(l.config.WindowSize)
+			reset := store.Micros(end.Sub(now))
+			remaining := l.config.Limit - window.count
+			if remaining <= 0 {
+				return window, end, []int64{0, reset, reset}
+			}
+			return window, end, []int64{remaining, reset, 0}
+		},
+	})
+	if err != nil {
+		log.Printf("固定窗口计数查询状态失败: key=%s, error=%v", key, err)
+		return algorithms.Status{Limit: l.config.Limit, Remaining: l.config.Limit, Reset: now}
+	}
+
+	return algorithms.Status{
+		Limit:     l.config.Limit,
+		Remaining: res[0],
+		Reset:     now.Add(store.Duration(res[1])),
+		Wait:      store.Duration(res[2]),
+	}
+}
+
+// Clock 实现Clocked接口
+func (l *FixedWindowLimiter) Clock() algorithms.Clock {
+	return l.clock
+}
+
+// Close 实现RateLimiter接口
+func (l *FixedWindowLimiter) Close() error {
+	return l.store.Close()
+}
@@ -0,0 +1,267 @@
+package gcra
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/wureny/FluxGo/internal/algorithms"
+	"github.com/wureny/FluxGo/internal/store"
+)
+
+// gcraScript GCRA的Redis实现，每个key只保存一个理论到达时间(TAT)
+// ARGV: 当前时间(微秒), 发射间隔(微秒，可以有小数), 窗口大小(微秒), 请求消耗的单位数, 是否预留
+// 预留时理论到达时间可以领先超过一个窗口，等待回落后再执行请求
+// 返回: {是否允许, 需要等待的微秒数}
+var gcraScript = store.NewScript(`
+local now = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local window = tonumber(ARGV[3])
+local n = tonumber(ARGV[4])
+local reserve = ARGV[5] == '1'
+
+local tat = tonumber(redis.call('GET', KEYS[1]))
+if tat == nil or tat < now then
+	tat = now
+end
+
+-- 放行当前请求后的理论到达时间最多领先当前时间一个窗口
+local newTat = tat + n * interval
+local allowAt = newTat - window
+local wait = 0
+if now < allowAt then
+	wait = math.ceil(allowAt - now)
+	if not reserve then
+		return {0, wait}
+	end
+end
+
+-- TAT回落到当前时间后状态等价于新key，可以过期
+redis.call('SET', KEYS[1], newTat, 'PX', math.ceil((newTat - now) / 1e3) + 1)
+return {1, wait}
+`)
+
+// refundScript 回退理论到达时间的Redis实现
+// ARGV: 当前时间(微秒), 发射间隔(微秒，可以有小数), 归还的单位数
+// 返回: {是否归还}
+var refundScript = store.NewScript(`
+local now = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+
+local tat = tonumber(redis.call('GET', KEYS[1]))
+if tat == nil or tat <= now then
+	return {0}
+end
+
+tat = math.max(now, tat - n * interval)
+redis.call('SET', KEYS[1], tat, 'PX', math.ceil((tat - now) / 1e3) + 1)
+return {1}
+`)
+
+// statusScript 查询理论到达时间的Redis实现，不修改状态
+// ARGV: 当前时间(微秒), 发射间隔(微秒，可以有小数), 窗口大小(微秒), 窗口内允许的最大请求数
+// 返回: {剩余的单位数, 距离TAT回落到当前时间的微秒数, 需要等待的微秒数}
+var statusScript = store.NewScript(`
+local now = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local window = tonumber(ARGV[3])
+local limit = tonumber(ARGV[4])
+
+local tat = tonumber(redis.call('GET', KEYS[1]))
+if tat == nil or tat < now then
+	tat = now
+end
+
+-- TAT最多领先当前时间一个窗口，剩余的领先空间可以放行的单位数
+local remaining = math.min(limit, math.max(0, math.floor((now + window - tat) / interval)))
+local wait = math.max(0, tat + interval - window - now)
+return {remaining, tat - now, wait}
+`)
+
+// GCRALimiter 实现基于通用信元速率算法(GCRA)的限流器
+// 每个key只保存理论到达时间，内存占用为O(1)，在窗口内最多允许Limit个请求的突发
+// 发射间隔WindowSize/Limit不能小于1微秒
+type GCRALimiter struct {
+	// 状态存储
+	store store.Store
+	// 时钟
+	clock algorithms.Clock
+	// 配置信息
+	config algorithms.Config
+	// 存储执行失败时的处理策略
+	failure algorithms.FailurePolicy
+	// 发射间隔，即两个请求之间的平均间隔
+	interval time.Duration
+}
+
+// NewLimiter 创建一个新的GCRA限流器
+func NewLimiter(config algorithms.Config, opts ...algorithms.Option) *GCRALimiter {
+	o := algorithms.NewOptions(opts...)
+	return &GCRALimiter{
+		store:    o.Store,
+		clock:    o.Clock,
+		config:   config,
+		failure:  o.Failure,
+		interval: config.WindowSize / time.Duration(config.Limit),
+	}
+}
+
+// intervalMicros 返回传给脚本的发射间隔微秒数
+// 保留小数部分，避免截断后Redis与进程内存储的速率不一致
+func (l *GCRALimiter) intervalMicros() float64 {
+	return float64(l.interval) / float64(time.Microsecond)
+}
+
+// Allow 实现RateLimiter接口
+func (l *GCRALimiter) Allow(ctx context.Context, key string) (bool, time.Duration) {
+	return l.AllowN(ctx, key, 1)
+}
+
+// AllowN 实现RateLimiter接口
+func (l *GCRALimiter) AllowN(ctx context.Context, key string, n int64) (bool, time.Duration) {
+	return l.advance(ctx, key, n, false, l.config.WindowSize, l.clock.Now())
+}
+
+// AllowNReserved 实现Prioritizer接口，保留的容量对应的发射间隔不能被占用
+func (l *GCRALimiter) AllowNReserved(ctx context.Context, key string, n int64, reserve float64) (bool, time.Duration) {
+	reserved := l.config.Reserved(reserve)
+	if n > l.config.Limit-reserved {
+		return false, 0
+	}
+	return l.advance(ctx, key, n, false, l.config.WindowSize-time.Duration(reserved)*l.interval, l.clock.Now())
+}
+
+// Reserve 实现Reserver接口
+func (l *GCRALimiter) Reserve(ctx context.Context, key string) *algorithms.Reservation {
+	return l.ReserveN(ctx, key, 1)
+}
+
+// ReserveN 实现Reserver接口
+func (l *GCRALimiter) ReserveN(ctx context.Context, key string, n int64) *algorithms.Reservation {
+	now := l.clock.Now()
+	ok, wait := l.advance(ctx, key, n, true, l.config.WindowSize, now)
+	return algorithms.NewReservation(ok, now.Add(wait), l.clock, func() {
+		l.RefundN(context.Background(), key, n)
+	})
+}
+
+// advance 将理论到达时间推进n个发射间隔，最多领先当前时间window，reserve为true时允许领先更多
+func (l *GCRALimiter) advance(ctx context.Context, key string, n int64, reserve bool, window time.Duration, now time.Time) (bool, time.Duration) {
+	if n > l.config.Limit {
+		return false, 0
+	}
+
+	reserveArg := 0
+	if reserve {
+		reserveArg = 1
+	}
+	res, err := l.store.Exec(ctx, key, store.Op{
+		Script: gcraScript,
+		Args:   []interface{}{now.UnixMicro(), l.intervalMicros(), window.Microseconds(), n, reserveArg},
+		Apply: func(state interface{}) (interface{}, time.Time, []int64) {
+			tat, exists := state.(time.Time)
+			if !exists || tat.Before(now) {
+				tat = now
+			}
+
+			// 放行当前请求后的理论到达时间最多领先当前时间一个窗口
+			newTat := tat.Add(time.Duration(n) * l.interval)
+			allowAt := newTat.Add(-window)
+			var waitTime time.Duration
+			if now.Before(allowAt) {
+				waitTime = allowAt.Sub(now)
+				if !reserve {
+					return tat, tat, []int64{0, store.Micros(waitTime)}
+				}
+			}
+
+			// TAT回落到当前时间后状态等价于新key
+			return newTat, newTat, []int64{1, store.Micros(waitTime)}
+		},
+	})
+	if err != nil {
+		return l.failure.Allows("GCRA", key, err), 0
+	}
+
+	return res[0] == 1, store.Duration(res[1])
+}
+
+// RefundN 实现Refunder接口，将理论到达时间回退n个发射间隔
+func (l *GCRALimiter) RefundN(ctx context.Context, key string, n int64) {
+	now := l.clock.Now()
+	_, err := l.store.Exec(ctx, key, store.Op{
+		Script: refundScript,
+		Args:   []interface{}{now.UnixMicro(), l.intervalMicros(), n},
+		Apply: func(state interface{}) (interface{}, time.Time, []int64) {
+			tat, exists := state.(time.Time)
+			if !exists || !tat.After(now) {
+				return nil, time.Time{}, []int64{0}
+			}
+
+			tat = tat.Add(-time.Duration(n) * l.interval)
+			if tat.Before(now) {
+				tat = now
+			}
+			return tat, tat, []int64{1}
+		},
+	})
+	if err != nil {
+		log.Printf("GCRA归还配额失败: key=%s, error=%v", key, err)
+	}
+}
+
+// Status 实现RateLimiter接口
+func (l *GCRALimiter) Status(ctx context.Context, key string) algorithms.Status {
+	now := l.clock.Now()
+	res, err := l.store.Exec(ctx, key, store.Op{
+		Script: statusScript,
+		Args:   []interface{}{now.UnixMicro(), l.intervalMicros(), l.config.WindowSize.Microseconds(), l.config.Limit},
+		Apply: func(state interface{}) (interface{}, time.Time, []int64) {
+			stored, exists := state.(time.Time)
+			tat := stored
+			if !exists || tat.Before(now) {
+				tat = now
+			}
+
+			// TAT最多领先当前时间一个窗口，剩余的领先空间可以放行的单位数
+			remaining := int64(now.Add(l.config.WindowSize).Sub(tat) / l.interval)
+			if remaining > l.config.Limit {
+				remaining = l.config.Limit
+			} else if remaining < 0 {
+				remaining = 0
+			}
+			var wait time.Duration
+			if allowAt := tat.Add(l.interval - l.config.WindowSize); now.Before(allowAt) {
+				wait = allowAt.Sub(now)
+			}
+
+			res := []int64{remaining, store.Micros(tat.Sub(now)), store.Micros(wait)}
+			if !exists {
+				return nil, time.Time{}, res
+			}
+			return stored, stored, res
+		},
+	})
+	if err != nil {
+		log.Printf("GCRA查询状态失败: key=%s, error=%v", key, err)
+		return algorithms.Status{Limit: l.config.Limit, Remaining: l.config.Limit, Reset: now}
+	}
+
+	return algorithms.Status{
+		Limit:     l.config.Limit,
+		Remaining: res[0],
+		Reset:     now.Add(store.Duration(res[1])),
+		Wait:      store.Duration(res[2]),
+	}
+}
+
+// Clock 实现Clocked接口
+func (l *GCRALimiter) Clock() algorithms.Clock {
+	return l.clock
+}
+
+// Close 实现RateLimiter接口
+func (l *GCRALimiter) Close() error {
+	return l.store.Close()
+}
@@ -2,10 +2,12 @@ package leakybucket
 
 import (
 	"context"
-	"sync"
+	"log"
+	"math"
 	"time"
 
 	"github.com/wureny/FluxGo/internal/algorithms"
+	"github.com/wureny/FluxGo/internal/store"
 )
 
 // 漏桶状态
@@ -14,70 +16,370 @@ type bucket struct {
 	lastLeakTime time.Time // 上次漏水时间
 }
 
+// leakScript 漏桶的Redis实现
+// ARGV: 当前时间(微秒), 漏水速率(每秒), 桶容量, 加入的水量, 是否预留, 新桶的水量, 漏空后状态的保留时长(微秒)
+// 预留时允许水量超过容量，等待漏到容量以内后再执行请求
+// 返回: {是否允许, 需要等待的微秒数}
+var leakScript = store.NewScript(`
+local now = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local capacity = tonumber(ARGV[3])
+local n = tonumber(ARGV[4])
+local reserve = ARGV[5] == '1'
+local initial = tonumber(ARGV[6])
+local retention = tonumber(ARGV[7])
+
+local state = redis.call('HMGET', KEYS[1], 'water', 'ts')
+local water = tonumber(state[1])
+local ts = tonumber(state[2])
+
+-- 新建的漏桶按初始填充比例放入水，已有的漏桶最多漏空
+if water == nil then
+	water = initial
+else
+	water = math.max(0, water - (now - ts) / 1e6 * rate)
+end
+
+-- 如果加入当前请求后会溢出，则拒绝请求
+local allowed = 1
+local wait = 0
+if water + n > capacity then
+	wait = math.ceil((water + n - capacity) / rate * 1e6)
+	if not reserve then
+		allowed = 0
+	end
+end
+
+if allowed == 1 then
+	water = water + n
+end
+-- 拒绝时同样保存状态，新建的漏桶从此时开始漏水
+redis.call('HSET', KEYS[1], 'water', water, 'ts', now)
+-- 桶漏空并经过保留时长后可以过期
+redis.call('PEXPIRE', KEYS[1], math.ceil((water / rate * 1e6 + retention) / 1e3) + 1)
+return {allowed, wait}
+`)
+
+// shapeScript 漏桶整形模式的Redis实现，桶中的水量即排在前面的请求，按漏水速率依次放行
+// ARGV: 当前时间(微秒), 漏水速率(每秒), 加入的水量, 最大排队时间(微秒), 新桶的水量, 漏空后状态的保留时长(微秒)
+// 返回: {是否允许, 允许时为排队的微秒数，拒绝时为需要等待的微秒数}
+var shapeScript = store.NewScript(`
+local now = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+local maxDelay = tonumber(ARGV[4])
+local initial = tonumber(ARGV[5])
+local retention = tonumber(ARGV[6])
+
+local state = redis.call('HMGET', KEYS[1], 'water', 'ts')
+local water = tonumber(state[1])
+local ts = tonumber(state[2])
+
+if water == nil then
+	water = initial
+else
+	water = math.max(0, water - (now - ts) / 1e6 * rate)
+end
+
+-- 排在前面的水漏完后才放行当前请求
+local delay = math.ceil(water / rate * 1e6)
+local res = {1, delay}
+if delay > maxDelay then
+	res = {0, delay - maxDelay}
+else
+	water = water + n
+end
+redis.call('HSET', KEYS[1], 'water', water, 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil((water / rate * 1e6 + retention) / 1e3) + 1)
+return res
+`)
+
+// refundScript 从桶中取回水量的Redis实现
+// ARGV: 当前时间(微秒), 漏水速率(每秒), 取回的水量, 漏空后状态的保留时长(微秒)
+// 返回: {是否取回}
+var refundScript = store.NewScript(`
+local now = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+local retention = tonumber(ARGV[4])
+
+local state = redis.call('HMGET', KEYS[1], 'water', 'ts')
+local water = tonumber(state[1])
+local ts = tonumber(state[2])
+
+-- 漏桶不存在时等价于新桶
+if water == nil then
+	return {0}
+end
+
+water = math.max(0, water - (now - ts) / 1e6 * rate - n)
+redis.call('HSET', KEYS[1], 'water', water, 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil((water / rate * 1e6 + retention) / 1e3) + 1)
+return {1}
+`)
+
+// statusScript 查询水量的Redis实现，不修改状态
+// ARGV: 当前时间(微秒), 漏水速率(每秒), 桶容量, 新桶的水量
+// 返回: {剩余的单位数, 距离漏空的微秒数, 需要等待的微秒数}
+var statusScript = store.NewScript(`
+local now = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local capacity = tonumber(ARGV[3])
+local initial = tonumber(ARGV[4])
+
+local state = redis.call('HMGET', KEYS[1], 'water', 'ts')
+local water = tonumber(state[1])
+local ts = tonumber(state[2])
+
+local reset = 0
+if water == nil then
+	water = initial
+else
+	reset = math.max(0, math.ceil(ts + water / rate * 1e6 - now))
+	water = math.max(0, water - (now - ts) / 1e6 * rate)
+end
+
+local wait = 0
+if water + 1 > capacity then
+	wait = math.ceil((water + 1 - capacity) / rate * 1e6)
+end
+return {math.max(0, math.floor(capacity - water)), reset, wait}
+`)
+
 // LeakyBucketLimiter 实现基于漏桶算法的限流器
 type LeakyBucketLimiter struct {
-	mu sync.RWMutex
-	// key -> 漏桶的映射
-	buckets map[string]bucket
+	// 状态存储
+	store store.Store
+	// 时钟
+	clock algorithms.Clock
 	// 配置信息
 	config algorithms.Config
+	// 存储执行失败时的处理策略
+	failure algorithms.FailurePolicy
 	// 漏水速率（每秒）
 	rate float64
 	// 桶容量
 	capacity float64
+	// 新桶的水量
+	initial float64
+	// 漏空后状态的保留时长，之后key被回收，重新按新key处理
+	retention time.Duration
 }
 
 // NewLimiter 创建一个新的漏桶限流器
-func NewLimiter(config algorithms.Config) *LeakyBucketLimiter {
+func NewLimiter(config algorithms.Config, opts ...algorithms.Option) *LeakyBucketLimiter {
+	o := algorithms.NewOptions(opts...)
 	return &LeakyBucketLimiter{
-		buckets:  make(map[string]bucket),
-		config:   config,
-		rate:     float64(config.Limit) / config.WindowSize.Seconds(),
-		capacity: float64(config.Limit),
+		store:     o.Store,
+		clock:     o.Clock,
+		config:    config,
+		failure:   o.Failure,
+		rate:      float64(config.Limit) / config.WindowSize.Seconds(),
+		capacity:  float64(config.Capacity()),
+		initial:   float64(config.Capacity()) * (1 - config.Fill()),
+		retention: retention(config),
+	}
+}
+
+// retention 返回漏空后状态的保留时长
+// 新key满额可用时漏空的漏桶等价于新key，可以立即回收
+func retention(config algorithms.Config) time.Duration {
+	if config.Fill() >= 1 {
+		return 0
 	}
+	return config.WindowSize
 }
 
 // Allow 实现RateLimiter接口
 func (l *LeakyBucketLimiter) Allow(ctx context.Context, key string) (bool, time.Duration) {
-	l.mu.Lock()
-	defer l.mu.Unlock()
+	return l.AllowN(ctx, key, 1)
+}
+
+// AllowN 实现RateLimiter接口
+func (l *LeakyBucketLimiter) AllowN(ctx context.Context, key string, n int64) (bool, time.Duration) {
+	return l.add(ctx, key, n, false, l.capacity, l.clock.Now())
+}
+
+// AllowNReserved 实现Prioritizer接口，加入后桶中至少还要剩余保留的容量
+func (l *LeakyBucketLimiter) AllowNReserved(ctx context.Context, key string, n int64, reserve float64) (bool, time.Duration) {
+	return l.add(ctx, key, n, false, l.capacity-float64(l.config.Reserved(reserve)), l.clock.Now())
+}
+
+// Reserve 实现Reserver接口
+func (l *LeakyBucketLimiter) Reserve(ctx context.Context, key string) *algorithms.Reservation {
+	return l.ReserveN(ctx, key, 1)
+}
+
+// ReserveN 实现Reserver接口
+func (l *LeakyBucketLimiter) ReserveN(ctx context.Context, key string, n int64) *algorithms.Reservation {
+	now := l.clock.Now()
+	ok, wait := l.add(ctx, key, n, true, l.capacity, now)
+	return algorithms.NewReservation(ok, now.Add(wait), l.clock, func() {
+		l.RefundN(context.Background(), key, n)
+	})
+}
+
+// ShapeN 实现Shaper接口
+// 整形模式下不再按桶容量突发放行，请求排在桶中已有的水之后按漏水速率依次放行
+func (l *LeakyBucketLimiter) ShapeN(ctx context.Context, key string, n int64, maxDelay time.Duration) (bool, time.Duration) {
+	if float64(n) > l.capacity {
+		return false, 0
+	}
 
-	now := time.Now()
-	b, exists := l.buckets[key]
+	now := l.clock.Now()
+	res, err := l.store.Exec(ctx, key, store.Op{
+		Script: shapeScript,
+		Args:   []interface{}{now.UnixMicro(), l.rate, n, maxDelay.Microseconds(), l.initial, l.retention.Microseconds()},
+		Apply: func(state interface{}) (interface{}, time.Time, []int64) {
+			b := bucket{water: l.leak(state, now), lastLeakTime: now}
+
+			// 排在前面的水漏完后才放行当前请求
+			delay := store.Micros(time.Duration(b.water / l.rate * float64(time.Second)))
+			if delay > maxDelay.Microseconds() {
+				return b, l.expireAt(b), []int64{0, delay - maxDelay.Microseconds()}
+			}
+
+			b.water += float64(n)
+			return b, l.expireAt(b), []int64{1, delay}
+		},
+	})
+	if err != nil {
+		return l.failure.Allows("漏桶整形", key, err), 0
+	}
+
+	return res[0] == 1, store.Duration(res[1])
+}
+
+// add 向漏桶中加入n个单位的水，加入后水量不能超过capacity，reserve为true时允许超过
+func (l *LeakyBucketLimiter) add(ctx context.Context, key string, n int64, reserve bool, capacity float64, now time.Time) (bool, time.Duration) {
+	if float64(n) > capacity {
+		return false, 0
+	}
+
+	reserveArg := 0
+	if reserve {
+		reserveArg = 1
+	}
+	res, err := l.store.Exec(ctx, key, store.Op{
+		Script: leakScript,
+		Args:   []interface{}{now.UnixMicro(), l.rate, capacity, n, reserveArg, l.initial, l.retention.Microseconds()},
+		Apply: func(state interface{}) (interface{}, time.Time, []int64) {
+			b := bucket{water: l.leak(state, now), lastLeakTime: now}
+
+			// 如果加入当前请求后会溢出，则拒绝请求；预留时等待漏到容量以内
+			// 拒绝时同样保存状态，新建的漏桶从此时开始漏水
+			var waitTime time.Duration
+			if b.water+float64(n) > capacity {
+				waitTime = time.Duration((b.water + float64(n) - capacity) / l.rate * float64(time.Second))
+				if !reserve {
+					return b, l.expireAt(b), []int64{0, store.Micros(waitTime)}
+				}
+			}
+
+			// 更新水量
+			b.water += float64(n)
+			return b, l.expireAt(b), []int64{1, store.Micros(waitTime)}
+		},
+	})
+	if err != nil {
+		return l.failure.Allows("漏桶", key, err), 0
+	}
+
+	return res[0] == 1, store.Duration(res[1])
+}
+
+// RefundN 实现Refunder接口，从漏桶中取回n个单位的水
+func (l *LeakyBucketLimiter) RefundN(ctx context.Context, key string, n int64) {
+	now := l.clock.Now()
+	_, err := l.store.Exec(ctx, key, store.Op{
+		Script: refundScript,
+		Args:   []interface{}{now.UnixMicro(), l.rate, n, l.retention.Microseconds()},
+		Apply: func(state interface{}) (interface{}, time.Time, []int64) {
+			b, exists := state.(bucket)
+			if !exists {
+				// 漏桶不存在时等价于新桶
+				return nil, time.Time{}, []int64{0}
+			}
+
+			elapsed := now.Sub(b.lastLeakTime).Seconds()
+			b.water = max(0, b.water-elapsed*l.rate-float64(n))
+			b.lastLeakTime = now
+			return b, l.expireAt(b), []int64{1}
+		},
+	})
+	if err != nil {
+		log.Printf("漏桶取回水量失败: key=%s, error=%v", key, err)
+	}
+}
+
+// Status 实现RateLimiter接口
+func (l *LeakyBucketLimiter) Status(ctx context.Context, key string) algorithms.Status {
+	now := l.clock.Now()
+	res, err := l.store.Exec(ctx, key, store.Op{
+		Script: statusScript,
+		Args:   []interface{}{now.UnixMicro(), l.rate, l.capacity, l.initial},
+		Apply: func(state interface{}) (interface{}, time.Time, []int64) {
+			b, exists := state.(bucket)
+			water := l.leak(state, now)
+
+			var wait time.Duration
+			if water+1 > l.capacity {
+				wait = time.Duration((water + 1 - l.capacity) / l.rate * float64(time.Second))
+			}
+			res := []int64{int64(math.Max(0, math.Floor(l.capacity-water))), 0, store.Micros(wait)}
+			if !exists {
+				return nil, time.Time{}, res
+			}
+
+			// 只读取状态
+			if reset := store.Micros(l.drainedAt(b).Sub(now)); reset > 0 {
+				res[1] = reset
+			}
+			return b, l.expireAt(b), res
+		},
+	})
+	if err != nil {
+		log.Printf("漏桶查询状态失败: key=%s, error=%v", key, err)
+		return algorithms.Status{Limit: int64(l.capacity), Remaining: int64(l.capacity), Reset: now}
+	}
+
+	return algorithms.Status{
+		Limit:     int64(l.capacity),
+		Remaining: res[0],
+		Reset:     now.Add(store.Duration(res[1])),
+		Wait:      store.Duration(res[2]),
+	}
+}
+
+// leak 返回漏水后当前的水量，新建的漏桶按初始填充比例放入水，已有的漏桶最多漏空
+func (l *LeakyBucketLimiter) leak(state interface{}, now time.Time) float64 {
+	b, exists := state.(bucket)
 	if !exists {
-		// 新建漏桶
-		l.buckets[key] = bucket{
-			water:        1, // 初始水量为1（当前请求）
-			lastLeakTime: now,
-		}
-		return true, 0
+		return l.initial
 	}
 
 	// 计算从上次漏水到现在流出的水量
-	elapsed := now.Sub(b.lastLeakTime).Seconds()
-	leakedWater := elapsed * l.rate
-	currentWater := max(0, b.water-leakedWater)
-
-	// 如果加入当前请求后会溢出，则拒绝请求
-	if currentWater+1 > l.capacity {
-		waitTime := time.Duration((currentWater + 1 - l.capacity) / l.rate * float64(time.Second))
-		return false, waitTime
-	}
+	return max(0, b.water-now.Sub(b.lastLeakTime).Seconds()*l.rate)
+}
 
-	// 更新水量和时间
-	b.water = currentWater + 1
-	b.lastLeakTime = now
-	l.buckets[key] = b
+// drainedAt 返回漏桶漏空的时间
+func (l *LeakyBucketLimiter) drainedAt(b bucket) time.Time {
+	return b.lastLeakTime.Add(time.Duration(b.water / l.rate * float64(time.Second)))
+}
+
+// expireAt 返回漏桶漏空并经过保留时长的时间，之后状态可以被回收
+func (l *LeakyBucketLimiter) expireAt(b bucket) time.Time {
+	return l.drainedAt(b).Add(l.retention)
+}
 
-	return true, 0
+// Clock 实现Clocked接口
+func (l *LeakyBucketLimiter) Clock() algorithms.Clock {
+	return l.clock
 }
 
 // Close 实现RateLimiter接口
 func (l *LeakyBucketLimiter) Close() error {
-	l.mu.Lock()
-	defer l.mu.Unlock()
-	l.buckets = make(map[string]bucket)
-	return nil
+	return l.store.Close()
 }
 
 func max(a, b float64) float64 {
@@ -2,6 +2,7 @@ package algorithms
 
 import (
 	"context"
+	"math"
 	"time"
 )
 
@@ -12,14 +13,153 @@ type RateLimiter interface {
 	// 返回值: 是否允许请求通过，如果不允许还会返回需要等待的时间
 	Allow(ctx context.Context, key string) (bool, time.Duration)
 
+	// AllowN 判断消耗n个单位的请求是否允许通过，Allow等价于AllowN(ctx, key, 1)
+	// n超过限流器容量的请求永远不会被允许，此时等待时间为0
+	AllowN(ctx context.Context, key string, n int64) (bool, time.Duration)
+
+	// Status 返回key的当前状态，不消耗配额
+	Status(ctx context.Context, key string) Status
+
 	// Close 清理资源
 	Close() error
 }
 
+// Status 限流器中key的当前状态
+type Status struct {
+	// 容量，即key最多可以连续放行的单位数
+	Limit int64
+	// 当前还可以放行的单位数
+	Remaining int64
+	// 配额完全恢复的时间
+	Reset time.Time
+	// 消耗1个单位的请求需要等待的时间，为0时可以立即放行
+	Wait time.Duration
+}
+
+// Refunder 支持归还配额的限流器
+type Refunder interface {
+	// RefundN 向key归还n个单位的配额，用于撤销已经放行但不应计数的请求
+	// 归还后的配额不会超过限流器的初始状态
+	RefundN(ctx context.Context, key string, n int64)
+}
+
+// Prioritizer 支持为高优先级请求保留容量的限流器
+type Prioritizer interface {
+	// AllowNReserved 与AllowN相同，但放行后key至少还要剩余容量的reserve比例（0到1）
+	// 用于低优先级的请求，保留的容量只有高优先级的请求可以使用。reserve为0时等价于AllowN
+	AllowNReserved(ctx context.Context, key string, n int64, reserve float64) (bool, time.Duration)
+}
+
+// Shaper 支持流量整形的限流器，请求按速率排队放行而不是被拒绝
+type Shaper interface {
+	// ShapeN 为n个单位的请求排队，返回是否允许以及放行前需要等待的时间
+	// 排队等待时间超过maxDelay时拒绝请求且不消耗配额，此时返回排队时间降到maxDelay以内需要等待的时间
+	ShapeN(ctx context.Context, key string, n int64, maxDelay time.Duration) (bool, time.Duration)
+}
+
+// Releaser 按在途请求数限流的限流器，放行的请求完成后需要释放占用的配额
+type Releaser interface {
+	// AcquireN 占用key的n个单位的配额，放行后key至少还要剩余容量的reserve比例
+	// 返回租约ID、是否允许以及需要等待的时间，放行的请求完成后用租约ID调用ReleaseN释放
+	AcquireN(ctx context.Context, key string, n int64, reserve float64) (string, bool, time.Duration)
+
+	// ReleaseN 释放租约lease占用的n个单位的配额，已经释放或到期的租约不做任何事
+	ReleaseN(ctx context.Context, key string, lease string, n int64)
+
+	// InFlight 返回key的在途请求数以及所有key合计的在途请求数
+	InFlight(ctx context.Context, key string) (int64, int64)
+}
+
+// QuotaStatus 日历配额的使用情况
+type QuotaStatus struct {
+	// 每个周期的配额
+	Limit int64
+	// 当前周期已使用的配额
+	Used int64
+	// 当前周期剩余的配额
+	Remaining int64
+	// 配额重置的时间，即下一个周期的开始时间
+	Reset time.Time
+}
+
+// HeavyHitter 请求量最大的key之一
+type HeavyHitter struct {
+	// 限流的key
+	Key string
+	// 滑动窗口内估算的请求单位数，包括被拒绝的请求
+	Count int64
+	// 估算值可能多出的上限，即实际值不小于Count-Error
+	Error int64
+}
+
+// HeavyHitterReporter 统计请求量最大的key的限流器
+type HeavyHitterReporter interface {
+	// TopK 返回请求量最大的key，按估算值从大到小排列
+	TopK(ctx context.Context) []HeavyHitter
+}
+
+// Quota 按日历周期计数的限流器，判断时同时返回配额的使用情况
+type Quota interface {
+	// ConsumeN 消耗key的n个单位的配额，返回是否允许以及判断后的使用情况
+	ConsumeN(ctx context.Context, key string, n int64) (bool, QuotaStatus)
+
+	// ConsumeNReserved 与ConsumeN相同，但消耗后key至少还要剩余配额的reserve比例
+	ConsumeNReserved(ctx context.Context, key string, n int64, reserve float64) (bool, QuotaStatus)
+
+	// Usage 返回key当前的使用情况，不消耗配额
+	Usage(ctx context.Context, key string) QuotaStatus
+}
+
 // Config 定义限流器的基本配置
 type Config struct {
 	// 时间窗口大小
 	WindowSize time.Duration
 	// 在窗口期内允许的最大请求数
 	Limit int64
+	// 突发容量，即桶最多能积攒的配额，为0时等于Limit。仅令牌桶和漏桶支持
+	// 例如Limit为600、WindowSize为1分钟、Burst为50表示平均每秒10个请求，最多突发50个
+	Burst int64
+	// 新建key时可用配额占突发容量的比例，取值0到1，为空时为1即满额可用。仅令牌桶和漏桶支持
+	// 只作用于新key，已有的令牌桶最多补满、漏桶最多漏空。小于1时补满或漏空的状态再保留一个窗口才回收
+	InitialFill *float64
+	// 预热时长，为0时不预热。仅令牌桶支持
+	// 新建的令牌桶在该时长内从配置速率的1/3线性增长到配置速率，避免冷启动时压垮上游
+	// 补满的令牌桶再保留一个窗口加预热时长才回收，回收后重新预热
+	// 冷启动时的突发量仍由InitialFill决定，通常配合较小的InitialFill使用
+	WarmUp time.Duration
+	// 所有key合计的上限，为0时不限制。仅并发限流和公平分配支持，公平分配必须设置
+	GlobalLimit int64
+	// 日历周期，Limit为每个周期的配额，忽略WindowSize。仅日历配额支持
+	Period Period
+	// 日历周期所在的时区，如"Asia/Shanghai"，为空时使用UTC。仅日历配额支持
+	Location string
+	// 计数草图每行的计数器数量，为0时为2048，最大65536。仅计数草图支持
+	// 估算值最多比实际值多出窗口内所有key合计请求数的e/SketchWidth，越大越精确
+	SketchWidth int
+	// 计数草图的行数，为0时为4，最大16。估算值超出上述误差的概率约为e^-SketchDepth
+	// 草图固定占用2*SketchWidth*SketchDepth个计数器，与key的数量无关
+	SketchDepth int
+	// 统计请求量最大的key数量，为0时为10，最大1000。仅计数草图支持
+	TopK int
+}
+
+// Capacity 返回突发容量
+func (c Config) Capacity() int64 {
+	if c.Burst > 0 {
+		return c.Burst
+	}
+	return c.Limit
+}
+
+// Reserved 返回容量中reserve比例对应的单位数，四舍五入
+func (c Config) Reserved(reserve float64) int64 {
+	return int64(math.Round(float64(c.Capacity()) * reserve))
+}
+
+// Fill 返回新建key时可用配额的比例
+func (c Config) Fill() float64 {
+	if c.InitialFill == nil {
+		return 1
+	}
+	return *c.InitialFill
 }
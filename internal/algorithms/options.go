@@ -0,0 +1,55 @@
+package algorithms
+
+import (
+	"github.com/wureny/FluxGo/internal/store"
+	"github.com/wureny/FluxGo/internal/store/memory"
+)
+
+// Options 限流器的可选配置
+type Options struct {
+	// 状态存储，默认使用进程内存储
+	Store store.Store
+	// 时钟，默认使用系统时间
+	Clock Clock
+	// 存储执行失败时的处理策略，默认放行请求
+	Failure FailurePolicy
+}
+
+// Option 限流器配置项
+type Option func(*Options)
+
+// WithStore 指定限流器读写状态使用的存储
+func WithStore(s store.Store) Option {
+	return func(o *Options) {
+		o.Store = s
+	}
+}
+
+// WithClock 指定限流器使用的时钟
+func WithClock(c Clock) Option {
+	return func(o *Options) {
+		o.Clock = c
+	}
+}
+
+// WithFailurePolicy 指定存储执行失败时的处理策略
+func WithFailurePolicy(p FailurePolicy) Option {
+	return func(o *Options) {
+		o.Failure = p
+	}
+}
+
+// NewOptions 应用配置项并填充默认值
+func NewOptions(opts ...Option) Options {
+	var o Options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.Clock == nil {
+		o.Clock = RealClock()
+	}
+	if o.Store == nil {
+		o.Store = memory.New(memory.Config{Now: o.Clock.Now})
+	}
+	return o
+}
@@ -0,0 +1,42 @@
+package algorithms
+
+import (
+	"fmt"
+	"time"
+)
+
+// Period 日历周期
+type Period string
+
+const (
+	// 整点开始的小时，按UTC对齐，半小时时差的时区不在本地整点开始
+	Hour Period = "hour"
+	// 0点开始的自然日
+	Day Period = "day"
+	// 周一0点开始的自然周
+	Week Period = "week"
+	// 1日0点开始的自然月
+	Month Period = "month"
+)
+
+// Bounds 返回t所在周期在loc时区下的起止时间，日、周、月按loc的本地时间对齐
+func (p Period) Bounds(t time.Time, loc *time.Location) (time.Time, time.Time, error) {
+	t = t.In(loc)
+	y, m, d := t.Date()
+	switch p {
+	case Hour:
+		// 小时按UTC截断，夏令时结束时本地时间重复的一小时也分为两个周期，每个周期总是一小时
+		start := t.UTC().Truncate(time.Hour).In(loc)
+		return start, start.Add(time.Hour), nil
+	case Day:
+		return time.Date(y, m, d, 0, 0, 0, 0, loc), time.Date(y, m, d+1, 0, 0, 0, 0, loc), nil
+	case Week:
+		// Weekday以周日为0，换算为距离周一的天数
+		d -= (int(t.Weekday()) + 6) % 7
+		return time.Date(y, m, d, 0, 0, 0, 0, loc), time.Date(y, m, d+7, 0, 0, 0, 0, loc), nil
+	case Month:
+		return time.Date(y, m, 1, 0, 0, 0, 0, loc), time.Date(y, m+1, 1, 0, 0, 0, 0, loc), nil
+	default:
+		return time.Time{}, time.Time{}, fmt.Errorf("unsupported period: %s", p)
+	}
+}
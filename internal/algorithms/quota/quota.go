@@ -0,0 +1,256 @@
+package quota
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/wureny/FluxGo/internal/algorithms"
+	"github.com/wureny/FluxGo/internal/store"
+)
+
+// usage 当前周期的使用记录
+type usage struct {
+	count int64     // 当前周期已使用的配额
+	start time.Time // 周期的开始时间
+}
+
+// consumeScript 日历配额计数的Redis实现，周期边界由调用方按时区计算
+// ARGV: 当前时间(微秒), 周期开始时间(微秒), 周期结束时间(微秒), 每个周期的配额, 消耗的单位数
+// 返回: {是否允许, 判断后已使用的配额}
+var consumeScript = store.NewScript(`
+local now = tonumber(ARGV[1])
+local start = tonumber(ARGV[2])
+local reset = tonumber(ARGV[3])
+local limit = tonumber(ARGV[4])
+local n = tonumber(ARGV[5])
+
+-- 记录不属于当前周期时已经重置
+local state = redis.call('HMGET', KEYS[1], 'count', 'start')
+local count = tonumber(state[1]) or 0
+if tonumber(state[2]) ~= start then
+	count = 0
+end
+
+-- 只查询使用情况
+if n == 0 then
+	return {1, count}
+end
+
+if count + n > limit then
+	return {0, count}
+end
+
+redis.call('HSET', KEYS[1], 'count', count + n, 'start', start)
+redis.call('PEXPIRE', KEYS[1], math.ceil((reset - now) / 1e3) + 1)
+return {1, count + n}
+`)
+
+// refundScript 扣减当前周期计数的Redis实现
+// ARGV: 周期开始时间(微秒), 归还的单位数
+// 返回: {归还后已使用的配额}
+var refundScript = store.NewScript(`
+local start = tonumber(ARGV[1])
+local n = tonumber(ARGV[2])
+
+local state = redis.call('HMGET', KEYS[1], 'count', 'start')
+local count = tonumber(state[1])
+-- 记录不属于当前周期时计数已经清零
+if count == nil or tonumber(state[2]) ~= start then
+	return {0}
+end
+
+count = math.max(0, count - n)
+redis.call('HSET', KEYS[1], 'count', count)
+return {count}
+`)
+
+// QuotaLimiter 实现按日历周期计数的配额限流器
+// 配额在Period的边界（整点、0点、周一0点、1日0点）按Location时区统一重置，
+// 不同于从key的第一个请求开始计时的固定窗口。配额需要在网关重启后保留时应使用Redis存储
+type QuotaLimiter struct {
+	// 状态存储
+	store store.Store
+	// 时钟
+	clock algorithms.Clock
+	// 配置信息
+	config algorithms.Config
+	// 存储执行失败时的处理策略
+	failure algorithms.FailurePolicy
+	// 周期所在的时区
+	location *time.Location
+}
+
+// NewLimiter 创建一个新的日历配额限流器
+func NewLimiter(config algorithms.Config, opts ...algorithms.Option) *QuotaLimiter {
+	o := algorithms.NewOptions(opts...)
+	location, err := time.LoadLocation(config.Location)
+	if err != nil {
+		log.Printf("日历配额时区无效，使用UTC: location=%s, error=%v", config.Location, err)
+		location = time.UTC
+	}
+	return &QuotaLimiter{
+		store:    o.Store,
+		clock:    o.Clock,
+		config:   config,
+		failure:  o.Failure,
+		location: location,
+	}
+}
+
+// Allow 实现RateLimiter接口
+func (l *QuotaLimiter) Allow(ctx context.Context, key string) (bool, time.Duration) {
+	return l.AllowN(ctx, key, 1)
+}
+
+// AllowN 实现RateLimiter接口，拒绝时返回距离配额重置的时间
+func (l *QuotaLimiter) AllowN(ctx context.Context, key string, n int64) (bool, time.Duration) {
+	if n > l.config.Limit {
+		return false, 0
+	}
+
+	now := l.clock.Now()
+	allowed, status := l.consume(ctx, key, n, l.config.Limit, now)
+	if !allowed {
+		return false, status.Reset.Sub(now)
+	}
+	return true, 0
+}
+
+// AllowNReserved 实现Prioritizer接口，拒绝时返回距离配额重置的时间
+func (l *QuotaLimiter) AllowNReserved(ctx context.Context, key string, n int64, reserve float64) (bool, time.Duration) {
+	now := l.clock.Now()
+	allowed, status := l.ConsumeNReserved(ctx, key, n, reserve)
+	if !allowed && n <= l.config.Limit-l.config.Reserved(reserve) {
+		return false, status.Reset.Sub(now)
+	}
+	return allowed, 0
+}
+
+// ConsumeN 实现Quota接口
+func (l *QuotaLimiter) ConsumeN(ctx context.Context, key string, n int64) (bool, algorithms.QuotaStatus) {
+	return l.ConsumeNReserved(ctx, key, n, 0)
+}
+
+// ConsumeNReserved 实现Quota接口
+func (l *QuotaLimiter) ConsumeNReserved(ctx context.Context, key string, n int64, reserve float64) (bool, algorithms.QuotaStatus) {
+	limit := l.config.Limit - l.config.Reserved(reserve)
+	if n > limit {
+		// 超过配额的请求永远不会被允许，不消耗配额
+		return false, l.Usage(ctx, key)
+	}
+	return l.consume(ctx, key, n, limit, l.clock.Now())
+}
+
+// Usage 实现Quota接口
+func (l *QuotaLimiter) Usage(ctx context.Context, key string) algorithms.QuotaStatus {
+	_, status := l.consume(ctx, key, 0, l.config.Limit, l.clock.Now())
+	return status
+}
+
+// Status 实现RateLimiter接口
+func (l *QuotaLimiter) Status(ctx context.Context, key string) algorithms.Status {
+	now := l.clock.Now()
+	_, q := l.consume(ctx, key, 0, l.config.Limit, now)
+	status := algorithms.Status{
+		Limit:     q.Limit,
+		Remaining: q.Remaining,
+		Reset:     q.Reset,
+	}
+	if status.Remaining <= 0 {
+		status.Wait = q.Reset.Sub(now)
+	}
+	return status
+}
+
+// RefundN 实现Refunder接口，扣减当前周期的计数
+func (l *QuotaLimiter) RefundN(ctx context.Context, key string, n int64) {
+	start, reset, err := l.config.Period.Bounds(l.clock.Now(), l.location)
+	if err != nil {
+		log.Printf("日历配额周期无效: key=%s, error=%v", key, err)
+		return
+	}
+
+	_, err = l.store.Exec(ctx, key, store.Op{
+		Script: refundScript,
+		Args:   []interface{}{start.UnixMicro(), n},
+		Apply: func(state interface{}) (interface{}, time.Time, []int64) {
+			u, exists := state.(usage)
+
+			// 记录不属于当前周期时计数已经清零
+			if !exists || !u.start.Equal(start) {
+				return nil, time.Time{}, []int64{0}
+			}
+
+			u.count -= n
+			if u.count < 0 {
+				u.count = 0
+			}
+			return u, reset, []int64{u.count}
+		},
+	})
+	if err != nil {
+		log.Printf("日历配额归还配额失败: key=%s, error=%v", key, err)
+	}
+}
+
+// consume 按limit消耗当前周期n个单位的配额，n为0时只查询使用情况
+// 返回的使用情况总是按完整的配额计算
+func (l *QuotaLimiter) consume(ctx context.Context, key string, n int64, limit int64, now time.Time) (bool, algorithms.QuotaStatus) {
+	start, reset, err := l.config.Period.Bounds(now, l.location)
+	if err != nil {
+		// 配置错误时放行，避免限流组件故障导致业务整体不可用
+		log.Printf("日历配额周期无效: key=%s, error=%v", key, err)
+		return true, algorithms.QuotaStatus{Limit: l.config.Limit, Remaining: l.config.Limit}
+	}
+
+	res, err := l.store.Exec(ctx, key, store.Op{
+		Script: consumeScript,
+		Args:   []interface{}{now.UnixMicro(), start.UnixMicro(), reset.UnixMicro(), limit, n},
+		Apply: func(state interface{}) (interface{}, time.Time, []int64) {
+			u, exists := state.(usage)
+
+			// 记录不属于当前周期时已经重置
+			if !exists || !u.start.Equal(start) {
+				u = usage{start: start}
+			}
+
+			// 只查询或拒绝时不修改计数
+			if n == 0 || u.count+n > limit {
+				allowed := int64(0)
+				if n == 0 {
+					allowed = 1
+				}
+				if u.count == 0 {
+					return nil, time.Time{}, []int64{allowed, 0}
+				}
+				return u, reset, []int64{allowed, u.count}
+			}
+
+			u.count += n
+			return u, reset, []int64{1, u.count}
+		},
+	})
+	if err != nil {
+		return l.failure.Allows("日历配额", key, err), algorithms.QuotaStatus{Limit: l.config.Limit, Remaining: l.config.Limit, Reset: reset}
+	}
+
+	// 调小配额后已用量可能超过配额，剩余配额不为负数
+	used := res[1]
+	return res[0] == 1, algorithms.QuotaStatus{
+		Limit:     l.config.Limit,
+		Used:      used,
+		Remaining: max(0, l.config.Limit-used),
+		Reset:     reset,
+	}
+}
+
+// Clock 实现Clocked接口
+func (l *QuotaLimiter) Clock() algorithms.Clock {
+	return l.clock
+}
+
+// Close 实现RateLimiter接口
+func (l *QuotaLimiter) Close() error {
+	return l.store.Close()
+}
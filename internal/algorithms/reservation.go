@@ -0,0 +1,131 @@
+package algorithms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrExceedsCapacity 请求成本超过限流器容量，永远不会被允许
+var ErrExceedsCapacity = errors.New("request cost exceeds limiter capacity")
+
+// Reserver 支持预留配额的限流器
+// 预留总会成功扣减配额（只要成本不超过容量），调用方按返回的延迟等待后再执行请求
+type Reserver interface {
+	// Reserve 预留1个单位的配额
+	Reserve(ctx context.Context, key string) *Reservation
+
+	// ReserveN 预留n个单位的配额
+	ReserveN(ctx context.Context, key string, n int64) *Reservation
+}
+
+// Reservation 一次配额预留
+type Reservation struct {
+	// 是否预留成功
+	ok bool
+	// 可以执行请求的时间
+	timeToAct time.Time
+	// 限流器使用的时钟
+	clock Clock
+	// 归还预留的配额
+	refund func()
+	// 保证只归还一次
+	once sync.Once
+}
+
+// NewReservation 创建配额预留，refund用于取消时归还配额
+func NewReservation(ok bool, timeToAct time.Time, clock Clock, refund func()) *Reservation {
+	return &Reservation{
+		ok:        ok,
+		timeToAct: timeToAct,
+		clock:     clock,
+		refund:    refund,
+	}
+}
+
+// OK 返回是否预留成功，成本超过容量时预留失败
+func (r *Reservation) OK() bool {
+	return r.ok
+}
+
+// Delay 返回执行请求前需要等待的时间
+func (r *Reservation) Delay() time.Duration {
+	return r.DelayFrom(r.clock.Now())
+}
+
+// DelayFrom 返回从now开始需要等待的时间
+func (r *Reservation) DelayFrom(now time.Time) time.Duration {
+	if !r.ok {
+		return 0
+	}
+	if delay := r.timeToAct.Sub(now); delay > 0 {
+		return delay
+	}
+	return 0
+}
+
+// Cancel 取消预留并归还配额，预留的执行时间已过时不做任何事
+func (r *Reservation) Cancel() {
+	if !r.ok || r.refund == nil || !r.clock.Now().Before(r.timeToAct) {
+		return
+	}
+	r.once.Do(r.refund)
+}
+
+// Wait 阻塞直到key的1个单位请求被允许或ctx结束
+func Wait(ctx context.Context, l RateLimiter, key string) error {
+	return WaitN(ctx, l, key, 1)
+}
+
+// WaitN 阻塞直到key的n个单位请求被允许或ctx结束
+// 支持预留的限流器先预留配额再等待，ctx提前结束时归还配额；
+// 其他限流器按Allow返回的等待时间重试
+func WaitN(ctx context.Context, l RateLimiter, key string, n int64) error {
+	if reserver, ok := l.(Reserver); ok {
+		r := reserver.ReserveN(ctx, key, n)
+		if !r.OK() {
+			return ErrExceedsCapacity
+		}
+		if err := sleep(ctx, r.clock, r.Delay()); err != nil {
+			r.Cancel()
+			return err
+		}
+		return nil
+	}
+
+	clock := ClockOf(l)
+	for {
+		allowed, wait := l.AllowN(ctx, key, n)
+		if allowed {
+			return nil
+		}
+		if wait == 0 {
+			return ErrExceedsCapacity
+		}
+		if err := sleep(ctx, clock, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// sleep 等待delay时长，ctx先结束或截止时间早于等待结束时返回错误
+// 等待结束的时间按限流器的时钟计算，与限流器返回的等待时间使用同一个时间来源
+func sleep(ctx context.Context, clock Clock, delay time.Duration) error {
+	if delay <= 0 {
+		return ctx.Err()
+	}
+	if deadline, ok := ctx.Deadline(); ok && deadline.Before(clock.Now().Add(delay)) {
+		return fmt.Errorf("wait %s would exceed context deadline", delay)
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -2,70 +2,346 @@ package slidinglog
 
 import (
 	"context"
-	"sync"
+	"log"
 	"time"
 
 	"github.com/wureny/FluxGo/internal/algorithms"
+	"github.com/wureny/FluxGo/internal/store"
 )
 
-// 请求记录
+// 请求记录，同一请求消耗的多个单位记录为一条
 type requestLog struct {
 	timestamp time.Time
+	units     int64
 }
 
+// parseLog Redis中每条日志保存为"时间戳:单位数"，列表的第一个元素保存所有日志的单位数合计
+// 返回时间戳、单位数以及包含分隔符的时间戳前缀
+const parseLog = `
+local function parseLog(entry)
+	local sep = string.find(entry, ':', 1, true)
+	return tonumber(string.sub(entry, 1, sep - 1)), tonumber(string.sub(entry, sep + 1)), string.sub(entry, 1, sep)
+end
+`
+
+// logScript 滑动窗口日志的Redis实现，请求日志按时间顺序保存在列表中
+// ARGV: 当前时间(微秒), 窗口大小(微秒), 窗口内允许的最大请求数, 请求消耗的单位数
+// 返回: {是否允许, 需要等待的微秒数}
+var logScript = store.NewScript(parseLog + `
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+local n = tonumber(ARGV[4])
+
+-- 取出单位数合计，执行结束前放回
+local total = tonumber(redis.call('LPOP', KEYS[1])) or 0
+
+-- 清理过期的日志
+local windowStart = now - window
+while true do
+	local oldest = redis.call('LINDEX', KEYS[1], 0)
+	if not oldest then
+		break
+	end
+	local ts, units = parseLog(oldest)
+	if ts > windowStart then
+		break
+	end
+	redis.call('LPOP', KEYS[1])
+	total = total - units
+end
+
+-- 如果加入当前请求后未超过限制，允许请求，整个请求记录一条日志
+if total + n <= limit then
+	redis.call('RPUSH', KEYS[1], ARGV[1] .. ':' .. ARGV[4])
+	redis.call('LPUSH', KEYS[1], total + n)
+	redis.call('PEXPIRE', KEYS[1], math.ceil(window / 1e3) + 1)
+	return {1, 0}
+end
+
+-- 等待足够多的单位过期，n不超过limit时total一定大于0
+redis.call('LPUSH', KEYS[1], total)
+local need = total + n - limit
+for _, entry in ipairs(redis.call('LRANGE', KEYS[1], 1, -1)) do
+	local ts, units = parseLog(entry)
+	need = need - units
+	if need <= 0 then
+		return {0, ts + window - now}
+	end
+end
+return {0, 0}
+`)
+
+// refundScript 扣减最近日志的Redis实现
+// ARGV: 当前时间(微秒), 窗口大小(微秒), 归还的单位数
+// 返回: {扣减的单位数}
+var refundScript = store.NewScript(parseLog + `
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+
+local total = tonumber(redis.call('LPOP', KEYS[1]))
+if total == nil then
+	return {0}
+end
+
+-- 只扣减窗口内的日志，过期的日志已经不占配额
+local windowStart = now - window
+local removed = 0
+while removed < n do
+	local newest = redis.call('LINDEX', KEYS[1], -1)
+	if not newest then
+		break
+	end
+	local ts, units, prefix = parseLog(newest)
+	if ts <= windowStart then
+		break
+	end
+	local take = math.min(units, n - removed)
+	if take == units then
+		redis.call('RPOP', KEYS[1])
+	else
+		redis.call('LSET', KEYS[1], -1, prefix .. (units - take))
+	end
+	removed = removed + take
+end
+
+if redis.call('LLEN', KEYS[1]) > 0 then
+	redis.call('LPUSH', KEYS[1], total - removed)
+end
+return {removed}
+`)
+
+// statusScript 查询窗口内日志的Redis实现，不修改状态
+// ARGV: 当前时间(微秒), 窗口大小(微秒), 窗口内允许的最大请求数
+// 返回: {剩余的单位数, 距离所有日志过期的微秒数, 需要等待的微秒数}
+var statusScript = store.NewScript(parseLog + `
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+
+local total = tonumber(redis.call('LINDEX', KEYS[1], 0))
+if total == nil then
+	return {limit, 0, 0}
+end
+
+-- 扣除过期日志的单位数
+local windowStart = now - window
+local logs = redis.call('LRANGE', KEYS[1], 1, -1)
+local first = #logs + 1
+for i, entry in ipairs(logs) do
+	local ts, units = parseLog(entry)
+	if ts > windowStart then
+		first = i
+		break
+	end
+	total = total - units
+end
+
+if total <= 0 then
+	return {limit, 0, 0}
+end
+
+local reset = parseLog(logs[#logs]) + window - now
+if total < limit then
+	return {limit - total, reset, 0}
+end
+-- 等待足够多的单位过期
+local need = total - limit + 1
+for i = first, #logs do
+	local ts, units = parseLog(logs[i])
+	need = need - units
+	if need <= 0 then
+		return {0, reset, ts + window - now}
+	end
+end
+return {0, reset, reset}
+`)
+
 // SlidingLogLimiter 实现基于滑动窗口日志的限流器
+// 每个放行的请求记录一条日志，日志条数不超过Limit，与请求的成本无关
 type SlidingLogLimiter struct {
-	mu sync.RWMutex
-	// key -> 请求日志切片的映射
-	logs map[string][]requestLog
+	// 状态存储
+	store store.Store
+	// 时钟
+	clock algorithms.Clock
 	// 配置信息
 	config algorithms.Config
+	// 存储执行失败时的处理策略
+	failure algorithms.FailurePolicy
 }
 
 // NewLimiter 创建一个新的滑动窗口日志限流器
-func NewLimiter(config algorithms.Config) *SlidingLogLimiter {
+func NewLimiter(config algorithms.Config, opts ...algorithms.Option) *SlidingLogLimiter {
+	o := algorithms.NewOptions(opts...)
 	return &SlidingLogLimiter{
-		logs:   make(map[string][]requestLog),
-		config: config,
+		store:   o.Store,
+		clock:   o.Clock,
+		config:  config,
+		failure: o.Failure,
 	}
 }
 
 // Allow 实现RateLimiter接口
 func (l *SlidingLogLimiter) Allow(ctx context.Context, key string) (bool, time.Duration) {
-	l.mu.Lock()
-	defer l.mu.Unlock()
+	return l.AllowN(ctx, key, 1)
+}
 
-	now := time.Now()
-	windowStart := now.Add(-l.config.WindowSize)
+// AllowN 实现RateLimiter接口
+func (l *SlidingLogLimiter) AllowN(ctx context.Context, key string, n int64) (bool, time.Duration) {
+	return l.allow(ctx, key, n, l.config.Limit)
+}
 
-	// 获取该key的请求日志
-	logs := l.logs[key]
+// AllowNReserved 实现Prioritizer接口，按扣除保留容量后的限制判断
+func (l *SlidingLogLimiter) AllowNReserved(ctx context.Context, key string, n int64, reserve float64) (bool, time.Duration) {
+	return l.allow(ctx, key, n, l.config.Limit-l.config.Reserved(reserve))
+}
 
-	// 清理过期的日志
-	validLogs := make([]requestLog, 0)
-	for _, log := range logs {
-		if log.timestamp.After(windowStart) {
-			validLogs = append(validLogs, log)
-		}
+// allow 按limit判断消耗n个单位的请求是否允许通过
+func (l *SlidingLogLimiter) allow(ctx context.Context, key string, n int64, limit int64) (bool, time.Duration) {
+	if n > limit {
+		return false, 0
+	}
+
+	now := l.clock.Now()
+	res, err := l.store.Exec(ctx, key, store.Op{
+		Script: logScript,
+		Args:   []interface{}{now.UnixMicro(), l.config.WindowSize.Microseconds(), limit, n},
+		Apply: func(state interface{}) (interface{}, time.Time, []int64) {
+			windowStart := now.Add(-l.config.WindowSize)
+
+			// 获取该key的请求日志
+			logs, _ := state.([]requestLog)
+
+			// 清理过期的日志
+			validLogs := make([]requestLog, 0, len(logs)+1)
+			var count int64
+			for _, log := range logs {
+				if log.timestamp.After(windowStart) {
+					validLogs = append(validLogs, log)
+					count += log.units
+				}
+			}
+
+			// 如果加入当前请求后未超过限制，允许请求，整个请求记录一条日志
+			if count+n <= limit {
+				validLogs = append(validLogs, requestLog{timestamp: now, units: n})
+				return validLogs, now.Add(l.config.WindowSize), []int64{1, 0}
+			}
+
+			// 计算需要等待足够多的单位过期的时间
+			waitDuration := l.expireWait(validLogs, count+n-limit).Sub(now)
+			expireAt := validLogs[len(validLogs)-1].timestamp.Add(l.config.WindowSize)
+			return validLogs, expireAt, []int64{0, store.Micros(waitDuration)}
+		},
+	})
+	if err != nil {
+		return l.failure.Allows("滑动窗口日志", key, err), 0
+	}
+
+	return res[0] == 1, store.Duration(res[1])
+}
+
+// RefundN 实现Refunder接口，从窗口内最近的日志开始扣减n个单位
+func (l *SlidingLogLimiter) RefundN(ctx context.Context, key string, n int64) {
+	now := l.clock.Now()
+	_, err := l.store.Exec(ctx, key, store.Op{
+		Script: refundScript,
+		Args:   []interface{}{now.UnixMicro(), l.config.WindowSize.Microseconds(), n},
+		Apply: func(state interface{}) (interface{}, time.Time, []int64) {
+			windowStart := now.Add(-l.config.WindowSize)
+			logs, _ := state.([]requestLog)
+
+			// 只扣减窗口内的日志，过期的日志已经不占配额
+			logs = append([]requestLog(nil), logs...)
+			var removed int64
+			for removed < n && len(logs) > 0 && logs[len(logs)-1].timestamp.After(windowStart) {
+				last := &logs[len(logs)-1]
+				take := min(last.units, n-removed)
+				last.units -= take
+				removed += take
+				if last.units == 0 {
+					logs = logs[:len(logs)-1]
+				}
+			}
+			if len(logs) == 0 {
+				return nil, time.Time{}, []int64{removed}
+			}
+			return logs, logs[len(logs)-1].timestamp.Add(l.config.WindowSize), []int64{removed}
+		},
+	})
+	if err != nil {
+		log.Printf("滑动窗口日志归还配额失败: key=%s, error=%v", key, err)
+	}
+}
+
+// Status 实现RateLimiter接口
+func (l *SlidingLogLimiter) Status(ctx context.Context, key string) algorithms.Status {
+	now := l.clock.Now()
+	res, err := l.store.Exec(ctx, key, store.Op{
+		Script: statusScript,
+		Args:   []interface{}{now.UnixMicro(), l.config.WindowSize.Microseconds(), l.config.Limit},
+		Apply: func(state interface{}) (interface{}, time.Time, []int64) {
+			windowStart := now.Add(-l.config.WindowSize)
+			logs, _ := state.([]requestLog)
+
+			// 跳过过期的日志
+			first := len(logs)
+			for i, log := range logs {
+				if log.timestamp.After(windowStart) {
+					first = i
+					break
+				}
+			}
+
+			var count int64
+			for _, log := range logs[first:] {
+				count += log.units
+			}
+			if count == 0 {
+				return nil, time.Time{}, []int64{l.config.Limit, 0, 0}
+			}
+
+			expireAt := logs[len(logs)-1].timestamp.Add(l.config.WindowSize)
+			reset := store.Micros(expireAt.Sub(now))
+			if count < l.config.Limit {
+				return logs, expireAt, []int64{l.config.Limit - count, reset, 0}
+			}
+			// 等待足够多的单位过期
+			wait := l.expireWait(logs[first:], count-l.config.Limit+1).Sub(now)
+			return logs, expireAt, []int64{0, reset, store.Micros(wait)}
+		},
+	})
+	if err != nil {
+		log.Printf("滑动窗口日志查询状态失败: key=%s, error=%v", key, err)
+		return algorithms.Status{Limit: l.config.Limit, Remaining: l.config.Limit, Reset: now}
 	}
 
-	// 如果请求数量未达到限制，允许请求
-	if int64(len(validLogs)) < l.config.Limit {
-		l.logs[key] = append(validLogs, requestLog{timestamp: now})
-		return true, 0
+	return algorithms.Status{
+		Limit:     l.config.Limit,
+		Remaining: res[0],
+		Reset:     now.Add(store.Duration(res[1])),
+		Wait:      store.Duration(res[2]),
 	}
+}
 
-	// 计算需要等待的时间
-	waitDuration := validLogs[0].timestamp.Add(l.config.WindowSize).Sub(now)
-	return false, waitDuration
+// expireWait 返回窗口内的日志从最早开始累计过期need个单位的时间
+func (l *SlidingLogLimiter) expireWait(logs []requestLog, need int64) time.Time {
+	for _, log := range logs {
+		need -= log.units
+		if need <= 0 {
+			return log.timestamp.Add(l.config.WindowSize)
+		}
+	}
+	return logs[len(logs)-1].timestamp.Add(l.config.WindowSize)
+}
+
+// Clock 实现Clocked接口
+func (l *SlidingLogLimiter) Clock() algorithms.Clock {
+	return l.clock
 }
 
 // Close 实现RateLimiter接口
 func (l *SlidingLogLimiter) Close() error {
-	l.mu.Lock()
-	defer l.mu.Unlock()
-
-	// 清理所有数据
-	l.logs = make(map[string][]requestLog)
-	return nil
+	return l.store.Close()
 }
@@ -2,68 +2,342 @@ package slidingwindow
 
 import (
 	"context"
-	"sync"
+	"log"
+	"math"
 	"time"
 
 	"github.com/wureny/FluxGo/internal/algorithms"
+	"github.com/wureny/FluxGo/internal/store"
 )
 
-// 窗口计数记录
+// 相邻两个窗口的计数
 type windowCount struct {
-	count     int64     // 当前窗口的请求计数
-	timestamp time.Time // 窗口的起始时间
+	start    time.Time // 当前窗口的起始时间
+	current  int64     // 当前窗口的请求计数
+	previous int64     // 上一个窗口的请求计数
 }
 
+// windowScript 滑动窗口计数的Redis实现
+// ARGV: 当前时间(微秒), 窗口大小(微秒), 窗口内允许的最大请求数, 请求消耗的单位数
+// 返回: {是否允许, 需要等待的微秒数}
+var windowScript = store.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+local n = tonumber(ARGV[4])
+
+-- 窗口按时间对齐
+local start = now - now % window
+
+local state = redis.call('HMGET', KEYS[1], 'start', 'current', 'previous')
+local lastStart = tonumber(state[1])
+local current = tonumber(state[2]) or 0
+local previous = tonumber(state[3]) or 0
+
+-- 滚动窗口
+if lastStart ~= start then
+	if lastStart == start - window then
+		previous = current
+	else
+		previous = 0
+	end
+	current = 0
+end
+
+-- 上一个窗口的计数按与滑动窗口的重叠比例加权
+local weight = 1 - (now - start) / window
+if previous * weight + current + n <= limit then
+	current = current + n
+	redis.call('HSET', KEYS[1], 'start', start, 'current', current, 'previous', previous)
+	-- 两个窗口之后计数不再有影响，可以过期
+	redis.call('PEXPIRE', KEYS[1], math.ceil((start + 2 * window - now) / 1e3) + 1)
+	return {1, 0}
+end
+
+-- 计算估算值降到限制以内的时间
+local at
+if current + n <= limit then
+	at = start + window * (1 - (limit - current - n) / previous)
+else
+	at = start + window + window * (1 - (limit - n) / current)
+end
+return {0, math.ceil(at - now)}
+`)
+
+// refundScript 扣减窗口计数的Redis实现，优先扣减当前窗口
+// ARGV: 当前时间(微秒), 窗口大小(微秒), 归还的单位数
+// 返回: {是否归还}
+var refundScript = store.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+
+local start = now - now % window
+
+local state = redis.call('HMGET', KEYS[1], 'start', 'current', 'previous')
+local lastStart = tonumber(state[1])
+local current = tonumber(state[2]) or 0
+local previous = tonumber(state[3]) or 0
+
+if lastStart == nil then
+	return {0}
+end
+if lastStart ~= start then
+	if lastStart == start - window then
+		previous = current
+	else
+		previous = 0
+	end
+	current = 0
+end
+
+local fromCurrent = math.min(current, n)
+current = current - fromCurrent
+previous = math.max(0, previous - (n - fromCurrent))
+redis.call('HSET', KEYS[1], 'start', start, 'current', current, 'previous', previous)
+redis.call('PEXPIRE', KEYS[1], math.ceil((start + 2 * window - now) / 1e3) + 1)
+return {1}
+`)
+
+// statusScript 查询估算计数的Redis实现，不修改状态
+// ARGV: 当前时间(微秒), 窗口大小(微秒), 窗口内允许的最大请求数
+// 返回: {剩余的单位数, 距离计数不再有影响的微秒数, 需要等待的微秒数}
+var statusScript = store.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+
+local start = now - now % window
+
+local state = redis.call('HMGET', KEYS[1], 'start', 'current', 'previous')
+local lastStart = tonumber(state[1])
+local current = tonumber(state[2]) or 0
+local previous = tonumber(state[3]) or 0
+
+if lastStart ~= start then
+	if lastStart == start - window then
+		previous = current
+	else
+		previous = 0
+	end
+	current = 0
+end
+
+-- 当前窗口的计数在下一个窗口结束后不再有影响，上一个窗口的计数在当前窗口结束后不再有影响
+local reset = 0
+if current > 0 then
+	reset = start + 2 * window - now
+elseif previous > 0 then
+	reset = start + window - now
+end
+
+local weight = 1 - (now - start) / window
+local estimate = previous * weight + current
+if estimate + 1 <= limit then
+	return {math.floor(limit - estimate), reset, 0}
+end
+
+-- 计算估算值降到限制以内的时间，与放行1个单位时的计算一致
+local at
+if current + 1 <= limit then
+	at = start + window * (1 - (limit - current - 1) / previous)
+else
+	at = start + window + window * (1 - (limit - 1) / current)
+end
+return {math.max(0, math.floor(limit - estimate)), reset, math.ceil(at - now)}
+`)
+
 // SlidingWindowLimiter 实现基于滑动窗口计数的限流器
+// 保存当前和上一个窗口的计数，按上一个窗口与滑动窗口的重叠比例加权估算窗口内的请求数
 type SlidingWindowLimiter struct {
-	mu sync.RWMutex
-	// key -> 窗口计数的映射
-	windows map[string]windowCount
+	// 状态存储
+	store store.Store
+	// 时钟
+	clock algorithms.Clock
 	// 配置信息
 	config algorithms.Config
+	// 存储执行失败时的处理策略
+	failure algorithms.FailurePolicy
 }
 
 // NewLimiter 创建一个新的滑动窗口计数限流器
-func NewLimiter(config algorithms.Config) *SlidingWindowLimiter {
+func NewLimiter(config algorithms.Config, opts ...algorithms.Option) *SlidingWindowLimiter {
+	o := algorithms.NewOptions(opts...)
 	return &SlidingWindowLimiter{
-		windows: make(map[string]windowCount),
+		store:   o.Store,
+		clock:   o.Clock,
 		config:  config,
+		failure: o.Failure,
 	}
 }
 
 // Allow 实现RateLimiter接口
 func (l *SlidingWindowLimiter) Allow(ctx context.Context, key string) (bool, time.Duration) {
-	l.mu.Lock()
-	defer l.mu.Unlock()
-
-	now := time.Now()
-	window, exists := l.windows[key]
-
-	// 如果窗口不存在或已过期，创建新窗口
-	if !exists || now.Sub(window.timestamp) >= l.config.WindowSize {
-		l.windows[key] = windowCount{
-			count:     1,
-			timestamp: now,
-		}
-		return true, 0
+	return l.AllowN(ctx, key, 1)
+}
+
+// AllowN 实现RateLimiter接口
+func (l *SlidingWindowLimiter) AllowN(ctx context.Context, key string, n int64) (bool, time.Duration) {
+	return l.allow(ctx, key, n, l.config.Limit)
+}
+
+// AllowNReserved 实现Prioritizer接口，按扣除保留容量后的限制判断
+func (l *SlidingWindowLimiter) AllowNReserved(ctx context.Context, key string, n int64, reserve float64) (bool, time.Duration) {
+	return l.allow(ctx, key, n, l.config.Limit-l.config.Reserved(reserve))
+}
+
+// allow 按limit判断消耗n个单位的请求是否允许通过
+func (l *SlidingWindowLimiter) allow(ctx context.Context, key string, n int64, limit int64) (bool, time.Duration) {
+	if n > limit {
+		return false, 0
+	}
+
+	now := l.clock.Now()
+	res, err := l.store.Exec(ctx, key, store.Op{
+		Script: windowScript,
+		Args:   []interface{}{now.UnixMicro(), l.config.WindowSize.Microseconds(), limit, n},
+		Apply: func(state interface{}) (interface{}, time.Time, []int64) {
+			w := l.roll(state, now)
+			// 两个窗口之后计数不再有影响
+			expireAt := w.start.Add(2 * l.config.WindowSize)
+
+			// 上一个窗口的计数按与滑动窗口的重叠比例加权
+			weight := 1 - float64(now.Sub(w.start))/float64(l.config.WindowSize)
+			threshold := float64(limit)
+			if float64(w.previous)*weight+float64(w.current+n) <= threshold {
+				w.current += n
+				return w, expireAt, []int64{1, 0}
+			}
+
+			// 计算估算值降到限制以内的时间
+			var at time.Time
+			if w.current+n <= limit {
+				ratio := 1 - (threshold-float64(w.current+n))/float64(w.previous)
+				at = w.start.Add(time.Duration(ratio * float64(l.config.WindowSize)))
+			} else {
+				ratio := 1 - (threshold-float64(n))/float64(w.current)
+				at = w.start.Add(l.config.WindowSize + time.Duration(ratio*float64(l.config.WindowSize)))
+			}
+			return w, expireAt, []int64{0, store.Micros(at.Sub(now))}
+		},
+	})
+	if err != nil {
+		return l.failure.Allows("滑动窗口计数", key, err), 0
+	}
+
+	return res[0] == 1, store.Duration(res[1])
+}
+
+// RefundN 实现Refunder接口，优先扣减当前窗口的计数，不足时扣减上一个窗口
+func (l *SlidingWindowLimiter) RefundN(ctx context.Context, key string, n int64) {
+	now := l.clock.Now()
+	_, err := l.store.Exec(ctx, key, store.Op{
+		Script: refundScript,
+		Args:   []interface{}{now.UnixMicro(), l.config.WindowSize.Microseconds(), n},
+		Apply: func(state interface{}) (interface{}, time.Time, []int64) {
+			if state == nil {
+				return nil, time.Time{}, []int64{0}
+			}
+
+			w := l.roll(state, now)
+			fromCurrent := n
+			if fromCurrent > w.current {
+				fromCurrent = w.current
+			}
+			w.current -= fromCurrent
+			w.previous -= n - fromCurrent
+			if w.previous < 0 {
+				w.previous = 0
+			}
+			return w, w.start.Add(2 * l.config.WindowSize), []int64{1}
+		},
+	})
+	if err != nil {
+		log.Printf("滑动窗口计数归还配额失败: key=%s, error=%v", key, err)
+	}
+}
+
+// Status 实现RateLimiter接口
+func (l *SlidingWindowLimiter) Status(ctx context.Context, key string) algorithms.Status {
+	now := l.clock.Now()
+	res, err := l.store.Exec(ctx, key, store.Op{
+		Script: statusScript,
+		Args:   []interface{}{now.UnixMicro(), l.config.WindowSize.Microseconds(), l.config.Limit},
+		Apply: func(state interface{}) (interface{}, time.Time, []int64) {
+			// 只读取状态，保持原有的失效时间
+			var expireAt time.Time
+			if old, exists := state.(windowCount); exists {
+				expireAt = old.start.Add(2 * l.config.WindowSize)
+			}
+			w := l.roll(state, now)
+
+			// 当前窗口的计数在下一个窗口结束后不再有影响，上一个窗口的计数在当前窗口结束后不再有影响
+			var reset int64
+			if w.current > 0 {
+				reset = store.Micros(w.start.Add(2 * l.config.WindowSize).Sub(now))
+			} else if w.previous > 0 {
+				reset = store.Micros(w.start.Add(l.config.WindowSize).Sub(now))
+			}
+
+			weight := 1 - float64(now.Sub(w.start))/float64(l.config.WindowSize)
+			limit := float64(l.config.Limit)
+			estimate := float64(w.previous)*weight + float64(w.current)
+			remaining := int64(math.Max(0, math.Floor(limit-estimate)))
+			if estimate+1 <= limit {
+				return state, expireAt, []int64{remaining, reset, 0}
+			}
+
+			// 计算估算值降到限制以内的时间，与放行1个单位时的计算一致
+			var at time.Time
+			if w.current+1 <= l.config.Limit {
+				ratio := 1 - (limit-float64(w.current+1))/float64(w.previous)
+				at = w.start.Add(time.Duration(ratio * float64(l.config.WindowSize)))
+			} else {
+				ratio := 1 - (limit-1)/float64(w.current)
+				at = w.start.Add(l.config.WindowSize + time.Duration(ratio*float64(l.config.WindowSize)))
+			}
+			return state, expireAt, []int64{remaining, reset, store.Micros(at.Sub(now))}
+		},
+	})
+	if err != nil {
+		log.Printf("滑动窗口计数查询状态失败: key=%s, error=%v", key, err)
+		return algorithms.Status{Limit: l.config.Limit, Remaining: l.config.Limit, Reset: now}
 	}
 
-	// 计算当前请求数量是否超过限制
-	if window.count >= l.config.Limit {
-		waitDuration := window.timestamp.Add(l.config.WindowSize).Sub(now)
-		return false, waitDuration
+	return algorithms.Status{
+		Limit:     l.config.Limit,
+		Remaining: res[0],
+		Reset:     now.Add(store.Duration(res[1])),
+		Wait:      store.Duration(res[2]),
 	}
+}
+
+// roll 将状态滚动到now所在的窗口
+func (l *SlidingWindowLimiter) roll(state interface{}, now time.Time) windowCount {
+	// 窗口按Unix时间对齐，与Redis实现保持一致
+	n := now.UnixNano()
+	start := time.Unix(0, n-n%int64(l.config.WindowSize))
+	w, exists := state.(windowCount)
+	if !exists {
+		return windowCount{start: start}
+	}
+
+	switch {
+	case w.start.Equal(start):
+		return w
+	case w.start.Add(l.config.WindowSize).Equal(start):
+		return windowCount{start: start, previous: w.current}
+	default:
+		return windowCount{start: start}
+	}
+}
 
-	// 更新计数
-	window.count++
-	l.windows[key] = window
-	return true, 0
+// Clock 实现Clocked接口
+func (l *SlidingWindowLimiter) Clock() algorithms.Clock {
+	return l.clock
 }
 
 // Close 实现RateLimiter接口
 func (l *SlidingWindowLimiter) Close() error {
-	l.mu.Lock()
-	defer l.mu.Unlock()
-	l.windows = make(map[string]windowCount)
-	return nil
+	return l.store.Close()
 }
@@ -2,81 +2,425 @@ package tokenbucket
 
 import (
 	"context"
-	"sync"
+	"log"
+	"math"
 	"time"
 
 	"github.com/wureny/FluxGo/internal/algorithms"
+	"github.com/wureny/FluxGo/internal/store"
 )
 
 // 令牌桶状态
 type bucket struct {
 	tokens     float64   // 当前令牌数
 	lastRefill time.Time // 上次补充令牌的时间
+	warmStart  time.Time // 本轮预热开始的时间
 }
 
+// coldFactor 冷启动时令牌生成速率为配置速率的1/coldFactor，与Guava的SmoothWarmingUp一致
+const coldFactor = 3
+
+// warmUpLua 预热期内令牌生成速率从冷启动速率线性增长到配置速率，供各脚本共用
+// 预热时长为0时始终按配置速率生成
+var warmUpLua = `
+local coldFactor = 3
+
+-- 预热开始后经过u秒累计生成的令牌数
+local function produced(u, rate, warmup)
+	local cold = rate / coldFactor
+	if u < warmup then
+		return cold * u + (rate - cold) * u * u / (2 * warmup)
+	end
+	return (cold + rate) * warmup / 2 + rate * (u - warmup)
+end
+
+-- 从ts到now生成的令牌数，时间单位为微秒
+local function refill(warm, ts, now, rate, warmup)
+	if warmup <= 0 or (ts - warm) / 1e6 >= warmup then
+		return (now - ts) / 1e6 * rate
+	end
+	return produced((now - warm) / 1e6, rate, warmup) - produced((ts - warm) / 1e6, rate, warmup)
+end
+
+-- 预热开始后经过u秒时，生成amount个令牌需要的微秒数
+local function fill(u, amount, rate, warmup)
+	if amount <= 0 then
+		return 0
+	end
+	if warmup <= 0 or u >= warmup then
+		return amount / rate * 1e6
+	end
+	local rest = produced(warmup, rate, warmup) - produced(u, rate, warmup)
+	if amount >= rest then
+		return (warmup - u + (amount - rest) / rate) * 1e6
+	end
+	-- 预热期内速率线性增长，解一元二次方程
+	local cold = rate / coldFactor
+	local k = (rate - cold) / warmup
+	local v = cold + k * u
+	return (math.sqrt(v * v + 2 * k * amount) - v) / k * 1e6
+end
+`
+
+// takeScript 令牌桶的Redis实现
+// ARGV: 当前时间(微秒), 令牌生成速率(每秒), 桶容量, 消耗的令牌数, 是否预留, 新桶的令牌数, 预热时长(秒), 保留的令牌数, 补满后状态的保留时长(微秒)
+// 预留时允许令牌数为负，等待补足后再执行请求；消耗后至少还要剩余保留的令牌数
+// 返回: {是否允许, 需要等待的微秒数}
+var takeScript = store.NewScript(warmUpLua + `
+local now = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local capacity = tonumber(ARGV[3])
+local n = tonumber(ARGV[4])
+local reserve = ARGV[5] == '1'
+local initial = tonumber(ARGV[6])
+local warmup = tonumber(ARGV[7])
+local reserved = tonumber(ARGV[8])
+local retention = tonumber(ARGV[9])
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts', 'warm')
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+local warm = tonumber(state[3])
+
+if tokens == nil then
+	-- 新建令牌桶，按初始填充比例放入令牌并开始预热
+	tokens = initial
+	warm = now
+else
+	-- 没有记录预热开始时间的状态视为已经预热完成
+	if warm == nil then
+		warm = ts - warmup * 1e6
+	end
+	-- 令牌数最多补到容量
+	tokens = math.min(capacity, tokens + refill(warm, ts, now, rate, warmup))
+end
+
+local allowed = 1
+local wait = 0
+if tokens < n + reserved then
+	wait = math.ceil(fill((now - warm) / 1e6, n + reserved - tokens, rate, warmup))
+	if not reserve then
+		allowed = 0
+	end
+end
+
+if allowed == 1 then
+	tokens = tokens - n
+end
+-- 拒绝时同样保存状态，预热从令牌桶新建时开始计时
+redis.call('HSET', KEYS[1], 'tokens', tokens, 'ts', now, 'warm', warm)
+-- 令牌桶补满并经过保留时长后可以过期
+redis.call('PEXPIRE', KEYS[1], math.ceil((fill((now - warm) / 1e6, capacity - tokens, rate, warmup) + retention) / 1e3) + 1)
+return {allowed, wait}
+`)
+
+// refundScript 归还令牌的Redis实现
+// ARGV: 当前时间(微秒), 令牌生成速率(每秒), 桶容量, 归还的令牌数, 预热时长(秒), 补满后状态的保留时长(微秒)
+// 返回: {是否归还}
+var refundScript = store.NewScript(warmUpLua + `
+local now = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local capacity = tonumber(ARGV[3])
+local n = tonumber(ARGV[4])
+local warmup = tonumber(ARGV[5])
+local retention = tonumber(ARGV[6])
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts', 'warm')
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+local warm = tonumber(state[3])
+
+-- 令牌桶不存在时等价于新桶
+if tokens == nil then
+	return {0}
+end
+if warm == nil then
+	warm = ts - warmup * 1e6
+end
+
+tokens = math.min(capacity, tokens + refill(warm, ts, now, rate, warmup) + n)
+redis.call('HSET', KEYS[1], 'tokens', tokens, 'ts', now, 'warm', warm)
+redis.call('PEXPIRE', KEYS[1], math.ceil((fill((now - warm) / 1e6, capacity - tokens, rate, warmup) + retention) / 1e3) + 1)
+return {1}
+`)
+
+// statusScript 查询令牌数的Redis实现，不修改状态
+// ARGV: 当前时间(微秒), 令牌生成速率(每秒), 桶容量, 新桶的令牌数, 预热时长(秒)
+// 返回: {剩余的单位数, 距离补满的微秒数, 需要等待的微秒数}
+var statusScript = store.NewScript(warmUpLua + `
+local now = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local capacity = tonumber(ARGV[3])
+local initial = tonumber(ARGV[4])
+local warmup = tonumber(ARGV[5])
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts', 'warm')
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+local warm = tonumber(state[3])
+
+local reset = 0
+if tokens == nil then
+	tokens = initial
+	warm = now
+else
+	if warm == nil then
+		warm = ts - warmup * 1e6
+	end
+	reset = math.max(0, math.ceil(ts + fill((ts - warm) / 1e6, capacity - tokens, rate, warmup) - now))
+	tokens = math.min(capacity, tokens + refill(warm, ts, now, rate, warmup))
+end
+
+local wait = 0
+if tokens < 1 then
+	wait = math.ceil(fill((now - warm) / 1e6, 1 - tokens, rate, warmup))
+end
+return {math.max(0, math.floor(tokens)), reset, wait}
+`)
+
 // TokenBucketLimiter 实现基于令牌桶算法的限流器
 type TokenBucketLimiter struct {
-	mu sync.RWMutex
-	// key -> 令牌桶的映射
-	buckets map[string]bucket
+	// 状态存储
+	store store.Store
+	// 时钟
+	clock algorithms.Clock
 	// 配置信息
 	config algorithms.Config
+	// 存储执行失败时的处理策略
+	failure algorithms.FailurePolicy
 	// 令牌生成速率（每秒）
 	rate float64
 	// 桶容量
 	capacity float64
+	// 新桶的令牌数
+	initial float64
+	// 补满后状态的保留时长，之后key被回收，重新按新key处理
+	retention time.Duration
 }
 
 // NewLimiter 创建一个新的令牌桶限流器
-func NewLimiter(config algorithms.Config) *TokenBucketLimiter {
+func NewLimiter(config algorithms.Config, opts ...algorithms.Option) *TokenBucketLimiter {
+	o := algorithms.NewOptions(opts...)
 	return &TokenBucketLimiter{
-		buckets:  make(map[string]bucket),
-		config:   config,
-		rate:     float64(config.Limit) / config.WindowSize.Seconds(),
-		capacity: float64(config.Limit),
+		store:     o.Store,
+		clock:     o.Clock,
+		config:    config,
+		failure:   o.Failure,
+		rate:      float64(config.Limit) / config.WindowSize.Seconds(),
+		capacity:  float64(config.Capacity()),
+		initial:   float64(config.Capacity()) * config.Fill(),
+		retention: retention(config),
 	}
 }
 
+// retention 返回补满后状态的保留时长
+// 新key满额可用并且不预热时补满的令牌桶等价于新key，可以立即回收
+func retention(config algorithms.Config) time.Duration {
+	if config.Fill() >= 1 && config.WarmUp <= 0 {
+		return 0
+	}
+	return config.WindowSize + config.WarmUp
+}
+
 // Allow 实现RateLimiter接口
 func (l *TokenBucketLimiter) Allow(ctx context.Context, key string) (bool, time.Duration) {
-	l.mu.Lock()
-	defer l.mu.Unlock()
+	return l.AllowN(ctx, key, 1)
+}
+
+// AllowN 实现RateLimiter接口
+func (l *TokenBucketLimiter) AllowN(ctx context.Context, key string, n int64) (bool, time.Duration) {
+	return l.take(ctx, key, n, false, 0, l.clock.Now())
+}
+
+// AllowNReserved 实现Prioritizer接口，消耗后桶中至少还要剩余保留的令牌
+func (l *TokenBucketLimiter) AllowNReserved(ctx context.Context, key string, n int64, reserve float64) (bool, time.Duration) {
+	return l.take(ctx, key, n, false, float64(l.config.Reserved(reserve)), l.clock.Now())
+}
+
+// Reserve 实现Reserver接口
+func (l *TokenBucketLimiter) Reserve(ctx context.Context, key string) *algorithms.Reservation {
+	return l.ReserveN(ctx, key, 1)
+}
+
+// ReserveN 实现Reserver接口
+func (l *TokenBucketLimiter) ReserveN(ctx context.Context, key string, n int64) *algorithms.Reservation {
+	now := l.clock.Now()
+	ok, wait := l.take(ctx, key, n, true, 0, now)
+	return algorithms.NewReservation(ok, now.Add(wait), l.clock, func() {
+		l.RefundN(context.Background(), key, n)
+	})
+}
+
+// take 从令牌桶中取出n个令牌，取出后至少还要剩余reserved个令牌，reserve为true时允许令牌数为负
+func (l *TokenBucketLimiter) take(ctx context.Context, key string, n int64, reserve bool, reserved float64, now time.Time) (bool, time.Duration) {
+	if float64(n)+reserved > l.capacity {
+		return false, 0
+	}
+
+	reserveArg := 0
+	if reserve {
+		reserveArg = 1
+	}
+	res, err := l.store.Exec(ctx, key, store.Op{
+		Script: takeScript,
+		Args: []interface{}{now.UnixMicro(), l.rate, l.capacity, n, reserveArg, l.initial, l.config.WarmUp.Seconds(), reserved,
+			l.retention.Microseconds()},
+		Apply: func(state interface{}) (interface{}, time.Time, []int64) {
+			b := l.refill(state, now)
 
-	now := time.Now()
-	b, exists := l.buckets[key]
+			// 如果令牌不足，拒绝请求；预留时先欠下令牌，等待补足
+			var waitTime time.Duration
+			if b.tokens < float64(n)+reserved {
+				waitTime = l.fillDuration(b, now, float64(n)+reserved-b.tokens)
+				if !reserve {
+					return b, l.expireAt(b), []int64{0, store.Micros(waitTime)}
+				}
+			}
+
+			// 消耗令牌
+			b.tokens -= float64(n)
+			return b, l.expireAt(b), []int64{1, store.Micros(waitTime)}
+		},
+	})
+	if err != nil {
+		return l.failure.Allows("令牌桶", key, err), 0
+	}
+
+	return res[0] == 1, store.Duration(res[1])
+}
+
+// RefundN 实现Refunder接口，向令牌桶归还n个令牌
+func (l *TokenBucketLimiter) RefundN(ctx context.Context, key string, n int64) {
+	now := l.clock.Now()
+	_, err := l.store.Exec(ctx, key, store.Op{
+		Script: refundScript,
+		Args:   []interface{}{now.UnixMicro(), l.rate, l.capacity, n, l.config.WarmUp.Seconds(), l.retention.Microseconds()},
+		Apply: func(state interface{}) (interface{}, time.Time, []int64) {
+			b, exists := state.(bucket)
+			if !exists {
+				// 令牌桶不存在时等价于新桶
+				return nil, time.Time{}, []int64{0}
+			}
+
+			b.tokens = min(l.capacity, b.tokens+l.produced(b, now)+float64(n))
+			b.lastRefill = now
+			return b, l.expireAt(b), []int64{1}
+		},
+	})
+	if err != nil {
+		log.Printf("令牌桶归还令牌失败: key=%s, error=%v", key, err)
+	}
+}
+
+// Status 实现RateLimiter接口
+func (l *TokenBucketLimiter) Status(ctx context.Context, key string) algorithms.Status {
+	now := l.clock.Now()
+	res, err := l.store.Exec(ctx, key, store.Op{
+		Script: statusScript,
+		Args:   []interface{}{now.UnixMicro(), l.rate, l.capacity, l.initial, l.config.WarmUp.Seconds()},
+		Apply: func(state interface{}) (interface{}, time.Time, []int64) {
+			b, exists := state.(bucket)
+			current := l.refill(state, now)
+
+			wait := l.fillDuration(current, now, 1-current.tokens)
+			res := []int64{int64(math.Max(0, math.Floor(current.tokens))), 0, store.Micros(wait)}
+			if !exists {
+				return nil, time.Time{}, res
+			}
+
+			// 只读取状态
+			if reset := store.Micros(l.fullAt(b).Sub(now)); reset > 0 {
+				res[1] = reset
+			}
+			return b, l.expireAt(b), res
+		},
+	})
+	if err != nil {
+		log.Printf("令牌桶查询状态失败: key=%s, error=%v", key, err)
+		return algorithms.Status{Limit: int64(l.capacity), Remaining: int64(l.capacity), Reset: now}
+	}
+
+	return algorithms.Status{
+		Limit:     int64(l.capacity),
+		Remaining: res[0],
+		Reset:     now.Add(store.Duration(res[1])),
+		Wait:      store.Duration(res[2]),
+	}
+}
+
+// refill 返回补充令牌后的令牌桶，新建的令牌桶按初始填充比例放入令牌并开始预热
+func (l *TokenBucketLimiter) refill(state interface{}, now time.Time) bucket {
+	b, exists := state.(bucket)
 	if !exists {
-		// 新建令牌桶，初始容量为满
-		l.buckets[key] = bucket{
-			tokens:     l.capacity - 1, // 减1是因为当前请求会消耗一个令牌
-			lastRefill: now,
-		}
-		return true, 0
+		return bucket{tokens: l.initial, lastRefill: now, warmStart: now}
 	}
 
-	// 计算需要补充的令牌数
-	elapsed := now.Sub(b.lastRefill).Seconds()
-	newTokens := elapsed * l.rate
-	b.tokens = min(l.capacity, b.tokens+newTokens)
+	// 令牌数最多补到容量
+	b.tokens = min(l.capacity, b.tokens+l.produced(b, now))
 	b.lastRefill = now
+	return b
+}
+
+// produced 返回从上次补充令牌到now生成的令牌数
+func (l *TokenBucketLimiter) produced(b bucket, now time.Time) float64 {
+	if b.lastRefill.Sub(b.warmStart) >= l.config.WarmUp {
+		return now.Sub(b.lastRefill).Seconds() * l.rate
+	}
+	return l.warmUpTokens(now.Sub(b.warmStart)) - l.warmUpTokens(b.lastRefill.Sub(b.warmStart))
+}
+
+// warmUpTokens 返回预热开始后经过elapsed累计生成的令牌数
+// 预热期内生成速率从配置速率的1/coldFactor线性增长到配置速率
+func (l *TokenBucketLimiter) warmUpTokens(elapsed time.Duration) float64 {
+	u := elapsed.Seconds()
+	w := l.config.WarmUp.Seconds()
+	cold := l.rate / coldFactor
+	if u < w {
+		return cold*u + (l.rate-cold)*u*u/(2*w)
+	}
+	return (cold+l.rate)*w/2 + l.rate*(u-w)
+}
+
+// fillDuration 返回从from开始生成amount个令牌需要的时间
+func (l *TokenBucketLimiter) fillDuration(b bucket, from time.Time, amount float64) time.Duration {
+	if amount <= 0 {
+		return 0
+	}
+	elapsed := from.Sub(b.warmStart)
+	if elapsed >= l.config.WarmUp {
+		return time.Duration(amount / l.rate * float64(time.Second))
+	}
 
-	// 如果没有令牌，拒绝请求
-	if b.tokens < 1 {
-		waitTime := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
-		return false, waitTime
+	rest := l.warmUpTokens(l.config.WarmUp) - l.warmUpTokens(elapsed)
+	if amount >= rest {
+		return l.config.WarmUp - elapsed + time.Duration((amount-rest)/l.rate*float64(time.Second))
 	}
 
-	// 消耗令牌
-	b.tokens--
-	l.buckets[key] = b
-	return true, 0
+	// 预热期内速率线性增长，解一元二次方程
+	cold := l.rate / coldFactor
+	k := (l.rate - cold) / l.config.WarmUp.Seconds()
+	v := cold + k*elapsed.Seconds()
+	return time.Duration((math.Sqrt(v*v+2*k*amount) - v) / k * float64(time.Second))
+}
+
+// fullAt 返回令牌桶补满的时间
+func (l *TokenBucketLimiter) fullAt(b bucket) time.Time {
+	return b.lastRefill.Add(l.fillDuration(b, b.lastRefill, l.capacity-b.tokens))
+}
+
+// expireAt 返回令牌桶补满并经过保留时长的时间，之后状态可以被回收
+func (l *TokenBucketLimiter) expireAt(b bucket) time.Time {
+	return l.fullAt(b).Add(l.retention)
+}
+
+// Clock 实现Clocked接口
+func (l *TokenBucketLimiter) Clock() algorithms.Clock {
+	return l.clock
 }
 
 // Close 实现RateLimiter接口
 func (l *TokenBucketLimiter) Close() error {
-	l.mu.Lock()
-	defer l.mu.Unlock()
-	l.buckets = make(map[string]bucket)
-	return nil
+	return l.store.Close()
 }
 
 func min(a, b float64) float64 {
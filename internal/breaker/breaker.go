@@ -0,0 +1,199 @@
+package breaker
+
+import (
+	"sync"
+	"time"
+)
+
+// State 熔断器状态
+type State string
+
+const (
+	// Closed 正常转发，连续失败达到阈值时熔断
+	Closed State = "closed"
+	// Open 熔断中，直接拒绝请求，OpenTimeout之后进入半开状态
+	Open State = "open"
+	// HalfOpen 半开，放行少量探测请求，全部成功后恢复，任意一个失败时重新熔断
+	HalfOpen State = "half_open"
+)
+
+// Config 熔断器配置
+type Config struct {
+	// 连续失败多少次后熔断，为0时为5
+	FailureThreshold int
+	// 熔断持续的时间，之后进入半开状态，为0时为30秒
+	OpenTimeout time.Duration
+	// 半开状态下需要成功的探测请求数，同时最多有这么多个探测请求在途，为0时为1
+	HalfOpenProbes int
+	// 上游响应超时，超过时返回504并计为失败，为0时不限制
+	Timeout time.Duration
+	// 获取当前时间，为空时使用系统时间
+	Now func() time.Time
+}
+
+// Snapshot 熔断器的当前状态
+type Snapshot struct {
+	// 状态
+	State State
+	// 连续失败的次数
+	Failures int
+	// 进入当前状态的时间
+	Since time.Time
+	// 熔断中时为进入半开状态的时间，其他状态为零值
+	RetryAt time.Time
+}
+
+// Breaker 上游熔断器
+// 转发前调用Acquire，请求结束后调用Release上报结果，客户端断开等无法反映上游状态的情况调用Cancel
+type Breaker struct {
+	mu sync.Mutex
+	// 配置信息
+	config Config
+	// 当前状态
+	state State
+	// 状态的代数，每次切换状态加1，之前状态放行的请求的结果不再计入
+	generation uint64
+	// 连续失败的次数
+	failures int
+	// 进入当前状态的时间
+	since time.Time
+	// 半开状态在途的探测请求数
+	probes int
+	// 半开状态成功的探测请求数
+	successes int
+}
+
+// NewBreaker 创建一个新的熔断器
+func NewBreaker(config Config) *Breaker {
+	if config.FailureThreshold <= 0 {
+		config.FailureThreshold = 5
+	}
+	if config.OpenTimeout <= 0 {
+		config.OpenTimeout = 30 * time.Second
+	}
+	if config.HalfOpenProbes <= 0 {
+		config.HalfOpenProbes = 1
+	}
+	if config.Now == nil {
+		config.Now = time.Now
+	}
+
+	return &Breaker{
+		config: config,
+		state:  Closed,
+		since:  config.Now(),
+	}
+}
+
+// Timeout 返回上游响应超时
+func (b *Breaker) Timeout() time.Duration {
+	return b.config.Timeout
+}
+
+// Acquire 判断是否可以转发请求，允许时返回请求所属的代数，请求结束后需要用它调用Release或Cancel
+func (b *Breaker) Acquire() (uint64, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.advance()
+	switch b.state {
+	case Open:
+		return 0, false
+	case HalfOpen:
+		// 已经有足够的探测请求时等待探测结果
+		if b.probes+b.successes >= b.config.HalfOpenProbes {
+			return 0, false
+		}
+		b.probes++
+	}
+	return b.generation, true
+}
+
+// Release 上报请求结果，failed为上游返回5xx或超时
+func (b *Breaker) Release(generation uint64, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// 熔断或恢复之前放行的请求的结果不再计入
+	if generation != b.generation {
+		return
+	}
+
+	now := b.config.Now()
+	switch b.state {
+	case Closed:
+		if !failed {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.config.FailureThreshold {
+			b.setState(Open, now)
+		}
+	case HalfOpen:
+		b.probes--
+		if failed {
+			b.failures++
+			b.setState(Open, now)
+			return
+		}
+		b.successes++
+		if b.successes >= b.config.HalfOpenProbes {
+			b.setState(Closed, now)
+		}
+	}
+}
+
+// Cancel 放弃请求但不计入结果
+func (b *Breaker) Cancel(generation uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation == b.generation && b.state == HalfOpen {
+		b.probes--
+	}
+}
+
+// Reset 手动恢复为正常状态
+func (b *Breaker) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.setState(Closed, b.config.Now())
+}
+
+// Snapshot 返回当前状态
+func (b *Breaker) Snapshot() Snapshot {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.advance()
+	snapshot := Snapshot{
+		State:    b.state,
+		Failures: b.failures,
+		Since:    b.since,
+	}
+	if b.state == Open {
+		snapshot.RetryAt = b.since.Add(b.config.OpenTimeout)
+	}
+	return snapshot
+}
+
+// advance 熔断持续时间结束后进入半开状态
+func (b *Breaker) advance() {
+	now := b.config.Now()
+	if b.state == Open && !now.Before(b.since.Add(b.config.OpenTimeout)) {
+		b.setState(HalfOpen, now)
+	}
+}
+
+// setState 切换状态并清空探测计数，恢复正常时同时清空失败次数
+func (b *Breaker) setState(state State, now time.Time) {
+	b.state = state
+	b.generation++
+	b.since = now
+	b.probes = 0
+	b.successes = 0
+	if state == Closed {
+		b.failures = 0
+	}
+}
@@ -1,33 +1,73 @@
 package gateway
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/wureny/FluxGo/internal/adaptive"
+	"github.com/wureny/FluxGo/internal/breaker"
 	"github.com/wureny/FluxGo/internal/limiter"
+	"github.com/wureny/FluxGo/internal/shedder"
+	"github.com/wureny/FluxGo/internal/store/redisstore"
 )
 
 /*
+- 过载保护中间件：
+在限流之前按网关整体的在途请求数、goroutine数量和调度延迟判断是否过载，过载时对新请求返回503
+管理API、健康检查和配置的路径前缀不受过载保护
 - 限流中间件：
 对所有非管理API的请求进行限流检查
-使用客户端IP作为限流key
+规则路径支持 /users/:id 参数、/api/v1/* 前缀和以 ~ 开头的正则，请求按最具体的规则限流
+规则可以限制请求方法和主机，同一路径可以为不同的方法和主机配置不同的规则
+限流key默认为客户端IP，规则可以配置为请求头、查询参数、Cookie、JWT声明或它们的组合
+按规则配置的成本扣减配额（固定值、请求头或请求体大小），按请求体大小计费时大小未知的请求按规则的Max计，未配置时返回411
+按规则配置的优先级（请求头的值或路径前缀）对请求分类，低优先级的请求不能使用为高优先级保留的容量
 当请求被限流时返回429状态码
+整形模式的规则让请求按漏水速率排队放行，排队时间超过上限时才返回429
+并发限流的规则在代理响应结束后释放配额
+上游响应状态码属于规则的RefundOn类别，或客户端在转发前断开时，归还请求消耗的配额
+通过X-RateLimit-Limit、X-RateLimit-Remaining、X-RateLimit-Reset响应头返回key在规则下的配额状态
 - 管理API：
-POST /admin/rules：添加限流规则
+GET /health：健康检查
+POST /admin/rules：添加限流规则，规则可以包含多个需要同时满足的限流配置
+以下path参数为规则路径时，可以通过methods=GET,POST和host=参数指定限制了方法和主机的规则
 DELETE /admin/rules/path：删除限流规则
 GET /admin/rules/path：获取限流规则
+GET /admin/stats：获取各规则内存存储的key数量和淘汰统计
+GET /admin/inflight/path?key=：获取并发限流规则的在途请求数
+GET /admin/quota/path?key=：获取日历配额规则的已用、剩余配额和重置时间
+GET /admin/keys/key：获取客户端在所有规则下的剩余配额、恢复时间和等待时间，不消耗配额
+GET /admin/adaptive：获取各目标前缀当前计算出的自适应并发限制
+GET /admin/breakers：获取各目标前缀的熔断器状态
+POST /admin/breakers/reset/prefix：手动将目标前缀的熔断器恢复为正常状态
+POST /admin/refund/path?key=&n=：向客户端归还n个单位的配额，n默认为1
+GET /admin/shedding：获取过载保护的当前状态和按原因统计的拒绝请求数
 - 反向代理：
 将请求转发到配置的目标服务器
 支持基于路径前缀的路由
+按目标前缀配置自适应并发限制，根据上游响应延迟和错误率自动收缩或放大，超过时返回503
+按目标前缀配置熔断器，上游连续返回5xx或超时后熔断，熔断期间直接返回503，之后放行探测请求判断是否恢复
 - 配置灵活：
 支持配置监听地址
 支持配置多个目标服务器
+支持配置Redis存储，让多个网关副本共享限流状态
 */
 
+// healthPath 健康检查的路径
+const healthPath = "/health"
+
+// forwardedKey 请求已转发到上游的标记，用于判断客户端是否在转发前断开
+const forwardedKey = "fluxgo.forwarded"
+
 // Gateway API网关结构体
 type Gateway struct {
 	// 限流规则管理器
@@ -36,6 +76,12 @@ type Gateway struct {
 	engine *gin.Engine
 	// 目标服务器地址映射
 	targets map[string]*url.URL
+	// 各目标前缀的自适应并发限流器
+	adaptive map[string]*adaptive.Limiter
+	// 各目标前缀的熔断器
+	breakers map[string]*breaker.Breaker
+	// 过载保护，未配置时为空
+	shedder *shedder.Shedder
 }
 
 // Config 网关配置
@@ -44,6 +90,16 @@ type Config struct {
 	ListenAddr string
 	// 目标服务器地址映射 (路径前缀 -> 目标URL)
 	Targets map[string]string
+	// Redis存储配置，Addr为空时规则只能使用内存存储
+	Redis redisstore.Config
+	// 自适应并发限流配置 (目标路径前缀 -> 配置)，未配置的目标不限制
+	Adaptive map[string]adaptive.Config
+	// 熔断配置 (目标路径前缀 -> 配置)，未配置的目标不熔断
+	Breakers map[string]breaker.Config
+	// 过载保护配置，没有设置任何上限时不启用
+	Shedding shedder.Config
+	// JWT验证器配置 (名称 -> 配置)，供key来源为jwt的规则使用
+	JWT map[string]limiter.JWTConfig
 }
 
 // New 创建新的API网关
@@ -52,6 +108,8 @@ func New(config Config) (*Gateway, error) {
 		ruleManager: limiter.NewRuleManager(),
 		engine:      gin.Default(),
 		targets:     make(map[string]*url.URL),
+		adaptive:    make(map[string]*adaptive.Limiter),
+		breakers:    make(map[string]*breaker.Breaker),
 	}
 
 	// 解析并存储目标服务器URL
@@ -63,6 +121,42 @@ func New(config Config) (*Gateway, error) {
 		g.targets[path] = targetURL
 	}
 
+	// 每个目标前缀独立计算并发限制
+	for path, adaptiveConfig := range config.Adaptive {
+		if _, ok := g.targets[path]; !ok {
+			return nil, fmt.Errorf("adaptive limit configured for unknown target %s", path)
+		}
+		l, err := adaptive.NewLimiter(adaptiveConfig)
+		if err != nil {
+			return nil, fmt.Errorf("invalid adaptive limit for target %s: %v", path, err)
+		}
+		g.adaptive[path] = l
+	}
+
+	// 每个目标前缀独立熔断
+	for path, breakerConfig := range config.Breakers {
+		if _, ok := g.targets[path]; !ok {
+			return nil, fmt.Errorf("circuit breaker configured for unknown target %s", path)
+		}
+		g.breakers[path] = breaker.NewBreaker(breakerConfig)
+	}
+
+	if config.Shedding.Enabled() {
+		g.shedder = shedder.NewShedder(config.Shedding)
+	}
+
+	// 注册Redis存储，供 Store 为 redis 的规则使用
+	if config.Redis.Addr != "" {
+		g.ruleManager.RegisterStore(limiter.RedisStore, redisstore.New(config.Redis))
+	}
+
+	// 注册JWT验证器，需要在添加规则之前注册
+	for name, jwtConfig := range config.JWT {
+		if err := g.ruleManager.RegisterJWT(name, jwtConfig); err != nil {
+			return nil, err
+		}
+	}
+
 	// 设置中间件和路由
 	g.setupRoutes()
 
@@ -71,21 +165,73 @@ func New(config Config) (*Gateway, error) {
 
 // setupRoutes 设置路由和中间件
 func (g *Gateway) setupRoutes() {
+	// 过载保护中间件，在限流之前拒绝请求，避免过载时还要访问限流存储
+	if g.shedder != nil {
+		g.engine.Use(g.sheddingMiddleware())
+	}
+
 	// 限流中间件
 	g.engine.Use(g.rateLimitMiddleware())
 
+	// 健康检查
+	g.engine.GET(healthPath, func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+
 	// 管理API
 	admin := g.engine.Group("/admin")
 	{
 		admin.POST("/rules", g.addRule)
 		admin.DELETE("/rules/*path", g.removeRule)
 		admin.GET("/rules/*path", g.getRule)
+		admin.GET("/stats", g.getStats)
+		admin.GET("/inflight/*path", g.getInFlight)
+		admin.GET("/quota/*path", g.getQuota)
+		admin.GET("/heavy-hitters/*path", g.getHeavyHitters)
+		admin.GET("/keys/:key", g.getKeyStatus)
+		admin.GET("/adaptive", g.getAdaptive)
+		admin.GET("/breakers", g.getBreakers)
+		admin.POST("/breakers/reset/*prefix", g.resetBreaker)
+		admin.POST("/refund/*path", g.refund)
+		admin.GET("/shedding", g.getShedding)
 	}
 
 	// 所有其他请求都转发到目标服务器
 	g.engine.NoRoute(g.handleProxy)
 }
 
+// sheddingMiddleware 过载保护中间件
+func (g *Gateway) sheddingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// 管理API和健康检查在过载时仍然需要可用
+		path := c.Request.URL.Path
+		if strings.HasPrefix(path, "/admin") || path == healthPath {
+			c.Next()
+			return
+		}
+		for _, prefix := range g.shedder.Exempt() {
+			if strings.HasPrefix(path, prefix) {
+				c.Next()
+				return
+			}
+		}
+
+		reason, ok := g.shedder.Acquire()
+		if !ok {
+			log.Printf("网关过载，拒绝请求: path=%s, reason=%s", path, reason)
+			c.Header("Retry-After", "1")
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+				"error":  "gateway overloaded",
+				"reason": reason,
+			})
+			return
+		}
+		defer g.shedder.Release()
+
+		c.Next()
+	}
+}
+
 // rateLimitMiddleware 限流中间件
 func (g *Gateway) rateLimitMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -95,25 +241,81 @@ func (g *Gateway) rateLimitMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// 使用客户端IP作为限流key
-		key := c.ClientIP()
+		// 按请求方法、主机和路径匹配规则，并按规则配置的来源提取限流key，未配置或无法提取时使用客户端IP
+		// 请求处理期间规则被替换时，释放和归还仍然作用于判断时匹配到的限流器
+		route := limiter.Route(c.Request.Method, c.Request.Host, c.Request.URL.Path)
+		match, hasRule := g.ruleManager.Match(route, c.Request, c.ClientIP())
+		if !hasRule {
+			// 如果路径没有配置限流规则，默认允许通过
+			c.Next()
+			return
+		}
 
-		// 检查是否允许请求通过
-		allowed, waitTime := g.ruleManager.Allow(c, c.Request.URL.Path, key)
-		if !allowed {
-			c.Header("X-RateLimit-Retry-After", fmt.Sprintf("%d", int64(waitTime.Seconds())))
+		// 按规则计算请求成本
+		cost, err := match.Rule.Cost.Calculate(c.Request)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusLengthRequired, gin.H{"error": err.Error()})
+			return
+		}
+
+		// 检查是否允许请求通过，低优先级的请求需要为高优先级保留容量，整形模式下返回需要排队的时间
+		decision := match.Admit(c, cost, match.Rule.Priority.Reserve(c.Request))
+		if status := decision.Status; status != nil {
+			setRateLimitHeaders(c, status.Limit, status.Remaining, status.Reset)
+		}
+		if !decision.Allowed {
+			c.Header("X-RateLimit-Retry-After", fmt.Sprintf("%d", int64(decision.Wait.Seconds())))
 			c.AbortWithStatus(http.StatusTooManyRequests)
 			return
 		}
 
+		// 并发限流的规则在请求完成后释放配额，客户端断开或panic时同样会释放
+		defer match.Release(context.Background(), decision, cost)
+
+		// 按漏水速率排队放行
+		if decision.Wait > 0 {
+			timer := time.NewTimer(decision.Wait)
+			select {
+			case <-timer.C:
+			case <-c.Request.Context().Done():
+				timer.Stop()
+				// 客户端已经断开，归还排队占用的配额
+				match.RefundN(context.Background(), cost)
+				c.Abort()
+				return
+			}
+		}
+
 		c.Next()
+
+		// 并发限流的配额已经在请求完成时释放
+		if match.Rule.Algorithm != limiter.Concurrency && shouldRefund(c, match.Rule) {
+			match.RefundN(context.Background(), cost)
+		}
+	}
+}
+
+// shouldRefund 判断请求结束后是否归还配额
+// 客户端在转发到上游前断开的请求不计费，其他请求按响应状态码的类别判断
+func shouldRefund(c *gin.Context, rule limiter.Rule) bool {
+	if !c.GetBool(forwardedKey) && c.Request.Context().Err() != nil {
+		return true
 	}
+	return rule.Refunds(c.Writer.Status())
+}
+
+// setRateLimitHeaders 通过响应头返回配额状态，重置时间为向上取整的Unix秒数
+func setRateLimitHeaders(c *gin.Context, limit int64, remaining int64, reset time.Time) {
+	c.Header("X-RateLimit-Limit", fmt.Sprintf("%d", limit))
+	c.Header("X-RateLimit-Remaining", fmt.Sprintf("%d", remaining))
+	c.Header("X-RateLimit-Reset", fmt.Sprintf("%d", reset.Add(time.Second-1).Unix()))
 }
 
 // handleProxy 处理代理请求
 func (g *Gateway) handleProxy(c *gin.Context) {
 	path := c.Request.URL.Path
 	var targetURL *url.URL
+	var targetPrefix string
 
 	// 添加调试日志
 	log.Printf("收到请求: path=%s", path)
@@ -122,6 +324,7 @@ func (g *Gateway) handleProxy(c *gin.Context) {
 	for prefix, target := range g.targets {
 		if len(path) >= len(prefix) && path[:len(prefix)] == prefix {
 			targetURL = target
+			targetPrefix = prefix
 			log.Printf("找到目标服务器: prefix=%s, target=%s", prefix, target)
 			break
 		}
@@ -133,9 +336,75 @@ func (g *Gateway) handleProxy(c *gin.Context) {
 		return
 	}
 
+	// 客户端已经断开时不再转发
+	ctx := c.Request.Context()
+	if ctx.Err() != nil {
+		log.Printf("客户端在转发前断开: path=%s", path)
+		c.Abort()
+		return
+	}
+
+	// 上游持续出错时熔断，直接返回503
+	req := c.Request
+	if br, ok := g.breakers[targetPrefix]; ok {
+		generation, allowed := br.Acquire()
+		if !allowed {
+			snapshot := br.Snapshot()
+			if wait := time.Until(snapshot.RetryAt); wait > 0 {
+				c.Header("Retry-After", fmt.Sprintf("%d", int64((wait+time.Second-1)/time.Second)))
+			}
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error":  "circuit breaker is open",
+				"target": targetPrefix,
+				"state":  snapshot.State,
+			})
+			return
+		}
+		defer func() {
+			// 没有转发或客户端断开时的结果不能反映上游状态
+			if !c.GetBool(forwardedKey) || ctx.Err() != nil {
+				br.Cancel(generation)
+				return
+			}
+			br.Release(generation, c.Writer.Status() >= http.StatusInternalServerError)
+		}()
+
+		if timeout := br.Timeout(); timeout > 0 {
+			timeoutCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			req = c.Request.WithContext(timeoutCtx)
+		}
+	}
+
+	// 按上游的响应延迟和错误率限制转发的并发数
+	if al, ok := g.adaptive[targetPrefix]; ok {
+		if !al.Acquire() {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "upstream concurrency limit exceeded"})
+			return
+		}
+		start := time.Now()
+		defer func() {
+			// 客户端断开时的结果不能反映上游状态
+			if ctx.Err() != nil {
+				al.Cancel()
+				return
+			}
+			al.Release(time.Since(start), c.Writer.Status() >= http.StatusInternalServerError)
+		}()
+	}
+
 	// 创建反向代理
 	proxy := httputil.NewSingleHostReverseProxy(targetURL)
-	proxy.ServeHTTP(c.Writer, c.Request)
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		log.Printf("转发请求失败: path=%s, error=%v", path, err)
+		if errors.Is(err, context.DeadlineExceeded) {
+			c.JSON(http.StatusGatewayTimeout, gin.H{"error": "upstream timeout", "target": targetPrefix})
+			return
+		}
+		c.JSON(http.StatusBadGateway, gin.H{"error": "upstream unavailable", "target": targetPrefix})
+	}
+	c.Set(forwardedKey, true)
+	proxy.ServeHTTP(c.Writer, req)
 }
 
 // addRule 添加限流规则
@@ -152,8 +421,9 @@ func (g *Gateway) addRule(c *gin.Context) {
 		return
 	}
 
+	// AddRule只在规则配置无效时返回错误
 	if err := g.ruleManager.AddRule(path, rule); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
@@ -162,14 +432,14 @@ func (g *Gateway) addRule(c *gin.Context) {
 
 // removeRule 移除限流规则
 func (g *Gateway) removeRule(c *gin.Context) {
-	path := c.Param("path")
+	path := ruleID(c)
 	g.ruleManager.RemoveRule(path)
 	c.Status(http.StatusOK)
 }
 
 // getRule 获取限流规则
 func (g *Gateway) getRule(c *gin.Context) {
-	path := c.Param("path")
+	path := ruleID(c)
 	rule, exists := g.ruleManager.GetRule(path)
 	if !exists {
 		c.JSON(http.StatusNotFound, gin.H{"error": "rule not found"})
@@ -178,6 +448,119 @@ func (g *Gateway) getRule(c *gin.Context) {
 	c.JSON(http.StatusOK, rule)
 }
 
+// ruleID 返回管理API指定的规则标识，由path路径参数以及methods和host查询参数组成
+func ruleID(c *gin.Context) string {
+	var methods []string
+	if m := c.Query("methods"); m != "" {
+		methods = strings.Split(m, ",")
+	}
+	return limiter.RuleID(c.Param("path"), methods, c.Query("host"))
+}
+
+// getStats 获取各规则内存存储的统计信息
+func (g *Gateway) getStats(c *gin.Context) {
+	c.JSON(http.StatusOK, g.ruleManager.Stats())
+}
+
+// getInFlight 获取并发限流规则的在途请求数，通过key参数指定客户端
+func (g *Gateway) getInFlight(c *gin.Context) {
+	path := ruleID(c)
+	inFlight, exists := g.ruleManager.InFlight(c, path, c.Query("key"))
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "concurrency rule not found"})
+		return
+	}
+	c.JSON(http.StatusOK, inFlight)
+}
+
+// getQuota 获取日历配额规则的使用情况，通过key参数指定客户端
+func (g *Gateway) getQuota(c *gin.Context) {
+	path := ruleID(c)
+	status, exists := g.ruleManager.Usage(c, path, c.Query("key"))
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "quota rule not found"})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// getHeavyHitters 获取计数草图规则下请求量最大的客户端
+func (g *Gateway) getHeavyHitters(c *gin.Context) {
+	path := ruleID(c)
+	hitters, exists := g.ruleManager.TopK(c, path)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "count-min rule not found"})
+		return
+	}
+	c.JSON(http.StatusOK, hitters)
+}
+
+// getKeyStatus 获取客户端在所有规则下的当前状态
+func (g *Gateway) getKeyStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, g.ruleManager.KeyStatus(c, c.Param("key")))
+}
+
+// getAdaptive 获取各目标前缀的自适应并发限制
+func (g *Gateway) getAdaptive(c *gin.Context) {
+	snapshots := make(map[string]adaptive.Snapshot, len(g.adaptive))
+	for prefix, al := range g.adaptive {
+		snapshots[prefix] = al.Snapshot()
+	}
+	c.JSON(http.StatusOK, snapshots)
+}
+
+// getBreakers 获取各目标前缀的熔断器状态
+func (g *Gateway) getBreakers(c *gin.Context) {
+	snapshots := make(map[string]breaker.Snapshot, len(g.breakers))
+	for prefix, br := range g.breakers {
+		snapshots[prefix] = br.Snapshot()
+	}
+	c.JSON(http.StatusOK, snapshots)
+}
+
+// resetBreaker 手动将目标前缀的熔断器恢复为正常状态
+func (g *Gateway) resetBreaker(c *gin.Context) {
+	prefix := c.Param("prefix")
+	br, exists := g.breakers[prefix]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "circuit breaker not found"})
+		return
+	}
+	br.Reset()
+	c.JSON(http.StatusOK, br.Snapshot())
+}
+
+// refund 向客户端归还配额，通过key参数指定客户端，n参数指定归还的单位数
+func (g *Gateway) refund(c *gin.Context) {
+	path := ruleID(c)
+	if _, exists := g.ruleManager.GetRule(path); !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "rule not found"})
+		return
+	}
+
+	n := int64(1)
+	if v := c.Query("n"); v != "" {
+		parsed, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || parsed < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "n must be a positive integer"})
+			return
+		}
+		n = parsed
+	}
+
+	g.ruleManager.RefundN(c, path, c.Query("key"), n)
+	c.Status(http.StatusOK)
+}
+
+// getShedding 获取过载保护的当前状态
+func (g *Gateway) getShedding(c *gin.Context) {
+	if g.shedder == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "load shedding not configured"})
+		return
+	}
+	c.JSON(http.StatusOK, g.shedder.Snapshot())
+}
+
 // Run 启动API网关
 func (g *Gateway) Run(addr string) error {
 	return g.engine.Run(addr)
@@ -185,6 +568,9 @@ func (g *Gateway) Run(addr string) error {
 
 // Close 关闭API网关
 func (g *Gateway) Close() error {
+	if g.shedder != nil {
+		g.shedder.Close()
+	}
 	return g.ruleManager.Close()
 }
 
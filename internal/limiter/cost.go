@@ -0,0 +1,73 @@
+package limiter
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// ErrUnknownLength 按请求体大小计费时请求体大小未知，并且规则没有配置此时的成本
+var ErrUnknownLength = errors.New("request body length is unknown")
+
+// CostSource 请求成本的来源
+type CostSource string
+
+const (
+	// 固定成本
+	FixedCost CostSource = "fixed"
+	// 从请求头读取成本
+	HeaderCost CostSource = "header"
+	// 按请求体大小计算成本
+	ContentLengthCost CostSource = "content_length"
+)
+
+// Cost 请求成本配置，批量接口可以比普通请求消耗更多的配额
+type Cost struct {
+	// 成本来源，为空时使用固定成本
+	Source CostSource
+	// 固定成本；来源为请求头或请求体大小时，作为无法计算成本时的默认值。为0时视为1
+	Value int64
+	// 请求头名称，来源为header时使用
+	Header string
+	// 每个单位对应的字节数，来源为content_length时使用。为0时按1字节计
+	Unit int64
+	// 请求体大小未知（如分块传输编码）时的成本，来源为content_length时使用。为0时拒绝这类请求，
+	// 避免客户端通过分块传输绕过按大小计费，通常设置为允许的最大请求体对应的成本
+	Max int64
+}
+
+// Calculate 计算请求的成本，结果至少为1
+// 按请求体大小计费时请求体大小未知并且没有配置Max时返回ErrUnknownLength
+func (c Cost) Calculate(r *http.Request) (int64, error) {
+	fallback := c.Value
+	if fallback < 1 {
+		fallback = 1
+	}
+
+	switch c.Source {
+	case HeaderCost:
+		n, err := strconv.ParseInt(r.Header.Get(c.Header), 10, 64)
+		if err != nil || n < 1 {
+			return fallback, nil
+		}
+		return n, nil
+	case ContentLengthCost:
+		if r.ContentLength < 0 {
+			if c.Max < 1 {
+				return 0, ErrUnknownLength
+			}
+			return c.Max, nil
+		}
+		if r.ContentLength == 0 {
+			return fallback, nil
+		}
+		unit := c.Unit
+		if unit < 1 {
+			unit = 1
+		}
+		// 不足一个单位的部分按一个单位计
+		return (r.ContentLength + unit - 1) / unit, nil
+	default:
+		return fallback, nil
+	}
+}
@@ -0,0 +1,231 @@
+package limiter
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256" // 注册SHA-256
+	_ "crypto/sha512" // 注册SHA-384和SHA-512
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// JWTConfig JWT验证配置
+type JWTConfig struct {
+	// 签名算法：HS256、HS384、HS512、RS256、RS384、RS512，token头部的alg必须与之相同
+	Algorithm string
+	// HMAC密钥，HS算法使用
+	Secret string
+	// PEM格式的RSA公钥，RS算法使用
+	PublicKey string
+	// 签发者，不为空时校验iss声明
+	Issuer string
+	// 受众，不为空时校验aud声明
+	Audience string
+	// 校验exp和nbf时允许的时钟偏差
+	Leeway time.Duration
+	// 获取当前时间，为空时使用系统时间
+	Now func() time.Time
+}
+
+// JWTVerifier 验证JWT的签名和有效期，并读取其中的声明
+type JWTVerifier struct {
+	// 配置信息
+	config JWTConfig
+	// 签名使用的哈希算法
+	hash crypto.Hash
+	// RSA公钥，HS算法时为空
+	publicKey *rsa.PublicKey
+}
+
+// NewJWTVerifier 创建JWT验证器
+func NewJWTVerifier(config JWTConfig) (*JWTVerifier, error) {
+	v := &JWTVerifier{config: config}
+	if v.config.Now == nil {
+		v.config.Now = time.Now
+	}
+
+	if len(config.Algorithm) != 5 {
+		return nil, fmt.Errorf("unsupported algorithm: %s", config.Algorithm)
+	}
+	switch config.Algorithm[2:] {
+	case "256":
+		v.hash = crypto.SHA256
+	case "384":
+		v.hash = crypto.SHA384
+	case "512":
+		v.hash = crypto.SHA512
+	default:
+		return nil, fmt.Errorf("unsupported algorithm: %s", config.Algorithm)
+	}
+
+	switch config.Algorithm[:2] {
+	case "HS":
+		if config.Secret == "" {
+			return nil, errors.New("secret is required")
+		}
+	case "RS":
+		block, _ := pem.Decode([]byte(config.PublicKey))
+		if block == nil {
+			return nil, errors.New("public key must be PEM encoded")
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse public key failed: %v", err)
+		}
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return nil, errors.New("public key is not an RSA key")
+		}
+		v.publicKey = rsaKey
+	default:
+		return nil, fmt.Errorf("unsupported algorithm: %s", config.Algorithm)
+	}
+	return v, nil
+}
+
+// Claim 验证token并返回声明name的值，数字声明转为十进制字符串
+func (v *JWTVerifier) Claim(token string, name string) (string, error) {
+	claims, err := v.Verify(token)
+	if err != nil {
+		return "", err
+	}
+
+	switch value := claims[name].(type) {
+	case string:
+		return value, nil
+	case json.Number:
+		return value.String(), nil
+	case nil:
+		return "", fmt.Errorf("claim %s not found", name)
+	default:
+		return "", fmt.Errorf("claim %s is not a string or number", name)
+	}
+}
+
+// Verify 验证token的签名、有效期、签发者和受众，返回所有声明
+func (v *JWTVerifier) Verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("invalid header: %v", err)
+	}
+	// 只接受配置的算法，避免alg为none或用公钥作为HMAC密钥的攻击
+	if header.Alg != v.config.Algorithm {
+		return nil, fmt.Errorf("unexpected algorithm: %s", header.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature encoding: %v", err)
+	}
+	if err := v.verifySignature(parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("invalid claims: %v", err)
+	}
+	if err := v.validateClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// verifySignature 验证签名
+func (v *JWTVerifier) verifySignature(signed string, signature []byte) error {
+	if v.publicKey != nil {
+		h := v.hash.New()
+		h.Write([]byte(signed))
+		if err := rsa.VerifyPKCS1v15(v.publicKey, v.hash, h.Sum(nil), signature); err != nil {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+
+	mac := hmac.New(v.hash.New, []byte(v.config.Secret))
+	mac.Write([]byte(signed))
+	if !hmac.Equal(mac.Sum(nil), signature) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// validateClaims 校验有效期、签发者和受众
+func (v *JWTVerifier) validateClaims(claims map[string]interface{}) error {
+	now := v.config.Now()
+	if exp, exists := claims["exp"]; exists {
+		t, ok := numericDate(exp)
+		if !ok || !now.Before(unixTime(t).Add(v.config.Leeway)) {
+			return errors.New("token is expired")
+		}
+	}
+	if nbf, exists := claims["nbf"]; exists {
+		t, ok := numericDate(nbf)
+		if !ok || now.Add(v.config.Leeway).Before(unixTime(t)) {
+			return errors.New("token is not valid yet")
+		}
+	}
+	if v.config.Issuer != "" && claims["iss"] != v.config.Issuer {
+		return errors.New("unexpected issuer")
+	}
+	if v.config.Audience != "" && !hasAudience(claims["aud"], v.config.Audience) {
+		return errors.New("unexpected audience")
+	}
+	return nil
+}
+
+// decodeSegment 解码base64url编码的JSON，数字保留为json.Number
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// numericDate 解析exp和nbf等时间声明，只接受JSON数字，字符串和null等其他类型视为无效
+func numericDate(claim interface{}) (float64, bool) {
+	n, ok := claim.(json.Number)
+	if !ok {
+		return 0, false
+	}
+	t, err := n.Float64()
+	return t, err == nil
+}
+
+// unixTime 将秒数转换为时间
+func unixTime(seconds float64) time.Time {
+	return time.Unix(0, int64(seconds*float64(time.Second)))
+}
+
+// hasAudience 判断aud声明是否包含audience，aud可以是字符串或字符串数组
+func hasAudience(aud interface{}, audience string) bool {
+	switch value := aud.(type) {
+	case string:
+		return value == audience
+	case []interface{}:
+		for _, a := range value {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
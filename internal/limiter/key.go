@@ -0,0 +1,203 @@
+package limiter
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// KeySource 限流key的来源
+type KeySource string
+
+const (
+	// 客户端IP
+	IPKey KeySource = "ip"
+	// 请求头的值，例如X-API-Key
+	HeaderKey KeySource = "header"
+	// 查询参数的值
+	QueryKey KeySource = "query"
+	// Cookie的值
+	CookieKey KeySource = "cookie"
+	// 验证签名后的JWT声明，例如sub，token从Authorization: Bearer请求头读取
+	JWTKey KeySource = "jwt"
+	// 多个来源的组合，例如API key加用户ID，任意一部分缺失时视为缺失
+	CompositeKey KeySource = "composite"
+)
+
+// Key 限流key的配置，客户端在共享NAT之后时可以按API key或用户限流
+// 除客户端IP外，key的值以来源作为前缀，例如"header:abc"，避免与回退使用的客户端IP混淆
+type Key struct {
+	// key的来源，为空时使用客户端IP。也可以是通过RuleManager.RegisterExtractor注册的名称
+	Source KeySource
+	// 请求头、查询参数、Cookie或JWT声明的名称，自定义来源时原样传给提取器
+	Name string
+	// JWT验证器的名称，为空时使用"default"。来源为jwt时使用
+	Verifier string
+	// 组合的各部分，来源为composite时使用
+	Parts []Key
+	// 无法提取key时使用的配置，为空时使用客户端IP
+	Fallback *Key
+}
+
+// KeyExtractor 从请求中提取限流key，嵌入网关的程序可以注册自定义的提取器
+type KeyExtractor interface {
+	// Extract 返回请求的key，无法提取时返回false。name为规则中配置的Name
+	Extract(r *http.Request, name string) (string, bool)
+}
+
+// KeyExtractorFunc 将函数转换为KeyExtractor
+type KeyExtractorFunc func(r *http.Request, name string) (string, bool)
+
+// Extract 实现KeyExtractor接口
+func (f KeyExtractorFunc) Extract(r *http.Request, name string) (string, bool) {
+	return f(r, name)
+}
+
+// defaultVerifier 未指定JWT验证器时使用的名称
+const defaultVerifier = "default"
+
+// RegisterExtractor 注册自定义的key提取器，规则的Key.Source为name时使用
+func (rm *RuleManager) RegisterExtractor(name string, e KeyExtractor) error {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	switch KeySource(name) {
+	case "", IPKey, HeaderKey, QueryKey, CookieKey, JWTKey, CompositeKey:
+		return fmt.Errorf("key source %q is reserved", name)
+	}
+	rm.extractors[KeySource(name)] = e
+	return nil
+}
+
+// RegisterJWT 注册JWT验证器，规则的Key.Verifier为name时使用
+func (rm *RuleManager) RegisterJWT(name string, config JWTConfig) error {
+	v, err := NewJWTVerifier(config)
+	if err != nil {
+		return fmt.Errorf("invalid jwt verifier %s: %v", name, err)
+	}
+
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rm.verifiers[name] = v
+	return nil
+}
+
+// extractKey 按配置提取key，依次尝试回退配置，都无法提取时返回客户端IP，调用方需要持有锁
+func (rm *RuleManager) extractKey(k Key, r *http.Request, clientIP string) string {
+	for current := &k; current != nil; current = current.Fallback {
+		if current.Source == "" || current.Source == IPKey {
+			return clientIP
+		}
+		if key, ok := rm.extract(*current, r, clientIP); ok {
+			return key
+		}
+	}
+	return clientIP
+}
+
+// extract 按单个来源提取key，不使用回退配置
+func (rm *RuleManager) extract(k Key, r *http.Request, clientIP string) (string, bool) {
+	var value string
+	switch k.Source {
+	case "", IPKey:
+		return clientIP, true
+	case HeaderKey:
+		value = r.Header.Get(k.Name)
+	case QueryKey:
+		value = r.URL.Query().Get(k.Name)
+	case CookieKey:
+		if cookie, err := r.Cookie(k.Name); err == nil {
+			value = cookie.Value
+		}
+	case JWTKey:
+		verifier := k.Verifier
+		if verifier == "" {
+			verifier = defaultVerifier
+		}
+		v, exists := rm.verifiers[verifier]
+		if !exists {
+			return "", false
+		}
+		token, ok := bearerToken(r.Header.Get("Authorization"))
+		if !ok {
+			return "", false
+		}
+		value, _ = v.Claim(token, k.Name)
+	case CompositeKey:
+		// 各部分以长度作为前缀，部分的值中包含分隔符时也不会与其他组合相同
+		values := make([]string, 0, len(k.Parts))
+		for _, part := range k.Parts {
+			v, ok := rm.extract(part, r, clientIP)
+			if !ok {
+				return "", false
+			}
+			values = append(values, strconv.Itoa(len(v))+":"+v)
+		}
+		value = strings.Join(values, "|")
+	default:
+		e, exists := rm.extractors[k.Source]
+		if !exists {
+			return "", false
+		}
+		value, _ = e.Extract(r, k.Name)
+	}
+
+	if value == "" {
+		return "", false
+	}
+	return string(k.Source) + ":" + value, true
+}
+
+// bearerToken 返回Authorization请求头中的Bearer token，认证方案不区分大小写(RFC 6750)
+func bearerToken(authorization string) (string, bool) {
+	scheme, token, ok := strings.Cut(authorization, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return token, true
+}
+
+// validateKey 校验key配置，调用方需要持有锁
+func (rm *RuleManager) validateKey(k Key) error {
+	switch k.Source {
+	case "", IPKey:
+	case HeaderKey, QueryKey, CookieKey:
+		if k.Name == "" {
+			return fmt.Errorf("invalid key: %s source requires a name", k.Source)
+		}
+	case JWTKey:
+		if k.Name == "" {
+			return fmt.Errorf("invalid key: jwt source requires a claim name")
+		}
+		verifier := k.Verifier
+		if verifier == "" {
+			verifier = defaultVerifier
+		}
+		if _, exists := rm.verifiers[verifier]; !exists {
+			return fmt.Errorf("invalid key: unknown jwt verifier %s", verifier)
+		}
+	case CompositeKey:
+		if len(k.Parts) == 0 {
+			return fmt.Errorf("invalid key: composite source requires parts")
+		}
+		for _, part := range k.Parts {
+			// 组合的各部分缺失时整体回退，各部分不能单独回退
+			if part.Fallback != nil {
+				return fmt.Errorf("invalid key: composite parts do not support fallback")
+			}
+			if err := rm.validateKey(part); err != nil {
+				return err
+			}
+		}
+	default:
+		if _, exists := rm.extractors[k.Source]; !exists {
+			return fmt.Errorf("invalid key: unsupported source %s", k.Source)
+		}
+	}
+
+	if k.Fallback != nil {
+		return rm.validateKey(*k.Fallback)
+	}
+	return nil
+}
@@ -3,24 +3,70 @@ package limiter
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/wureny/FluxGo/internal/algorithms"
+	"github.com/wureny/FluxGo/internal/algorithms/composite"
+	"github.com/wureny/FluxGo/internal/algorithms/concurrency"
+	"github.com/wureny/FluxGo/internal/algorithms/countmin"
+	"github.com/wureny/FluxGo/internal/algorithms/fair"
+	"github.com/wureny/FluxGo/internal/algorithms/fixedwindow"
+	"github.com/wureny/FluxGo/internal/algorithms/gcra"
 	"github.com/wureny/FluxGo/internal/algorithms/leakybucket"
+	"github.com/wureny/FluxGo/internal/algorithms/quota"
 	"github.com/wureny/FluxGo/internal/algorithms/slidinglog"
 	"github.com/wureny/FluxGo/internal/algorithms/slidingwindow"
 	"github.com/wureny/FluxGo/internal/algorithms/tokenbucket"
+	"github.com/wureny/FluxGo/internal/store"
+	"github.com/wureny/FluxGo/internal/store/memory"
 )
 
 // Algorithm 限流算法类型
 type Algorithm string
 
 const (
-	SlidingLog    Algorithm = "sliding_log"
+	SlidingLog  Algorithm = "sliding_log"
+	FixedWindow Algorithm = "fixed_window"
+	LeakyBucket Algorithm = "leaky_bucket"
+	TokenBucket Algorithm = "token_bucket"
+	// 加权滑动窗口计数
+	SlidingWindowCounter Algorithm = "sliding_window_counter"
+	// 通用信元速率算法
+	GCRA Algorithm = "gcra"
+	// 按在途请求数限流，请求完成后释放配额
+	Concurrency Algorithm = "concurrency"
+	// 按日历周期（小时、天、周、月）计数的配额
+	Quota Algorithm = "quota"
+	// 在key之间按最大最小公平分配全局上限
+	Fair Algorithm = "fair"
+	// 基于计数草图的滑动窗口计数，内存占用固定，适合有大量不同客户端的接口
+	CountMin Algorithm = "count_min"
+
+	// Deprecated: 实际为固定窗口计数，保留以兼容已有配置，请使用 FixedWindow
 	SlidingWindow Algorithm = "sliding_window"
-	LeakyBucket   Algorithm = "leaky_bucket"
-	TokenBucket   Algorithm = "token_bucket"
+)
+
+// StoreType 限流状态存储类型
+type StoreType string
+
+const (
+	// 进程内存储，各网关副本独立计数
+	MemoryStore StoreType = "memory"
+	// Redis存储，多个网关副本共享计数
+	RedisStore StoreType = "redis"
+)
+
+// KeyScope 匹配多个路径的规则如何区分key
+type KeyScope string
+
+const (
+	// 规则匹配的所有路径共用key的配额
+	PatternScope KeyScope = "pattern"
+	// 每个具体路径分别计算key的配额，例如 /users/:id 下每个用户ID各自限流
+	PathScope KeyScope = "path"
 )
 
 // Rule 限流规则
@@ -29,93 +75,528 @@ type Rule struct {
 	Algorithm Algorithm
 	// 限流配置
 	Config algorithms.Config
+	// 多个限流配置，请求需要同时满足所有配置，例如每秒10个并且每天5000个
+	// 设置后忽略Config
+	Limits []algorithms.Config
+	// 状态存储类型，为空时使用内存存储
+	Store StoreType
+	// 状态存储不可用时的处理策略，为空时放行请求
+	StoreFailure algorithms.FailurePolicy
+	// 请求成本，为空时每个请求消耗1个单位
+	Cost Cost
+	// 内存存储最多保存的key数量，超过时淘汰最久未访问的key。为0时不限制
+	MaxKeys int
+	// 整形模式下请求最多排队等待的时间，超过时才拒绝请求。为0时不排队
+	// 仅漏桶算法支持，请求按漏水速率依次放行，适合不能承受突发流量的上游
+	MaxDelay time.Duration
+	// 需要归还配额的响应状态码类别，如"5xx"，响应状态码属于这些类别的请求不计费
+	// 并发限流在请求完成后总会释放配额，不支持设置
+	RefundOn []string
+	// 请求优先级，低优先级的请求不能使用为高优先级保留的容量。不支持整形模式
+	Priority Priority
+	// 规则路径匹配多个具体路径时key的范围，为空时所有路径共用配额
+	KeyScope KeyScope
+	// 规则适用的请求方法，为空时适用于所有方法
+	Methods []string
+	// 规则适用的主机，支持*.example.com匹配所有子域名，为空时适用于所有主机
+	Host string
+	// 限流key的来源，为空时使用客户端IP
+	Key Key
+	// 是否省略X-RateLimit配额响应头。返回配额状态需要额外查询一次存储，不需要响应头的规则可以省略
+	// 日历配额在判断时同时返回状态，省略与否都不需要额外查询
+	OmitHeaders bool
+}
+
+// InFlight 并发限流的在途请求数
+type InFlight struct {
+	// 查询的key
+	Key string
+	// key的在途请求数
+	Count int64
+	// 所有key合计的在途请求数
+	Global int64
+}
+
+// scopedKey 返回请求路径为path时key在规则下实际使用的key
+// 按具体路径区分时在key后加上路径，不同路径的状态互不影响
+func (r Rule) scopedKey(path string, key string) string {
+	if r.KeyScope == PathScope {
+		return key + "@" + path
+	}
+	return key
+}
+
+// Configs 返回规则的所有限流配置，未设置Limits时只有Config
+func (r Rule) Configs() []algorithms.Config {
+	if len(r.Limits) > 0 {
+		return r.Limits
+	}
+	return []algorithms.Config{r.Config}
+}
+
+// Refunds 判断响应状态码为status的请求是否需要归还配额
+func (r Rule) Refunds(status int) bool {
+	class := fmt.Sprintf("%dxx", status/100)
+	for _, c := range r.RefundOn {
+		if strings.EqualFold(c, class) {
+			return true
+		}
+	}
+	return false
 }
 
 // RuleManager 限流规则管理器
+// 规则路径支持参数、前缀和正则，请求路径按最具体的规则匹配，语法见matcher；
+// 同一路径可以按请求方法和主机配置多个规则，以RuleID返回的标识区分，见route。
+// 各方法的path参数可以是规则标识、Route返回的请求标识或请求路径
 type RuleManager struct {
 	mu sync.RWMutex
-	// 路径 -> 限流规则的映射
+	// 规则标识 -> 限流规则的映射
 	rules map[string]Rule
-	// 路径 -> 限流器实例的映射
+	// 规则标识 -> 规则路径的映射
+	paths map[string]string
+	// 规则路径 -> 该路径下的规则标识，按方法和主机的具体程度排列
+	variants map[string][]string
+	// 查找匹配请求路径的规则路径
+	matcher *matcher
+	// 规则标识 -> 限流器实例的映射
 	limiters map[string]algorithms.RateLimiter
+	// 存储类型 -> 共享存储的映射
+	stores map[StoreType]store.Store
+	// 规则标识 -> 内存存储的映射，用于统计
+	memoryStores map[string]*memory.MemoryStore
+	// 来源名称 -> 自定义key提取器的映射
+	extractors map[KeySource]KeyExtractor
+	// 名称 -> JWT验证器的映射
+	verifiers map[string]*JWTVerifier
 }
 
 // NewRuleManager 创建新的规则管理器
 func NewRuleManager() *RuleManager {
 	return &RuleManager{
-		rules:    make(map[string]Rule),
-		limiters: make(map[string]algorithms.RateLimiter),
+		rules:        make(map[string]Rule),
+		paths:        make(map[string]string),
+		variants:     make(map[string][]string),
+		matcher:      newMatcher(),
+		limiters:     make(map[string]algorithms.RateLimiter),
+		stores:       make(map[StoreType]store.Store),
+		memoryStores: make(map[string]*memory.MemoryStore),
+		extractors:   make(map[KeySource]KeyExtractor),
+		verifiers:    make(map[string]*JWTVerifier),
 	}
 }
 
-// AddRule 添加限流规则
+// RegisterStore 注册共享存储，规则可以通过Store字段选择使用
+// 注册后存储由RuleManager负责关闭
+func (rm *RuleManager) RegisterStore(storeType StoreType, s store.Store) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	if old, exists := rm.stores[storeType]; exists {
+		old.Close()
+	}
+	rm.stores[storeType] = s
+}
+
+// AddRule 添加限流规则，路径、方法和主机都相同的规则会被替换
 func (rm *RuleManager) AddRule(path string, rule Rule) error {
 	rm.mu.Lock()
 	defer rm.mu.Unlock()
 
+	rule.Methods = normalizeMethods(rule.Methods)
+	rule.Host = strings.ToLower(rule.Host)
+	if err := validateMatch(rule.Methods, rule.Host); err != nil {
+		return err
+	}
+	if err := validatePattern(path); err != nil {
+		return err
+	}
+	if other, conflicts := rm.matcher.conflict(path); conflicts {
+		return fmt.Errorf("path %s conflicts with existing rule %s", path, other)
+	}
+	id := RuleID(path, rule.Methods, rule.Host)
+	for _, other := range rm.variants[path] {
+		if other != id && rm.rules[other].overlaps(rule) {
+			return fmt.Errorf("rule %s overlaps with existing rule %s", id, other)
+		}
+	}
+
 	// 创建对应的限流器实例
-	limiter, err := rm.createLimiter(rule)
+	limiter, ms, err := rm.createLimiter(id, rule)
 	if err != nil {
 		return err
 	}
 
 	// 如果已存在旧的限流器，先关闭它
-	if oldLimiter, exists := rm.limiters[path]; exists {
-		oldLimiter.Close()
-	}
+	rm.closeLimiter(id)
 
-	rm.rules[path] = rule
-	rm.limiters[path] = limiter
+	if _, exists := rm.rules[id]; !exists {
+		rm.variants[path] = append(rm.variants[path], id)
+	}
+	rm.rules[id] = rule
+	rm.paths[id] = path
+	rm.sortVariants(path)
+	rm.matcher.add(path)
+	rm.limiters[id] = limiter
+	if ms != nil {
+		rm.memoryStores[id] = ms
+	} else {
+		delete(rm.memoryStores, id)
+	}
 	return nil
 }
 
-// RemoveRule 移除限流规则
-func (rm *RuleManager) RemoveRule(path string) {
+// RemoveRule 移除限流规则，id为RuleID返回的规则标识，不限制方法和主机的规则即为路径
+func (rm *RuleManager) RemoveRule(id string) {
 	rm.mu.Lock()
 	defer rm.mu.Unlock()
 
-	if limiter, exists := rm.limiters[path]; exists {
+	rm.closeLimiter(id)
+	if path, exists := rm.paths[id]; exists {
+		variants := rm.variants[path]
+		for i, v := range variants {
+			if v == id {
+				variants = append(variants[:i], variants[i+1:]...)
+				break
+			}
+		}
+		// 路径下没有其他规则时才从路径树中删除
+		if len(variants) == 0 {
+			delete(rm.variants, path)
+			rm.matcher.remove(path)
+		} else {
+			rm.variants[path] = variants
+		}
+	}
+	delete(rm.limiters, id)
+	delete(rm.rules, id)
+	delete(rm.paths, id)
+	delete(rm.memoryStores, id)
+}
+
+// sortVariants 按方法和主机的具体程度排列路径下的规则，具体程度相同时按标识排列，保证匹配结果确定
+func (rm *RuleManager) sortVariants(path string) {
+	variants := rm.variants[path]
+	sort.Slice(variants, func(i, j int) bool {
+		a, b := rm.rules[variants[i]], rm.rules[variants[j]]
+		if a.specificity() != b.specificity() {
+			return a.specificity() > b.specificity()
+		}
+		if len(a.Host) != len(b.Host) {
+			return len(a.Host) > len(b.Host)
+		}
+		return variants[i] < variants[j]
+	})
+}
+
+// closeLimiter 关闭规则对应的限流器及其独占的内存存储
+func (rm *RuleManager) closeLimiter(id string) {
+	if limiter, exists := rm.limiters[id]; exists {
 		limiter.Close()
-		delete(rm.limiters, path)
 	}
-	delete(rm.rules, path)
+	// 组合限流器通过前缀共享内存存储，需要单独关闭
+	if ms, exists := rm.memoryStores[id]; exists {
+		ms.Close()
+	}
 }
 
 // Allow 判断请求是否允许通过
 func (rm *RuleManager) Allow(ctx context.Context, path string, key string) (bool, time.Duration) {
-	rm.mu.RLock()
-	limiter, exists := rm.limiters[path]
-	rm.mu.RUnlock()
+	return rm.AllowN(ctx, path, key, 1)
+}
+
+// AllowN 判断消耗n个单位的请求是否允许通过
+func (rm *RuleManager) AllowN(ctx context.Context, path string, key string, n int64) (bool, time.Duration) {
+	limiter, key, exists := rm.resolve(path, key)
 
 	if !exists {
 		// 如果路径没有配置限流规则，默认允许通过
 		return true, 0
 	}
 
-	return limiter.Allow(ctx, key)
+	return limiter.AllowN(ctx, key, n)
+}
+
+// RefundN 向key归还n个单位的配额，限流器不支持归还时不做任何事
+func (rm *RuleManager) RefundN(ctx context.Context, path string, key string, n int64) {
+	limiter, key, exists := rm.resolve(path, key)
+
+	if r, ok := limiter.(algorithms.Refunder); exists && ok {
+		r.RefundN(ctx, key, n)
+	}
+}
+
+// InFlight 返回并发限流规则下key的在途请求数，规则不存在或不是并发限流时返回false
+func (rm *RuleManager) InFlight(ctx context.Context, path string, key string) (InFlight, bool) {
+	limiter, scoped, exists := rm.resolve(path, key)
+
+	r, ok := limiter.(algorithms.Releaser)
+	if !exists || !ok {
+		return InFlight{}, false
+	}
+
+	count, global := r.InFlight(ctx, scoped)
+	return InFlight{Key: key, Count: count, Global: global}, true
+}
+
+// Status 返回规则下key的当前状态，不消耗配额。规则不存在时返回false
+func (rm *RuleManager) Status(ctx context.Context, path string, key string) (algorithms.Status, bool) {
+	limiter, key, exists := rm.resolve(path, key)
+
+	if !exists {
+		return algorithms.Status{}, false
+	}
+	return limiter.Status(ctx, key), true
 }
 
-// GetRule 获取指定路径的限流规则
+// KeyStatus 返回key在所有规则下的当前状态，不消耗配额
+func (rm *RuleManager) KeyStatus(ctx context.Context, key string) map[string]algorithms.Status {
+	rm.mu.RLock()
+	limiters := make(map[string]algorithms.RateLimiter, len(rm.limiters))
+	for path, limiter := range rm.limiters {
+		// 按具体路径区分key的规则需要通过具体路径查询
+		if rm.rules[path].KeyScope == PathScope {
+			continue
+		}
+		limiters[path] = limiter
+	}
+	rm.mu.RUnlock()
+
+	statuses := make(map[string]algorithms.Status, len(limiters))
+	for path, limiter := range limiters {
+		statuses[path] = limiter.Status(ctx, key)
+	}
+	return statuses
+}
+
+// Usage 返回日历配额规则下key的使用情况，规则不存在或不是单个日历配额时返回false
+func (rm *RuleManager) Usage(ctx context.Context, path string, key string) (algorithms.QuotaStatus, bool) {
+	limiter, key, exists := rm.resolve(path, key)
+
+	q, ok := limiter.(algorithms.Quota)
+	if !exists || !ok {
+		return algorithms.QuotaStatus{}, false
+	}
+	return q.Usage(ctx, key), true
+}
+
+// TopK 返回计数草图规则下请求量最大的key，规则不存在或不是单个计数草图时返回false
+func (rm *RuleManager) TopK(ctx context.Context, path string) ([]algorithms.HeavyHitter, bool) {
+	limiter, _, exists := rm.resolve(path, "")
+
+	r, ok := limiter.(algorithms.HeavyHitterReporter)
+	if !exists || !ok {
+		return nil, false
+	}
+	return r.TopK(ctx), true
+}
+
+// GetRule 获取限流规则，path可以是规则标识，也可以是请求标识或请求路径，此时返回匹配的规则
 func (rm *RuleManager) GetRule(path string) (Rule, bool) {
 	rm.mu.RLock()
 	defer rm.mu.RUnlock()
-	rule, exists := rm.rules[path]
-	return rule, exists
+	id, _, exists := rm.lookup(path)
+	return rm.rules[id], exists
+}
+
+// resolve 返回匹配请求的限流器，以及按规则的key范围转换后的key
+func (rm *RuleManager) resolve(path string, key string) (algorithms.RateLimiter, string, bool) {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+
+	id, requestPath, exists := rm.lookup(path)
+	if !exists {
+		return nil, key, false
+	}
+	return rm.limiters[id], rm.rules[id].scopedKey(requestPath, key), true
+}
+
+// lookup 返回匹配请求的规则标识和请求路径，与规则标识完全相同时直接使用该规则，调用方需要持有锁
+func (rm *RuleManager) lookup(route string) (string, string, bool) {
+	if _, exists := rm.rules[route]; exists {
+		return route, rm.paths[route], true
+	}
+
+	method, host, path := parseRoute(route)
+	var id string
+	_, matched := rm.matcher.match(path, func(pattern string) bool {
+		for _, v := range rm.variants[pattern] {
+			if rm.rules[v].matches(method, host) {
+				id = v
+				return true
+			}
+		}
+		return false
+	})
+	return id, path, matched
+}
+
+// Stats 返回各路径内存存储的统计信息
+func (rm *RuleManager) Stats() map[string]memory.Stats {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+
+	stats := make(map[string]memory.Stats, len(rm.memoryStores))
+	for path, ms := range rm.memoryStores {
+		stats[path] = ms.Stats()
+	}
+	return stats
+}
+
+// createLimiter 根据规则创建对应的限流器实例，使用内存存储时同时返回该存储
+func (rm *RuleManager) createLimiter(id string, rule Rule) (algorithms.RateLimiter, *memory.MemoryStore, error) {
+	configs := rule.Configs()
+	for _, config := range configs {
+		// 日历配额按Period计数，不使用WindowSize
+		if config.Limit <= 0 || (config.WindowSize <= 0 && rule.Algorithm != Quota) {
+			return nil, nil, fmt.Errorf("invalid config: limit and window size must be positive")
+		}
+		// GCRA的发射间隔小于1微秒时无法用存储脚本的微秒时间表示
+		if rule.Algorithm == GCRA && config.WindowSize/time.Duration(config.Limit) < time.Microsecond {
+			return nil, nil, fmt.Errorf("invalid config: gcra requires window size / limit of at least 1µs")
+		}
+		if config.Burst < 0 || config.Fill() < 0 || config.Fill() > 1 {
+			return nil, nil, fmt.Errorf("invalid config: burst must not be negative and initial fill must be between 0 and 1")
+		}
+		if (config.Burst > 0 || config.InitialFill != nil) && rule.Algorithm != TokenBucket && rule.Algorithm != LeakyBucket {
+			return nil, nil, fmt.Errorf("invalid config: burst and initial fill are only supported by token bucket and leaky bucket")
+		}
+		if config.GlobalLimit < 0 {
+			return nil, nil, fmt.Errorf("invalid config: global limit must not be negative")
+		}
+		if rule.Algorithm == Fair && config.GlobalLimit <= 0 {
+			return nil, nil, fmt.Errorf("invalid config: fair requires a positive global limit")
+		}
+		if config.WarmUp < 0 || (config.WarmUp > 0 && rule.Algorithm != TokenBucket) {
+			return nil, nil, fmt.Errorf("invalid config: warm-up must not be negative and is only supported by token bucket")
+		}
+		if config.SketchWidth < 0 || config.SketchDepth < 0 || config.TopK < 0 ||
+			((config.SketchWidth > 0 || config.SketchDepth > 0 || config.TopK > 0) && rule.Algorithm != CountMin) {
+			return nil, nil, fmt.Errorf("invalid config: sketch width, depth and top k must not be negative and are only supported by count-min")
+		}
+		// 规则可以通过管理API添加，限制草图大小避免一次请求分配过多内存
+		if config.SketchWidth > countmin.MaxWidth || config.SketchDepth > countmin.MaxDepth || config.TopK > countmin.MaxTopK {
+			return nil, nil, fmt.Errorf("invalid config: sketch width, depth and top k must not exceed %d, %d and %d",
+				countmin.MaxWidth, countmin.MaxDepth, countmin.MaxTopK)
+		}
+		// 成本超过容量的请求永远不会被放行
+		capacity := config.Capacity()
+		if config.GlobalLimit > 0 {
+			capacity = min(capacity, config.GlobalLimit)
+		}
+		if rule.Cost.Value > capacity || rule.Cost.Max > capacity {
+			return nil, nil, fmt.Errorf("invalid cost: value and max must not exceed the capacity %d", capacity)
+		}
+		if rule.Algorithm == Quota {
+			if _, _, err := config.Period.Bounds(time.Now(), time.UTC); err != nil {
+				return nil, nil, fmt.Errorf("invalid config: %v", err)
+			}
+			if _, err := time.LoadLocation(config.Location); err != nil {
+				return nil, nil, fmt.Errorf("invalid config: unknown location %s", config.Location)
+			}
+		}
+	}
+	// 组合限流器不会释放在途请求的配额
+	if rule.Algorithm == Concurrency && len(configs) > 1 {
+		return nil, nil, fmt.Errorf("concurrency does not support multiple limits")
+	}
+	if err := rule.Priority.validate(); err != nil {
+		return nil, nil, err
+	}
+	if err := rm.validateKey(rule.Key); err != nil {
+		return nil, nil, err
+	}
+	if rule.Priority.Enabled() && rule.MaxDelay > 0 {
+		return nil, nil, fmt.Errorf("shaping does not support priority")
+	}
+	for _, class := range rule.RefundOn {
+		if len(class) != 3 || class[0] < '1' || class[0] > '5' || !strings.EqualFold(class[1:], "xx") {
+			return nil, nil, fmt.Errorf("invalid refund status class: %s", class)
+		}
+	}
+	if !rule.StoreFailure.Valid() {
+		return nil, nil, fmt.Errorf("unsupported store failure policy: %s", rule.StoreFailure)
+	}
+	if rule.KeyScope != "" && rule.KeyScope != PatternScope && rule.KeyScope != PathScope {
+		return nil, nil, fmt.Errorf("unsupported key scope: %s", rule.KeyScope)
+	}
+	if rule.Algorithm == Concurrency && len(rule.RefundOn) > 0 {
+		return nil, nil, fmt.Errorf("concurrency releases slots when requests complete and does not support refund")
+	}
+
+	var s store.Store
+	var ms *memory.MemoryStore
+	switch rule.Store {
+	case "", MemoryStore:
+		// 每个限流器使用独立的内存存储
+		ms = memory.New(memory.Config{MaxKeys: rule.MaxKeys})
+		s = ms
+	default:
+		shared, exists := rm.stores[rule.Store]
+		if !exists {
+			return nil, nil, fmt.Errorf("unsupported store: %s", rule.Store)
+		}
+		// 以规则标识和算法作为前缀，隔离共享存储中不同规则的状态
+		s = store.WithPrefix(shared, fmt.Sprintf("%s:%s:", id, rule.Algorithm))
+	}
+
+	if len(configs) == 1 {
+		limiter, err := newLimiter(rule.Algorithm, configs[0], algorithms.WithStore(s), algorithms.WithFailurePolicy(rule.StoreFailure))
+		if err != nil {
+			s.Close()
+			return nil, nil, err
+		}
+		if _, ok := limiter.(algorithms.Shaper); rule.MaxDelay > 0 && !ok {
+			limiter.Close()
+			return nil, nil, fmt.Errorf("algorithm %s does not support shaping", rule.Algorithm)
+		}
+		return limiter, ms, nil
+	}
+
+	if rule.MaxDelay > 0 {
+		s.Close()
+		return nil, nil, fmt.Errorf("shaping does not support multiple limits")
+	}
+
+	// 多个限流配置组合为一个限流器，以序号作为前缀隔离各自的状态
+	limiters := make([]algorithms.RateLimiter, 0, len(configs))
+	for i, config := range configs {
+		limiter, err := newLimiter(rule.Algorithm, config,
+			algorithms.WithStore(store.WithPrefix(s, fmt.Sprintf("%d:", i))), algorithms.WithFailurePolicy(rule.StoreFailure))
+		if err != nil {
+			s.Close()
+			return nil, nil, err
+		}
+		limiters = append(limiters, limiter)
+	}
+	return composite.NewLimiter(limiters...), ms, nil
 }
 
-// createLimiter 根据规则创建对应的限流器实例
-func (rm *RuleManager) createLimiter(rule Rule) (algorithms.RateLimiter, error) {
-	switch rule.Algorithm {
+// newLimiter 根据算法类型创建限流器
+func newLimiter(algorithm Algorithm, config algorithms.Config, opts ...algorithms.Option) (algorithms.RateLimiter, error) {
+	switch algorithm {
 	case SlidingLog:
-		return slidinglog.NewLimiter(rule.Config), nil
-	case SlidingWindow:
-		return slidingwindow.NewLimiter(rule.Config), nil
+		return slidinglog.NewLimiter(config, opts...), nil
+	case FixedWindow, SlidingWindow:
+		return fixedwindow.NewLimiter(config, opts...), nil
+	case SlidingWindowCounter:
+		return slidingwindow.NewLimiter(config, opts...), nil
 	case LeakyBucket:
-		return leakybucket.NewLimiter(rule.Config), nil
+		return leakybucket.NewLimiter(config, opts...), nil
 	case TokenBucket:
-		return tokenbucket.NewLimiter(rule.Config), nil
+		return tokenbucket.NewLimiter(config, opts...), nil
+	case GCRA:
+		return gcra.NewLimiter(config, opts...), nil
+	case Concurrency:
+		return concurrency.NewLimiter(config, opts...), nil
+	case Quota:
+		return quota.NewLimiter(config, opts...), nil
+	case Fair:
+		return fair.NewLimiter(config, opts...), nil
+	case CountMin:
+		return countmin.NewLimiter(config, opts...), nil
 	default:
-		return nil, fmt.Errorf("unsupported algorithm: %s", rule.Algorithm)
+		return nil, fmt.Errorf("unsupported algorithm: %s", algorithm)
 	}
 }
 
@@ -130,7 +611,24 @@ func (rm *RuleManager) Close() error {
 		}
 	}
 
+	for _, ms := range rm.memoryStores {
+		if err := ms.Close(); err != nil {
+			return err
+		}
+	}
+
+	for _, s := range rm.stores {
+		if err := s.Close(); err != nil {
+			return err
+		}
+	}
+
 	rm.limiters = make(map[string]algorithms.RateLimiter)
 	rm.rules = make(map[string]Rule)
+	rm.paths = make(map[string]string)
+	rm.variants = make(map[string][]string)
+	rm.matcher = newMatcher()
+	rm.stores = make(map[StoreType]store.Store)
+	rm.memoryStores = make(map[string]*memory.MemoryStore)
 	return nil
 }
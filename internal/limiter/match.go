@@ -0,0 +1,99 @@
+package limiter
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/wureny/FluxGo/internal/algorithms"
+)
+
+// Match 请求匹配到的规则，保存匹配时的限流器和限流key
+// 请求处理期间规则被替换或删除时，释放和归还仍然作用于判断时使用的限流器
+type Match struct {
+	// 匹配到的规则
+	Rule Rule
+	// 匹配时规则对应的限流器
+	limiter algorithms.RateLimiter
+	// 限流器中使用的key，按具体路径区分时包含请求路径
+	key string
+}
+
+// Decision 限流判断的结果
+type Decision struct {
+	// 是否允许请求通过
+	Allowed bool
+	// 拒绝时需要等待的时间，整形模式下为放行前需要排队的时间
+	Wait time.Duration
+	// 判断后的配额状态，规则省略配额响应头时为nil
+	Status *algorithms.Status
+	// 并发限流占用的租约ID，请求完成后通过Release释放
+	Lease string
+}
+
+// Match 按请求标识匹配规则并提取限流key，没有匹配的规则时返回false
+// 规则和限流器在同一次查找中取得，之后的判断、释放和归还都使用返回的Match
+func (rm *RuleManager) Match(route string, r *http.Request, clientIP string) (*Match, bool) {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+
+	id, requestPath, exists := rm.lookup(route)
+	if !exists {
+		return nil, false
+	}
+	rule := rm.rules[id]
+	key := rm.extractKey(rule.Key, r, clientIP)
+	return &Match{Rule: rule, limiter: rm.limiters[id], key: rule.scopedKey(requestPath, key)}, true
+}
+
+// Admit 判断消耗n个单位的请求是否允许通过，放行后key至少还要剩余容量的reserve比例
+// 日历配额在判断时同时返回配额状态；其他算法在规则需要配额响应头时额外查询一次状态
+func (m *Match) Admit(ctx context.Context, n int64, reserve float64) Decision {
+	if q, ok := m.limiter.(algorithms.Quota); ok {
+		allowed, usage := q.ConsumeNReserved(ctx, m.key, n, reserve)
+		d := Decision{
+			Allowed: allowed,
+			Status:  &algorithms.Status{Limit: usage.Limit, Remaining: usage.Remaining, Reset: usage.Reset},
+		}
+		if !allowed {
+			d.Wait = usage.Reset.Sub(algorithms.ClockOf(m.limiter).Now())
+		}
+		return d
+	}
+
+	var d Decision
+	if r, ok := m.limiter.(algorithms.Releaser); ok {
+		d.Lease, d.Allowed, d.Wait = r.AcquireN(ctx, m.key, n, reserve)
+	} else if p, ok := m.limiter.(algorithms.Prioritizer); ok && reserve > 0 {
+		d.Allowed, d.Wait = p.AllowNReserved(ctx, m.key, n, reserve)
+	} else if s, ok := m.limiter.(algorithms.Shaper); ok && m.Rule.MaxDelay > 0 {
+		d.Allowed, d.Wait = s.ShapeN(ctx, m.key, n, m.Rule.MaxDelay)
+	} else {
+		d.Allowed, d.Wait = m.limiter.AllowN(ctx, m.key, n)
+	}
+
+	if !m.Rule.OmitHeaders {
+		status := m.limiter.Status(ctx, m.key)
+		d.Status = &status
+	}
+	return d
+}
+
+// Key 返回限流器中使用的key，按具体路径区分时包含请求路径
+func (m *Match) Key() string {
+	return m.key
+}
+
+// Release 请求完成后释放判断时占用的租约，仅对并发限流的规则有效
+func (m *Match) Release(ctx context.Context, d Decision, n int64) {
+	if r, ok := m.limiter.(algorithms.Releaser); ok && d.Lease != "" {
+		r.ReleaseN(ctx, m.key, d.Lease, n)
+	}
+}
+
+// RefundN 向key归还n个单位的配额，限流器不支持归还时不做任何事
+func (m *Match) RefundN(ctx context.Context, n int64) {
+	if r, ok := m.limiter.(algorithms.Refunder); ok {
+		r.RefundN(ctx, m.key, n)
+	}
+}
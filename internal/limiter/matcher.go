@@ -0,0 +1,241 @@
+package limiter
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// 规则路径的语法：
+//   - 静态路径，如 /api/v1/users，只匹配完全相同的路径
+//   - 参数，如 /users/:id，:name 匹配任意一段非空的路径，/users/ 不匹配 /users/:id
+//   - 前缀，如 /api/v1/*，末尾的 * 匹配 /api/v1 本身及其下的所有路径
+//   - 正则，如 ~^/api/v[0-9]+/orders$，以 ~ 开头，其余部分为匹配完整路径的正则表达式
+//
+// 多个规则匹配同一路径时，从左到右逐段比较，静态段优先于参数，参数优先于前缀，
+// 即更具体的规则优先；路径树中没有匹配的规则时才按正则匹配，多个正则按规则路径的字典序依次尝试
+
+// regexPrefix 正则规则路径的前缀
+const regexPrefix = "~"
+
+// node 路径树的节点，对应路径中的一段
+type node struct {
+	// 静态段 -> 子节点
+	static map[string]*node
+	// 参数段的子节点
+	param *node
+	// 以该节点结束的规则路径
+	pattern string
+	// 以该节点为前缀的规则路径
+	wildcard string
+}
+
+// regexRule 正则规则
+type regexRule struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+// matcher 按路径树查找匹配请求路径的规则，查找时间只与路径的段数有关，与规则数量无关
+type matcher struct {
+	// 路径树的根节点
+	root *node
+	// 正则规则，按规则路径的字典序排列
+	regexes []regexRule
+}
+
+// newMatcher 创建一个空的matcher
+func newMatcher() *matcher {
+	return &matcher{root: &node{}}
+}
+
+// validatePattern 校验规则路径的语法
+func validatePattern(pattern string) error {
+	if strings.HasPrefix(pattern, regexPrefix) {
+		if _, err := regexp.Compile(pattern[len(regexPrefix):]); err != nil {
+			return fmt.Errorf("invalid path regex %s: %v", pattern, err)
+		}
+		return nil
+	}
+
+	segments := split(pattern)
+	for i, seg := range segments {
+		if seg == ":" {
+			return fmt.Errorf("invalid path %s: parameter must have a name", pattern)
+		}
+		if seg == "*" && i != len(segments)-1 {
+			return fmt.Errorf("invalid path %s: wildcard must be the last segment", pattern)
+		}
+	}
+	return nil
+}
+
+// conflict 返回与pattern占用同一节点的其他规则路径，例如参数名不同的 /users/:id 和 /users/:uid
+func (m *matcher) conflict(pattern string) (string, bool) {
+	if strings.HasPrefix(pattern, regexPrefix) {
+		return "", false
+	}
+
+	n := m.root
+	for _, seg := range split(pattern) {
+		switch {
+		case seg == "*":
+			return n.wildcard, n.wildcard != "" && n.wildcard != pattern
+		case strings.HasPrefix(seg, ":"):
+			n = n.param
+		default:
+			n = n.static[seg]
+		}
+		if n == nil {
+			return "", false
+		}
+	}
+	return n.pattern, n.pattern != "" && n.pattern != pattern
+}
+
+// add 添加规则路径，需要先通过validatePattern校验
+func (m *matcher) add(pattern string) {
+	if strings.HasPrefix(pattern, regexPrefix) {
+		m.removeRegex(pattern)
+		m.regexes = append(m.regexes, regexRule{
+			pattern: pattern,
+			re:      regexp.MustCompile(pattern[len(regexPrefix):]),
+		})
+		sort.Slice(m.regexes, func(i, j int) bool { return m.regexes[i].pattern < m.regexes[j].pattern })
+		return
+	}
+
+	n := m.root
+	for _, seg := range split(pattern) {
+		switch {
+		case seg == "*":
+			n.wildcard = pattern
+			return
+		case strings.HasPrefix(seg, ":"):
+			if n.param == nil {
+				n.param = &node{}
+			}
+			n = n.param
+		default:
+			if n.static == nil {
+				n.static = make(map[string]*node)
+			}
+			child, exists := n.static[seg]
+			if !exists {
+				child = &node{}
+				n.static[seg] = child
+			}
+			n = child
+		}
+	}
+	n.pattern = pattern
+}
+
+// remove 删除规则路径
+func (m *matcher) remove(pattern string) {
+	if strings.HasPrefix(pattern, regexPrefix) {
+		m.removeRegex(pattern)
+		return
+	}
+	m.root.remove(pattern, split(pattern))
+}
+
+// remove 删除以该节点开始、剩余段为segments的规则路径，并删除不再有规则和子节点的子节点
+func (n *node) remove(pattern string, segments []string) {
+	if len(segments) == 0 {
+		if n.pattern == pattern {
+			n.pattern = ""
+		}
+		return
+	}
+
+	seg := segments[0]
+	switch {
+	case seg == "*":
+		if n.wildcard == pattern {
+			n.wildcard = ""
+		}
+	case strings.HasPrefix(seg, ":"):
+		if n.param == nil {
+			return
+		}
+		n.param.remove(pattern, segments[1:])
+		if n.param.empty() {
+			n.param = nil
+		}
+	default:
+		child, exists := n.static[seg]
+		if !exists {
+			return
+		}
+		child.remove(pattern, segments[1:])
+		if child.empty() {
+			delete(n.static, seg)
+		}
+	}
+}
+
+// empty 返回节点是否不再有规则路径和子节点
+func (n *node) empty() bool {
+	return n.pattern == "" && n.wildcard == "" && n.param == nil && len(n.static) == 0
+}
+
+// removeRegex 删除正则规则
+func (m *matcher) removeRegex(pattern string) {
+	for i, r := range m.regexes {
+		if r.pattern == pattern {
+			m.regexes = append(m.regexes[:i], m.regexes[i+1:]...)
+			return
+		}
+	}
+}
+
+// match 返回匹配请求路径并且accept返回true的最具体的规则路径，没有匹配时返回false
+// accept用于按方法和主机等路径以外的条件筛选，返回false时继续尝试不那么具体的规则路径
+func (m *matcher) match(path string, accept func(pattern string) bool) (string, bool) {
+	if pattern := m.root.match(split(path), accept); pattern != "" {
+		return pattern, true
+	}
+	for _, r := range m.regexes {
+		if r.re.MatchString(path) && accept(r.pattern) {
+			return r.pattern, true
+		}
+	}
+	return "", false
+}
+
+// match 按静态段、参数、前缀的顺序查找，更具体的分支没有匹配时回溯
+func (n *node) match(segments []string, accept func(pattern string) bool) string {
+	if len(segments) == 0 && n.pattern != "" && accept(n.pattern) {
+		return n.pattern
+	}
+
+	if len(segments) > 0 {
+		if child, exists := n.static[segments[0]]; exists {
+			if pattern := child.match(segments[1:], accept); pattern != "" {
+				return pattern
+			}
+		}
+		// 参数不匹配空的段，例如 /users/ 的最后一段
+		if n.param != nil && segments[0] != "" {
+			if pattern := n.param.match(segments[1:], accept); pattern != "" {
+				return pattern
+			}
+		}
+	}
+
+	if n.wildcard != "" && accept(n.wildcard) {
+		return n.wildcard
+	}
+	return ""
+}
+
+// split 将路径按/分成多段，忽略开头的/
+func split(path string) []string {
+	path = strings.TrimPrefix(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
@@ -0,0 +1,99 @@
+package limiter
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// PrioritySource 请求优先级的来源
+type PrioritySource string
+
+const (
+	// 按请求头的值划分优先级，例如客户端声明的X-Priority、认证层写入的套餐等级或API key本身
+	HeaderPriority PrioritySource = "header"
+	// 按路径前缀划分优先级
+	PathPriority PrioritySource = "path"
+)
+
+// PriorityClass 优先级类别
+type PriorityClass struct {
+	// 类别名称，如"interactive"、"bulk"
+	Name string
+	// 匹配的取值，来源为header时为请求头的值，来源为path时为路径前缀
+	Values []string
+	// 放行后需要保留的容量比例，取值0到1。为0时可以使用全部容量，即最高优先级
+	Reserve float64
+}
+
+// Priority 请求优先级配置，低优先级的请求不能使用为高优先级保留的容量，
+// 避免批量任务等低优先级客户端耗尽配额后交互请求被限流
+type Priority struct {
+	// 优先级来源，为空时不区分优先级
+	Source PrioritySource
+	// 请求头名称，来源为header时使用
+	Header string
+	// 优先级类别，按顺序匹配第一个符合的类别
+	Classes []PriorityClass
+	// 未匹配任何类别的请求需要保留的容量比例
+	DefaultReserve float64
+}
+
+// Enabled 判断是否配置了优先级
+func (p Priority) Enabled() bool {
+	return p.Source != "" || p.DefaultReserve > 0
+}
+
+// Reserve 返回请求需要保留的容量比例
+func (p Priority) Reserve(r *http.Request) float64 {
+	if class, ok := p.Classify(r); ok {
+		return class.Reserve
+	}
+	return p.DefaultReserve
+}
+
+// Classify 返回请求匹配的优先级类别，未匹配时返回false
+func (p Priority) Classify(r *http.Request) (PriorityClass, bool) {
+	var value string
+	switch p.Source {
+	case HeaderPriority:
+		value = r.Header.Get(p.Header)
+	case PathPriority:
+		value = r.URL.Path
+	default:
+		return PriorityClass{}, false
+	}
+
+	for _, class := range p.Classes {
+		for _, v := range class.Values {
+			if (p.Source == HeaderPriority && v == value) ||
+				(p.Source == PathPriority && strings.HasPrefix(value, v)) {
+				return class, true
+			}
+		}
+	}
+	return PriorityClass{}, false
+}
+
+// validate 校验优先级配置
+func (p Priority) validate() error {
+	switch p.Source {
+	case "", PathPriority:
+	case HeaderPriority:
+		if p.Header == "" {
+			return fmt.Errorf("invalid priority: header is required")
+		}
+	default:
+		return fmt.Errorf("invalid priority: unsupported source %s", p.Source)
+	}
+
+	if p.DefaultReserve < 0 || p.DefaultReserve > 1 {
+		return fmt.Errorf("invalid priority: default reserve must be between 0 and 1")
+	}
+	for _, class := range p.Classes {
+		if class.Reserve < 0 || class.Reserve > 1 {
+			return fmt.Errorf("invalid priority: reserve of class %s must be between 0 and 1", class.Name)
+		}
+	}
+	return nil
+}
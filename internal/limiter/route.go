@@ -0,0 +1,159 @@
+package limiter
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// 同一规则路径可以按请求方法和主机配置多个规则，规则的标识由RuleID生成，格式为"方法 主机 路径"，
+// 例如"POST * /api/v1/orders"，不限制的部分为*；方法和主机都不限制时标识就是路径本身，与只按路径配置的规则兼容。
+//
+// 请求按Route生成的"方法 主机 路径"查找规则：先按路径找到最具体的规则路径，再在该路径的规则中按
+// 精确主机、通配主机（*.example.com）、不限主机的顺序，同一主机下限制方法的规则优先于不限制方法的规则；
+// 该路径下没有匹配方法和主机的规则时，继续尝试不那么具体的规则路径
+
+// Route 返回请求的查找标识，method和host为空时只匹配不限制方法和主机的规则
+func Route(method string, host string, path string) string {
+	if method == "" && host == "" {
+		return path
+	}
+	return fmt.Sprintf("%s %s %s", orAny(strings.ToUpper(method)), orAny(strings.ToLower(host)), path)
+}
+
+// RuleID 返回按方法和主机限制的规则的标识，都不限制时返回路径本身
+func RuleID(path string, methods []string, host string) string {
+	methods = normalizeMethods(methods)
+	host = strings.ToLower(host)
+	if len(methods) == 0 && host == "" {
+		return path
+	}
+	return fmt.Sprintf("%s %s %s", orAny(strings.Join(methods, ",")), orAny(host), path)
+}
+
+// parseRoute 解析Route或RuleID生成的标识，返回方法、主机和路径，以/或~开头时只有路径
+func parseRoute(route string) (string, string, string) {
+	if strings.HasPrefix(route, "/") || strings.HasPrefix(route, regexPrefix) {
+		return "", "", route
+	}
+	fields := strings.SplitN(route, " ", 3)
+	if len(fields) < 3 {
+		return "", "", route
+	}
+	method, host := fields[0], fields[1]
+	if method == "*" {
+		method = ""
+	}
+	if host == "*" {
+		host = ""
+	}
+	return method, host, fields[2]
+}
+
+// orAny 为空时返回*
+func orAny(s string) string {
+	if s == "" {
+		return "*"
+	}
+	return s
+}
+
+// normalizeMethods 将方法转为大写并排序去重
+func normalizeMethods(methods []string) []string {
+	if len(methods) == 0 {
+		return nil
+	}
+	normalized := make([]string, 0, len(methods))
+	for _, m := range methods {
+		normalized = append(normalized, strings.ToUpper(strings.TrimSpace(m)))
+	}
+	sort.Strings(normalized)
+
+	unique := normalized[:1]
+	for _, m := range normalized[1:] {
+		if m != unique[len(unique)-1] {
+			unique = append(unique, m)
+		}
+	}
+	return unique
+}
+
+// validateMatch 校验规则的方法和主机
+func validateMatch(methods []string, host string) error {
+	for _, m := range methods {
+		if m == "" || strings.ContainsAny(m, " ,*") {
+			return fmt.Errorf("invalid method: %q", m)
+		}
+	}
+	if host == "" {
+		return nil
+	}
+	name := strings.TrimPrefix(host, "*.")
+	if name == "" || strings.ContainsAny(name, " /*") {
+		return fmt.Errorf("invalid host: %q", host)
+	}
+	return nil
+}
+
+// matches 判断规则的方法和主机是否匹配请求
+func (r Rule) matches(method string, host string) bool {
+	if len(r.Methods) > 0 {
+		matched := false
+		for _, m := range r.Methods {
+			if strings.EqualFold(m, method) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return r.Host == "" || hostMatches(r.Host, host)
+}
+
+// specificity 规则的方法和主机的具体程度，同一路径下更具体的规则优先
+func (r Rule) specificity() int {
+	score := 0
+	switch {
+	case strings.HasPrefix(r.Host, "*."):
+		score += 2
+	case r.Host != "":
+		score += 4
+	}
+	if len(r.Methods) > 0 {
+		score++
+	}
+	return score
+}
+
+// overlaps 判断两个规则是否会匹配同一个请求且具体程度相同
+func (r Rule) overlaps(other Rule) bool {
+	if r.Host != other.Host || (len(r.Methods) == 0) != (len(other.Methods) == 0) {
+		return false
+	}
+	if len(r.Methods) == 0 {
+		return true
+	}
+	for _, m := range r.Methods {
+		for _, o := range other.Methods {
+			if m == o {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hostMatches 判断请求的主机是否匹配规则的主机，忽略端口和大小写，*.example.com匹配所有子域名
+func hostMatches(pattern string, host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+		return len(host) > len(suffix) && strings.HasSuffix(host, suffix)
+	}
+	return host == pattern
+}
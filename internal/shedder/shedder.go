@@ -0,0 +1,164 @@
+package shedder
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Reason 拒绝请求的原因
+type Reason string
+
+const (
+	// InFlight 网关的在途请求数达到上限
+	InFlight Reason = "in_flight"
+	// Goroutines goroutine数量达到上限
+	Goroutines Reason = "goroutines"
+	// Latency 调度延迟达到上限，说明CPU已经饱和，新请求只会让所有请求变慢
+	Latency Reason = "latency"
+)
+
+// Config 过载保护配置，各项为0时不按该项判断
+type Config struct {
+	// 网关的在途请求数上限
+	MaxInFlight int64
+	// goroutine数量上限
+	MaxGoroutines int
+	// 调度延迟上限
+	MaxLatency time.Duration
+	// 测量调度延迟的间隔，为0时为100毫秒
+	LatencyInterval time.Duration
+	// 不受过载保护的路径前缀，管理API和健康检查始终不受限制
+	Exempt []string
+}
+
+// Enabled 判断是否配置了过载保护
+func (c Config) Enabled() bool {
+	return c.MaxInFlight > 0 || c.MaxGoroutines > 0 || c.MaxLatency > 0
+}
+
+// Snapshot 过载保护的当前状态和统计
+type Snapshot struct {
+	// 在途请求数
+	InFlight int64
+	// goroutine数量
+	Goroutines int
+	// 最近一次测量的调度延迟
+	Latency time.Duration
+	// 放行的请求数
+	Admitted int64
+	// 按原因统计的拒绝请求数
+	Shed map[Reason]int64
+}
+
+// Shedder 按网关整体的饱和程度拒绝新请求的过载保护
+// 请求进入时调用Acquire，结束后调用Release
+type Shedder struct {
+	// 配置信息
+	config Config
+	// 在途请求数
+	inFlight atomic.Int64
+	// 最近一次测量的调度延迟，单位纳秒
+	latency atomic.Int64
+	// 放行的请求数
+	admitted atomic.Int64
+	// 按原因统计的拒绝请求数
+	shed map[Reason]*atomic.Int64
+	// 停止测量调度延迟
+	stop chan struct{}
+	// 保证只关闭一次
+	closeOnce sync.Once
+}
+
+// NewShedder 创建一个新的过载保护，配置了调度延迟上限时在后台定期测量调度延迟
+func NewShedder(config Config) *Shedder {
+	if config.LatencyInterval <= 0 {
+		config.LatencyInterval = 100 * time.Millisecond
+	}
+
+	s := &Shedder{
+		config: config,
+		shed: map[Reason]*atomic.Int64{
+			InFlight:   new(atomic.Int64),
+			Goroutines: new(atomic.Int64),
+			Latency:    new(atomic.Int64),
+		},
+		stop: make(chan struct{}),
+	}
+	if config.MaxLatency > 0 {
+		go s.measure()
+	}
+	return s
+}
+
+// Acquire 判断是否放行请求，放行时占用一个在途请求，拒绝时返回原因
+func (s *Shedder) Acquire() (Reason, bool) {
+	reason, overloaded := s.check()
+	if overloaded {
+		s.shed[reason].Add(1)
+		return reason, false
+	}
+	s.admitted.Add(1)
+	return "", true
+}
+
+// Release 请求结束，释放在途请求
+func (s *Shedder) Release() {
+	s.inFlight.Add(-1)
+}
+
+// Snapshot 返回当前状态和统计
+func (s *Shedder) Snapshot() Snapshot {
+	shed := make(map[Reason]int64, len(s.shed))
+	for reason, count := range s.shed {
+		shed[reason] = count.Load()
+	}
+	return Snapshot{
+		InFlight:   s.inFlight.Load(),
+		Goroutines: runtime.NumGoroutine(),
+		Latency:    time.Duration(s.latency.Load()),
+		Admitted:   s.admitted.Load(),
+		Shed:       shed,
+	}
+}
+
+// Exempt 返回不受过载保护的路径前缀
+func (s *Shedder) Exempt() []string {
+	return s.config.Exempt
+}
+
+// Close 停止测量调度延迟
+func (s *Shedder) Close() {
+	s.closeOnce.Do(func() { close(s.stop) })
+}
+
+// check 判断网关是否过载，未过载时占用一个在途请求
+func (s *Shedder) check() (Reason, bool) {
+	if s.config.MaxLatency > 0 && time.Duration(s.latency.Load()) >= s.config.MaxLatency {
+		return Latency, true
+	}
+	if s.config.MaxGoroutines > 0 && runtime.NumGoroutine() >= s.config.MaxGoroutines {
+		return Goroutines, true
+	}
+	// 先占用再判断，避免并发请求同时通过检查后超过上限
+	if inFlight := s.inFlight.Add(1); s.config.MaxInFlight > 0 && inFlight > s.config.MaxInFlight {
+		s.inFlight.Add(-1)
+		return InFlight, true
+	}
+	return "", false
+}
+
+// measure 定期测量调度延迟，即定时器到期后goroutine实际被调度执行的滞后时间
+func (s *Shedder) measure() {
+	for {
+		start := time.Now()
+		select {
+		case <-time.After(s.config.LatencyInterval):
+			lag := time.Since(start) - s.config.LatencyInterval
+			s.latency.Store(int64(max(0, lag)))
+		case <-s.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package memory
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/wureny/FluxGo/internal/store"
+)
+
+const (
+	// 默认的失效状态清理间隔
+	defaultCleanupInterval = time.Minute
+	// 默认的分片数量
+	defaultShards = 32
+	// 限制key数量时每个分片至少容纳的key数量，容量较小时减少分片以保证LRU的准确性
+	minKeysPerShard = 1024
+)
+
+// Config 内存存储配置
+type Config struct {
+	// 最多保存的key数量，超过时淘汰最久未访问的key。为0时不限制
+	MaxKeys int
+	// 清理失效状态的间隔，为0时使用默认值
+	CleanupInterval time.Duration
+	// 分片数量，为0时使用默认值。不同分片的key互不阻塞
+	Shards int
+	// 获取当前时间，用于判断状态是否失效，为空时使用系统时间
+	Now func() time.Time
+}
+
+// Stats 内存存储的统计信息
+type Stats struct {
+	// 当前保存的key数量
+	Keys int
+	// 状态失效后被清理的key数量
+	Expired int64
+	// 超过MaxKeys被淘汰的key数量
+	Evicted int64
+}
+
+// 单个key的状态
+type entry struct {
+	key      string
+	state    interface{}
+	expireAt time.Time // 状态失效时间，零值表示不失效
+}
+
+// 分片，每个分片有独立的锁、LRU链表和统计信息
+type shard struct {
+	mu sync.Mutex
+	// key -> LRU链表节点的映射
+	entries map[string]*list.Element
+	// 按访问时间排序的链表，表头为最近访问
+	lru *list.List
+	// 分片最多保存的key数量，为0时不限制
+	maxKeys int
+	// 统计信息
+	expired int64
+	evicted int64
+}
+
+// MemoryStore 进程内的状态存储
+// 状态只在当前进程可见，多副本部署时每个副本各自计数。
+// key按哈希分布到多个分片，不同分片的请求不会争用同一把锁；
+// 后台定期清理已经回到初始状态的key，并可以按LRU限制key的数量，避免内存无限增长
+type MemoryStore struct {
+	// 配置信息
+	config Config
+	// 分片
+	shards []*shard
+	// 停止后台清理
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+// New 创建一个新的内存存储
+func New(config Config) *MemoryStore {
+	if config.CleanupInterval <= 0 {
+		config.CleanupInterval = defaultCleanupInterval
+	}
+	if config.Shards <= 0 {
+		config.Shards = defaultShards
+	}
+	if config.Now == nil {
+		config.Now = time.Now
+	}
+	// LRU在分片内近似，key上限较小时减少分片数量
+	if config.MaxKeys > 0 && config.MaxKeys/config.Shards < minKeysPerShard {
+		config.Shards = max(1, config.MaxKeys/minKeysPerShard)
+	}
+
+	s := &MemoryStore{
+		config: config,
+		shards: make([]*shard, config.Shards),
+		stop:   make(chan struct{}),
+	}
+	for i := range s.shards {
+		s.shards[i] = &shard{
+			entries: make(map[string]*list.Element),
+			lru:     list.New(),
+			maxKeys: (config.MaxKeys + config.Shards - 1) / config.Shards,
+		}
+	}
+	go s.cleanupLoop()
+	return s
+}
+
+// Exec 实现Store接口
+func (s *MemoryStore) Exec(ctx context.Context, key string, op store.Op) ([]int64, error) {
+	sh := s.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	var state interface{}
+	elem, exists := sh.entries[key]
+	if exists {
+		state = elem.Value.(*entry).state
+	}
+
+	next, expireAt, result := op.Apply(state)
+	switch {
+	case next == nil:
+		if exists {
+			sh.remove(elem)
+		}
+	case exists:
+		e := elem.Value.(*entry)
+		e.state = next
+		e.expireAt = expireAt
+		sh.lru.MoveToFront(elem)
+	default:
+		sh.entries[key] = sh.lru.PushFront(&entry{key: key, state: next, expireAt: expireAt})
+		// 超过容量时淘汰最久未访问的key
+		if sh.maxKeys > 0 && sh.lru.Len() > sh.maxKeys {
+			sh.remove(sh.lru.Back())
+			sh.evicted++
+		}
+	}
+	return result, nil
+}
+
+// Stats 返回统计信息
+func (s *MemoryStore) Stats() Stats {
+	var stats Stats
+	for _, sh := range s.shards {
+		sh.mu.Lock()
+		stats.Keys += sh.lru.Len()
+		stats.Expired += sh.expired
+		stats.Evicted += sh.evicted
+		sh.mu.Unlock()
+	}
+	return stats
+}
+
+// Cleanup 清理所有已经失效的状态，每次只锁定一个分片
+func (s *MemoryStore) Cleanup(now time.Time) {
+	for _, sh := range s.shards {
+		sh.mu.Lock()
+		for _, elem := range sh.entries {
+			e := elem.Value.(*entry)
+			if !e.expireAt.IsZero() && !e.expireAt.After(now) {
+				sh.remove(elem)
+				sh.expired++
+			}
+		}
+		sh.mu.Unlock()
+	}
+}
+
+// cleanupLoop 定期清理失效状态，直到存储关闭
+func (s *MemoryStore) cleanupLoop() {
+	ticker := time.NewTicker(s.config.CleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.Cleanup(s.config.Now())
+		}
+	}
+}
+
+// shard 返回key所在的分片，使用FNV-1a哈希
+func (s *MemoryStore) shard(key string) *shard {
+	if len(s.shards) == 1 {
+		return s.shards[0]
+	}
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return s.shards[h%uint32(len(s.shards))]
+}
+
+// remove 删除链表节点对应的key，调用方需持有分片的锁
+func (sh *shard) remove(elem *list.Element) {
+	sh.lru.Remove(elem)
+	delete(sh.entries, elem.Value.(*entry).key)
+}
+
+// Close 实现Store接口
+func (s *MemoryStore) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+	})
+
+	for _, sh := range s.shards {
+		sh.mu.Lock()
+		sh.entries = make(map[string]*list.Element)
+		sh.lru.Init()
+		sh.mu.Unlock()
+	}
+	return nil
+}
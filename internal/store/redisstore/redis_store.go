@@ -0,0 +1,89 @@
+package redisstore
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/wureny/FluxGo/internal/store"
+)
+
+// Config Redis存储配置
+type Config struct {
+	// Redis地址
+	Addr string
+	// 密码
+	Password string
+	// 数据库编号
+	DB int
+	// key前缀，默认为 "fluxgo:"
+	Prefix string
+}
+
+// RedisStore 基于Redis的状态存储
+// 每次状态转换都以Lua脚本在Redis中原子执行，多个网关副本共享同一份状态
+type RedisStore struct {
+	client redis.UniversalClient
+	// key前缀
+	prefix string
+	// 是否由本存储创建并负责关闭client
+	ownClient bool
+}
+
+// New 根据配置创建Redis存储
+func New(config Config) *RedisStore {
+	s := NewFromClient(redis.NewClient(&redis.Options{
+		Addr:     config.Addr,
+		Password: config.Password,
+		DB:       config.DB,
+	}), config.Prefix)
+	s.ownClient = true
+	return s
+}
+
+// NewFromClient 使用已有的Redis客户端创建存储，client由调用方负责关闭
+func NewFromClient(client redis.UniversalClient, prefix string) *RedisStore {
+	if prefix == "" {
+		prefix = "fluxgo:"
+	}
+	return &RedisStore{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+// Exec 实现Store接口
+func (s *RedisStore) Exec(ctx context.Context, key string, op store.Op) ([]int64, error) {
+	keys := []string{s.prefix + key}
+
+	// 优先使用EVALSHA，脚本未加载时回退到EVAL
+	res, err := s.client.EvalSha(ctx, op.Script.Hash, keys, op.Args...).Result()
+	if err != nil && redis.HasErrorPrefix(err, "NOSCRIPT") {
+		res, err = s.client.Eval(ctx, op.Script.Src, keys, op.Args...).Result()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	values, ok := res.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected script result: %v", res)
+	}
+	result := make([]int64, len(values))
+	for i, v := range values {
+		n, ok := v.(int64)
+		if !ok {
+			return nil, fmt.Errorf("unexpected script result: %v", res)
+		}
+		result[i] = n
+	}
+	return result, nil
+}
+
+// Close 实现Store接口
+func (s *RedisStore) Close() error {
+	if s.ownClient {
+		return s.client.Close()
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package store
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"time"
+)
+
+// Store 定义了限流状态存储的接口
+// 算法对每个key状态的读-改-写都通过一次 Exec 原子完成，
+// 这样多个网关副本共享同一个存储时也能共享同一份限流计数
+type Store interface {
+	// Exec 对key原子地执行一次状态转换，返回转换结果
+	Exec(ctx context.Context, key string, op Op) ([]int64, error)
+
+	// Close 清理资源
+	Close() error
+}
+
+// Op 描述一次针对单个key的原子状态转换
+// 同一个转换需要同时给出Lua和Go两种等价实现：
+// Redis等远程存储执行 Script，进程内存储执行 Apply
+type Op struct {
+	// Redis端执行的Lua脚本，KEYS[1]为状态key
+	Script *Script
+	// 传给Lua脚本的参数(ARGV)
+	Args []interface{}
+	// 进程内的等价实现，state为key当前状态（不存在时为nil）
+	// 返回新状态（nil表示删除该key）、新状态的失效时间和转换结果；
+	// 失效时间之后状态等价于不存在，可以被回收，与Lua脚本中的PEXPIRE对应
+	Apply func(state interface{}) (interface{}, time.Time, []int64)
+}
+
+// Script Lua脚本
+type Script struct {
+	// 脚本源码
+	Src string
+	// 脚本的SHA1，用于EVALSHA
+	Hash string
+}
+
+// NewScript 创建Lua脚本
+func NewScript(src string) *Script {
+	h := sha1.Sum([]byte(src))
+	return &Script{
+		Src:  src,
+		Hash: hex.EncodeToString(h[:]),
+	}
+}
+
+// Micros 将时长转换为脚本中使用的微秒数（向上取整）
+// 存储脚本统一以微秒表示时间，避免纳秒时间戳超出Lua数值精度
+func Micros(d time.Duration) int64 {
+	return int64((d + time.Microsecond - 1) / time.Microsecond)
+}
+
+// Duration 将脚本返回的微秒数转换为时长
+func Duration(us int64) time.Duration {
+	return time.Duration(us) * time.Microsecond
+}
+
+// prefixStore 为所有key添加统一前缀的存储包装
+type prefixStore struct {
+	Store
+	prefix string
+}
+
+// WithPrefix 返回为所有key添加前缀的存储
+// 用于多条规则共享同一个存储时隔离各自的状态，关闭时不会关闭底层存储
+func WithPrefix(s Store, prefix string) Store {
+	return &prefixStore{Store: s, prefix: prefix}
+}
+
+// Exec 实现Store接口
+func (s *prefixStore) Exec(ctx context.Context, key string, op Op) ([]int64, error) {
+	return s.Store.Exec(ctx, s.prefix+key, op)
+}
+
+// Close 实现Store接口，底层存储由其创建者负责关闭
+func (s *prefixStore) Close() error {
+	return nil
+}
@@ -10,8 +10,12 @@ import (
 	"strings"
 	"time"
 
+	"github.com/wureny/FluxGo/internal/adaptive"
 	"github.com/wureny/FluxGo/internal/algorithms"
+	"github.com/wureny/FluxGo/internal/breaker"
 	"github.com/wureny/FluxGo/internal/limiter"
+	"github.com/wureny/FluxGo/internal/shedder"
+	"github.com/wureny/FluxGo/internal/store/memory"
 )
 
 // Client FluxGo客户端
@@ -32,7 +36,7 @@ type Config struct {
 
 // RuleConfig 限流规则配置
 type RuleConfig struct {
-	// 路径
+	// 路径，支持 /users/:id 参数、/api/v1/* 前缀和以 ~ 开头的正则
 	Path string
 	// 限流算法
 	Algorithm limiter.Algorithm
@@ -40,6 +44,49 @@ type RuleConfig struct {
 	WindowSize time.Duration
 	// 限制次数
 	Limit int64
+	// 突发容量，为0时等于Limit。仅令牌桶和漏桶支持
+	Burst int64
+	// 新建key时可用配额占突发容量的比例，为空时满额可用。仅令牌桶和漏桶支持
+	InitialFill *float64
+	// 多个限流配置，请求需要同时满足，设置后忽略WindowSize和Limit
+	Limits []algorithms.Config
+	// 状态存储类型，为空时使用内存存储
+	Store limiter.StoreType
+	// 状态存储不可用时的处理策略，为空时放行请求
+	StoreFailure algorithms.FailurePolicy
+	// 请求成本，为空时每个请求消耗1个单位
+	Cost limiter.Cost
+	// 内存存储最多保存的key数量，为0时不限制
+	MaxKeys int
+	// 整形模式下请求最多排队等待的时间，为0时不排队。仅漏桶算法支持
+	MaxDelay time.Duration
+	// 所有key合计的上限，为0时不限制。仅并发限流和公平分配支持
+	GlobalLimit int64
+	// 预热时长，新key的令牌生成速率在该时长内从1/3线性增长到配置速率，为0时不预热。仅令牌桶支持
+	WarmUp time.Duration
+	// 日历周期，设置后Limit为每个周期的配额。仅日历配额支持
+	Period algorithms.Period
+	// 日历周期所在的时区，为空时使用UTC。仅日历配额支持
+	Location string
+	// 需要归还配额的响应状态码类别，如"5xx"。并发限流不支持
+	RefundOn []string
+	// 请求优先级，低优先级的请求不能使用为高优先级保留的容量
+	Priority limiter.Priority
+	// 计数草图每行的计数器数量和行数，为0时分别为2048和4。仅计数草图支持
+	SketchWidth int
+	SketchDepth int
+	// 统计请求量最大的key数量，为0时为10。仅计数草图支持
+	TopK int
+	// 路径匹配多个具体路径时key的范围，为空时所有路径共用配额
+	KeyScope limiter.KeyScope
+	// 适用的请求方法，为空时适用于所有方法
+	Methods []string
+	// 适用的主机，支持*.example.com，为空时适用于所有主机
+	Host string
+	// 限流key的来源，为空时使用客户端IP
+	Key limiter.Key
+	// 是否省略X-RateLimit配额响应头，省略后每个请求少查询一次存储
+	OmitHeaders bool
 }
 
 // New 创建新的客户端
@@ -61,9 +108,31 @@ func (c *Client) SetRule(config RuleConfig) error {
 	rule := limiter.Rule{
 		Algorithm: config.Algorithm,
 		Config: algorithms.Config{
-			WindowSize: config.WindowSize,
-			Limit:      config.Limit,
+			WindowSize:  config.WindowSize,
+			Limit:       config.Limit,
+			Burst:       config.Burst,
+			InitialFill: config.InitialFill,
+			GlobalLimit: config.GlobalLimit,
+			WarmUp:      config.WarmUp,
+			Period:      config.Period,
+			Location:    config.Location,
+			SketchWidth: config.SketchWidth,
+			SketchDepth: config.SketchDepth,
+			TopK:        config.TopK,
 		},
+		Limits:       config.Limits,
+		Store:        config.Store,
+		StoreFailure: config.StoreFailure,
+		Cost:         config.Cost,
+		MaxKeys:      config.MaxKeys,
+		MaxDelay:     config.MaxDelay,
+		RefundOn:     config.RefundOn,
+		Priority:     config.Priority,
+		KeyScope:     config.KeyScope,
+		Methods:      config.Methods,
+		Host:         config.Host,
+		Key:          config.Key,
+		OmitHeaders:  config.OmitHeaders,
 	}
 
 	body, err := json.Marshal(rule)
@@ -104,7 +173,12 @@ func (c *Client) SetRule(config RuleConfig) error {
 
 // GetRule 获取限流规则
 func (c *Client) GetRule(path string) (*RuleConfig, error) {
-	url := fmt.Sprintf("%s/admin/rules/%s", c.gatewayAddr, path)
+	return c.GetRuleFor(path, nil, "")
+}
+
+// GetRuleFor 获取限制了请求方法和主机的限流规则，methods和host与添加规则时相同
+func (c *Client) GetRuleFor(path string, methods []string, host string) (*RuleConfig, error) {
+	url := fmt.Sprintf("%s/admin/rules/%s%s", c.gatewayAddr, strings.TrimPrefix(path, "/"), ruleQuery(methods, host))
 	resp, err := c.httpClient.Get(url)
 	if err != nil {
 		return nil, fmt.Errorf("send request failed: %v", err)
@@ -126,16 +200,43 @@ func (c *Client) GetRule(path string) (*RuleConfig, error) {
 	}
 
 	return &RuleConfig{
-		Path:       path,
-		Algorithm:  rule.Algorithm,
-		WindowSize: rule.Config.WindowSize,
-		Limit:      rule.Config.Limit,
+		Path:         path,
+		Algorithm:    rule.Algorithm,
+		WindowSize:   rule.Config.WindowSize,
+		Limit:        rule.Config.Limit,
+		Burst:        rule.Config.Burst,
+		InitialFill:  rule.Config.InitialFill,
+		GlobalLimit:  rule.Config.GlobalLimit,
+		WarmUp:       rule.Config.WarmUp,
+		Period:       rule.Config.Period,
+		Location:     rule.Config.Location,
+		Limits:       rule.Limits,
+		Store:        rule.Store,
+		StoreFailure: rule.StoreFailure,
+		Cost:         rule.Cost,
+		MaxKeys:      rule.MaxKeys,
+		MaxDelay:     rule.MaxDelay,
+		RefundOn:     rule.RefundOn,
+		Priority:     rule.Priority,
+		SketchWidth:  rule.Config.SketchWidth,
+		SketchDepth:  rule.Config.SketchDepth,
+		TopK:         rule.Config.TopK,
+		KeyScope:     rule.KeyScope,
+		Methods:      rule.Methods,
+		Host:         rule.Host,
+		Key:          rule.Key,
+		OmitHeaders:  rule.OmitHeaders,
 	}, nil
 }
 
 // RemoveRule 删除限流规则
 func (c *Client) RemoveRule(path string) error {
-	url := fmt.Sprintf("%s/admin/rules/%s", c.gatewayAddr, path)
+	return c.RemoveRuleFor(path, nil, "")
+}
+
+// RemoveRuleFor 删除限制了请求方法和主机的限流规则，methods和host与添加规则时相同
+func (c *Client) RemoveRuleFor(path string, methods []string, host string) error {
+	url := fmt.Sprintf("%s/admin/rules/%s%s", c.gatewayAddr, strings.TrimPrefix(path, "/"), ruleQuery(methods, host))
 	req, err := http.NewRequest(http.MethodDelete, url, nil)
 	if err != nil {
 		return fmt.Errorf("create request failed: %v", err)
@@ -155,6 +256,228 @@ func (c *Client) RemoveRule(path string) error {
 	return nil
 }
 
+// ruleQuery 返回指定规则方法和主机的查询参数，都为空时返回空字符串
+func ruleQuery(methods []string, host string) string {
+	query := url.Values{}
+	if len(methods) > 0 {
+		query.Set("methods", strings.Join(methods, ","))
+	}
+	if host != "" {
+		query.Set("host", host)
+	}
+	if len(query) == 0 {
+		return ""
+	}
+	return "?" + query.Encode()
+}
+
+// GetStats 获取各规则内存存储的统计信息
+func (c *Client) GetStats() (map[string]memory.Stats, error) {
+	resp, err := c.httpClient.Get(c.gatewayAddr + "/admin/stats")
+	if err != nil {
+		return nil, fmt.Errorf("send request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("get stats failed: status=%d, body=%s", resp.StatusCode, string(body))
+	}
+
+	var stats map[string]memory.Stats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return nil, fmt.Errorf("decode response failed: %v", err)
+	}
+	return stats, nil
+}
+
+// GetInFlight 获取并发限流规则下key的在途请求数
+func (c *Client) GetInFlight(path string, key string) (*limiter.InFlight, error) {
+	url := fmt.Sprintf("%s/admin/inflight/%s?key=%s", c.gatewayAddr, strings.TrimPrefix(path, "/"), url.QueryEscape(key))
+	resp, err := c.httpClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("send request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("get in-flight failed: status=%d, body=%s", resp.StatusCode, string(body))
+	}
+
+	var inFlight limiter.InFlight
+	if err := json.NewDecoder(resp.Body).Decode(&inFlight); err != nil {
+		return nil, fmt.Errorf("decode response failed: %v", err)
+	}
+	return &inFlight, nil
+}
+
+// GetQuota 获取日历配额规则下key的使用情况
+func (c *Client) GetQuota(path string, key string) (*algorithms.QuotaStatus, error) {
+	url := fmt.Sprintf("%s/admin/quota/%s?key=%s", c.gatewayAddr, strings.TrimPrefix(path, "/"), url.QueryEscape(key))
+	resp, err := c.httpClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("send request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("get quota failed: status=%d, body=%s", resp.StatusCode, string(body))
+	}
+
+	var status algorithms.QuotaStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, fmt.Errorf("decode response failed: %v", err)
+	}
+	return &status, nil
+}
+
+// GetHeavyHitters 获取计数草图规则下请求量最大的key，规则不存在或不是计数草图时返回nil
+func (c *Client) GetHeavyHitters(path string) ([]algorithms.HeavyHitter, error) {
+	url := fmt.Sprintf("%s/admin/heavy-hitters/%s", c.gatewayAddr, strings.TrimPrefix(path, "/"))
+	resp, err := c.httpClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("send request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("get heavy hitters failed: status=%d, body=%s", resp.StatusCode, string(body))
+	}
+
+	var hitters []algorithms.HeavyHitter
+	if err := json.NewDecoder(resp.Body).Decode(&hitters); err != nil {
+		return nil, fmt.Errorf("decode response failed: %v", err)
+	}
+	return hitters, nil
+}
+
+// GetKeyStatus 获取key在所有规则下的当前状态，不消耗配额
+func (c *Client) GetKeyStatus(key string) (map[string]algorithms.Status, error) {
+	resp, err := c.httpClient.Get(c.gatewayAddr + "/admin/keys/" + url.PathEscape(key))
+	if err != nil {
+		return nil, fmt.Errorf("send request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("get key status failed: status=%d, body=%s", resp.StatusCode, string(body))
+	}
+
+	var statuses map[string]algorithms.Status
+	if err := json.NewDecoder(resp.Body).Decode(&statuses); err != nil {
+		return nil, fmt.Errorf("decode response failed: %v", err)
+	}
+	return statuses, nil
+}
+
+// Refund 向规则下的key归还n个单位的配额
+func (c *Client) Refund(path string, key string, n int64) error {
+	url := fmt.Sprintf("%s/admin/refund/%s?key=%s&n=%d", c.gatewayAddr, strings.TrimPrefix(path, "/"), url.QueryEscape(key), n)
+	resp, err := c.httpClient.Post(url, "application/json", nil)
+	if err != nil {
+		return fmt.Errorf("send request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("refund failed: status=%d, body=%s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+// GetAdaptiveLimits 获取各目标前缀当前的自适应并发限制
+func (c *Client) GetAdaptiveLimits() (map[string]adaptive.Snapshot, error) {
+	resp, err := c.httpClient.Get(c.gatewayAddr + "/admin/adaptive")
+	if err != nil {
+		return nil, fmt.Errorf("send request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("get adaptive limits failed: status=%d, body=%s", resp.StatusCode, string(body))
+	}
+
+	var snapshots map[string]adaptive.Snapshot
+	if err := json.NewDecoder(resp.Body).Decode(&snapshots); err != nil {
+		return nil, fmt.Errorf("decode response failed: %v", err)
+	}
+	return snapshots, nil
+}
+
+// GetBreakers 获取各目标前缀的熔断器状态
+func (c *Client) GetBreakers() (map[string]breaker.Snapshot, error) {
+	resp, err := c.httpClient.Get(c.gatewayAddr + "/admin/breakers")
+	if err != nil {
+		return nil, fmt.Errorf("send request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("get breakers failed: status=%d, body=%s", resp.StatusCode, string(body))
+	}
+
+	var snapshots map[string]breaker.Snapshot
+	if err := json.NewDecoder(resp.Body).Decode(&snapshots); err != nil {
+		return nil, fmt.Errorf("decode response failed: %v", err)
+	}
+	return snapshots, nil
+}
+
+// ResetBreaker 手动将目标前缀的熔断器恢复为正常状态
+func (c *Client) ResetBreaker(prefix string) error {
+	url := fmt.Sprintf("%s/admin/breakers/reset/%s", c.gatewayAddr, strings.TrimPrefix(prefix, "/"))
+	resp, err := c.httpClient.Post(url, "application/json", nil)
+	if err != nil {
+		return fmt.Errorf("send request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("reset breaker failed: status=%d, body=%s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+// GetShedding 获取过载保护的当前状态和拒绝请求的统计
+func (c *Client) GetShedding() (*shedder.Snapshot, error) {
+	resp, err := c.httpClient.Get(c.gatewayAddr + "/admin/shedding")
+	if err != nil {
+		return nil, fmt.Errorf("send request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("get shedding failed: status=%d, body=%s", resp.StatusCode, string(body))
+	}
+
+	var snapshot shedder.Snapshot
+	if err := json.NewDecoder(resp.Body).Decode(&snapshot); err != nil {
+		return nil, fmt.Errorf("decode response failed: %v", err)
+	}
+	return &snapshot, nil
+}
+
 // Do 发送HTTP请求
 func (c *Client) Do(req *http.Request) (*http.Response, error) {
 	// 确保请求发送到网关
@@ -2,7 +2,11 @@ package blackbox
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -13,8 +17,12 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
+	"github.com/wureny/FluxGo/internal/adaptive"
+	"github.com/wureny/FluxGo/internal/algorithms"
+	"github.com/wureny/FluxGo/internal/breaker"
 	"github.com/wureny/FluxGo/internal/gateway"
 	"github.com/wureny/FluxGo/internal/limiter"
+	"github.com/wureny/FluxGo/internal/shedder"
 	"github.com/wureny/FluxGo/pkg/client"
 )
 
@@ -166,3 +174,1294 @@ func TestConcurrentRateLimit(t *testing.T) {
 	assert.True(t, limitCount > 0, "应该有请求被限流")
 	assert.True(t, successCount > 0, "应该有请求成功")
 }
+
+// 测试按请求头计算的请求成本
+func TestWeightedRateLimit(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	}))
+	defer testServer.Close()
+
+	gw, err := gateway.New(gateway.Config{
+		ListenAddr: ":0",
+		Targets: map[string]string{
+			"/api": testServer.URL,
+		},
+	})
+	assert.NoError(t, err)
+
+	gwServer := httptest.NewServer(gw.GetHandler())
+	defer gwServer.Close()
+
+	c := client.New(client.Config{
+		GatewayAddr: gwServer.URL,
+		Timeout:     5 * time.Second,
+	})
+
+	// 批量接口按请求头声明的条数计费
+	err = c.SetRule(client.RuleConfig{
+		Path:       "/api/batch",
+		Algorithm:  limiter.TokenBucket,
+		WindowSize: time.Minute,
+		Limit:      10,
+		Cost: limiter.Cost{
+			Source: limiter.HeaderCost,
+			Header: "X-Batch-Size",
+		},
+	})
+	assert.NoError(t, err)
+
+	send := func(batchSize string) int {
+		req, err := http.NewRequest(http.MethodGet, gwServer.URL+"/api/batch", nil)
+		assert.NoError(t, err)
+		req.Header.Set("X-Batch-Size", batchSize)
+		resp, err := c.Do(req)
+		if !assert.NoError(t, err) {
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusOK, send("6"), "成本在配额内的请求应该成功")
+	assert.Equal(t, http.StatusTooManyRequests, send("6"), "成本超过剩余配额的请求应该被限流")
+	assert.Equal(t, http.StatusOK, send("4"), "剩余配额内的请求应该成功")
+	assert.Equal(t, http.StatusTooManyRequests, send("1"), "配额已用完")
+}
+
+// 测试规则同时满足多个限流
+func TestCompositeRateLimit(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	}))
+	defer testServer.Close()
+
+	gw, err := gateway.New(gateway.Config{
+		ListenAddr: ":0",
+		Targets: map[string]string{
+			"/api": testServer.URL,
+		},
+	})
+	assert.NoError(t, err)
+
+	gwServer := httptest.NewServer(gw.GetHandler())
+	defer gwServer.Close()
+
+	c := client.New(client.Config{
+		GatewayAddr: gwServer.URL,
+		Timeout:     5 * time.Second,
+	})
+
+	// 每秒5个并且每分钟3个
+	err = c.SetRule(client.RuleConfig{
+		Path:      "/api/composite",
+		Algorithm: limiter.SlidingWindowCounter,
+		Limits: []algorithms.Config{
+			{WindowSize: time.Second, Limit: 5},
+			{WindowSize: time.Minute, Limit: 3},
+		},
+	})
+	assert.NoError(t, err)
+
+	rule, err := c.GetRule("/api/composite")
+	assert.NoError(t, err)
+	if assert.NotNil(t, rule) {
+		assert.Len(t, rule.Limits, 2, "规则应该包含两个限流配置")
+	}
+
+	for i := 0; i < 3; i++ {
+		resp, err := c.Get("/api/composite")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "请求应该成功")
+		resp.Body.Close()
+	}
+
+	resp, err := c.Get("/api/composite")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode, "超过每分钟限制的请求应该被限流")
+	resp.Body.Close()
+}
+
+// 测试漏桶整形模式让突发请求排队而不是被拒绝
+func TestShapingRateLimit(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	}))
+	defer testServer.Close()
+
+	gw, err := gateway.New(gateway.Config{
+		ListenAddr: ":0",
+		Targets: map[string]string{
+			"/api": testServer.URL,
+		},
+	})
+	assert.NoError(t, err)
+
+	gwServer := httptest.NewServer(gw.GetHandler())
+	defer gwServer.Close()
+
+	c := client.New(client.Config{
+		GatewayAddr: gwServer.URL,
+		Timeout:     5 * time.Second,
+	})
+
+	// 每100ms放行一个请求，最多排队250ms
+	err = c.SetRule(client.RuleConfig{
+		Path:       "/api/shaping",
+		Algorithm:  limiter.LeakyBucket,
+		WindowSize: time.Second,
+		Limit:      10,
+		MaxDelay:   250 * time.Millisecond,
+	})
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	var successCount, limitCount int32
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := c.Get("/api/shaping")
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer resp.Body.Close()
+			switch resp.StatusCode {
+			case http.StatusOK:
+				atomic.AddInt32(&successCount, 1)
+			case http.StatusTooManyRequests:
+				atomic.AddInt32(&limitCount, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(3), successCount, "排队时间内的请求应该成功")
+	assert.Equal(t, int32(2), limitCount, "排队时间超过上限的请求应该被限流")
+	assert.True(t, time.Since(start) >= 200*time.Millisecond, "请求应该按漏水速率排队放行")
+}
+
+// 测试令牌桶的突发容量与平均速率分开配置
+func TestBurstRateLimit(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	}))
+	defer testServer.Close()
+
+	gw, err := gateway.New(gateway.Config{
+		ListenAddr: ":0",
+		Targets: map[string]string{
+			"/api": testServer.URL,
+		},
+	})
+	assert.NoError(t, err)
+
+	gwServer := httptest.NewServer(gw.GetHandler())
+	defer gwServer.Close()
+
+	c := client.New(client.Config{
+		GatewayAddr: gwServer.URL,
+		Timeout:     5 * time.Second,
+	})
+
+	// 平均每分钟100个，最多突发3个
+	err = c.SetRule(client.RuleConfig{
+		Path:       "/api/burst",
+		Algorithm:  limiter.TokenBucket,
+		WindowSize: time.Minute,
+		Limit:      100,
+		Burst:      3,
+	})
+	assert.NoError(t, err)
+
+	rule, err := c.GetRule("/api/burst")
+	assert.NoError(t, err)
+	if assert.NotNil(t, rule) {
+		assert.Equal(t, int64(3), rule.Burst, "规则应该包含突发容量")
+	}
+
+	for i := 0; i < 3; i++ {
+		resp, err := c.Get("/api/burst")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "突发容量内的请求应该成功")
+		resp.Body.Close()
+	}
+
+	resp, err := c.Get("/api/burst")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode, "超过突发容量的请求应该被限流")
+	resp.Body.Close()
+}
+
+// 测试并发限流在代理响应结束后释放配额
+func TestConcurrencyRateLimit(t *testing.T) {
+	release := make(chan struct{})
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 带X-Hang请求头的请求一直等到客户端断开
+		hang := release
+		if r.Header.Get("X-Hang") != "" {
+			hang = nil
+		}
+		select {
+		case <-hang:
+		case <-r.Context().Done():
+		}
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	}))
+	defer testServer.Close()
+
+	gw, err := gateway.New(gateway.Config{
+		ListenAddr: ":0",
+		Targets: map[string]string{
+			"/api": testServer.URL,
+		},
+	})
+	assert.NoError(t, err)
+
+	gwServer := httptest.NewServer(gw.GetHandler())
+	defer gwServer.Close()
+
+	c := client.New(client.Config{
+		GatewayAddr: gwServer.URL,
+		Timeout:     5 * time.Second,
+	})
+
+	err = c.SetRule(client.RuleConfig{
+		Path:       "/api/slow",
+		Algorithm:  limiter.Concurrency,
+		WindowSize: time.Minute,
+		Limit:      2,
+	})
+	assert.NoError(t, err)
+
+	inFlight := func() int64 {
+		n, err := c.GetInFlight("/api/slow", "127.0.0.1")
+		if !assert.NoError(t, err) || !assert.NotNil(t, n) {
+			return -1
+		}
+		return n.Count
+	}
+
+	// 两个请求占满并发配额
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := c.Get("/api/slow")
+			if assert.NoError(t, err) {
+				assert.Equal(t, http.StatusOK, resp.StatusCode, "并发上限内的请求应该成功")
+				resp.Body.Close()
+			}
+		}()
+	}
+	assert.Eventually(t, func() bool { return inFlight() == 2 }, time.Second, 10*time.Millisecond, "应该有2个在途请求")
+
+	resp, err := c.Get("/api/slow")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode, "超过并发上限的请求应该被限流")
+	resp.Body.Close()
+
+	// 响应结束后释放配额
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int64(0), inFlight(), "响应结束后应该释放配额")
+
+	// 客户端断开时同样释放配额
+	impatient := client.New(client.Config{
+		GatewayAddr: gwServer.URL,
+		Timeout:     50 * time.Millisecond,
+	})
+	req, err := http.NewRequest(http.MethodGet, gwServer.URL+"/api/slow", nil)
+	assert.NoError(t, err)
+	req.Header.Set("X-Hang", "1")
+	_, err = impatient.Do(req)
+	assert.Error(t, err, "客户端应该超时断开")
+	assert.Eventually(t, func() bool { return inFlight() == 0 }, time.Second, 10*time.Millisecond, "客户端断开后应该释放配额")
+
+	// 不是并发限流的规则没有在途请求数
+	n, err := c.GetInFlight("/api/other", "127.0.0.1")
+	assert.NoError(t, err)
+	assert.Nil(t, n)
+}
+
+// 测试按上游延迟和错误率调整的自适应并发限制
+func TestAdaptiveConcurrency(t *testing.T) {
+	release := make(chan struct{})
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/fail":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/api/slow":
+			<-release
+		}
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	}))
+	defer testServer.Close()
+
+	// 只能为已配置的目标前缀设置自适应限制
+	_, err := gateway.New(gateway.Config{
+		Targets:  map[string]string{"/api": testServer.URL},
+		Adaptive: map[string]adaptive.Config{"/other": {}},
+	})
+	assert.Error(t, err, "未知目标前缀的自适应配置应该报错")
+
+	gw, err := gateway.New(gateway.Config{
+		ListenAddr: ":0",
+		Targets: map[string]string{
+			"/api": testServer.URL,
+		},
+		Adaptive: map[string]adaptive.Config{
+			"/api": {InitialLimit: 2},
+		},
+	})
+	assert.NoError(t, err)
+
+	gwServer := httptest.NewServer(gw.GetHandler())
+	defer gwServer.Close()
+
+	c := client.New(client.Config{
+		GatewayAddr: gwServer.URL,
+		Timeout:     5 * time.Second,
+	})
+
+	snapshot := func() adaptive.Snapshot {
+		limits, err := c.GetAdaptiveLimits()
+		assert.NoError(t, err)
+		return limits["/api"]
+	}
+	assert.Equal(t, int64(2), snapshot().Limit, "初始限制应该是2")
+
+	// 两个请求占满并发限制
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := c.Get("/api/slow")
+			if assert.NoError(t, err) {
+				assert.Equal(t, http.StatusOK, resp.StatusCode, "限制内的请求应该成功")
+				resp.Body.Close()
+			}
+		}()
+	}
+	assert.Eventually(t, func() bool { return snapshot().InFlight == 2 }, time.Second, 10*time.Millisecond, "应该有2个在途请求")
+
+	resp, err := c.Get("/api/slow")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode, "超过自适应限制的请求应该返回503")
+	resp.Body.Close()
+
+	// 跑满限制的请求成功后限制放大
+	close(release)
+	wg.Wait()
+	assert.Equal(t, adaptive.Snapshot{Limit: 3}, snapshot(), "成功后限制应该放大")
+
+	// 上游持续出错时限制收缩
+	for i := 0; i < 5; i++ {
+		resp, err := c.Get("/api/fail")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+		resp.Body.Close()
+	}
+	assert.Equal(t, adaptive.Snapshot{Limit: 1}, snapshot(), "上游出错后限制应该收缩")
+}
+
+// 测试按目标前缀熔断
+func TestCircuitBreaker(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/fail":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/api/slow":
+			select {
+			case <-time.After(time.Second):
+			case <-r.Context().Done():
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	}))
+	defer testServer.Close()
+
+	_, err := gateway.New(gateway.Config{
+		Targets:  map[string]string{"/api": testServer.URL},
+		Breakers: map[string]breaker.Config{"/other": {}},
+	})
+	assert.Error(t, err, "未知目标前缀的熔断配置应该报错")
+
+	gw, err := gateway.New(gateway.Config{
+		ListenAddr: ":0",
+		Targets: map[string]string{
+			"/api": testServer.URL,
+		},
+		Breakers: map[string]breaker.Config{
+			"/api": {
+				FailureThreshold: 2,
+				OpenTimeout:      100 * time.Millisecond,
+				Timeout:          50 * time.Millisecond,
+			},
+		},
+	})
+	assert.NoError(t, err)
+	defer gw.Close()
+
+	gwServer := httptest.NewServer(gw.GetHandler())
+	defer gwServer.Close()
+
+	c := client.New(client.Config{
+		GatewayAddr: gwServer.URL,
+		Timeout:     5 * time.Second,
+	})
+
+	get := func(path string) *http.Response {
+		resp, err := c.Get(path)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+	state := func() breaker.State {
+		breakers, err := c.GetBreakers()
+		assert.NoError(t, err)
+		return breakers["/api"].State
+	}
+
+	// 连续失败后熔断，直接返回503
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, http.StatusInternalServerError, get("/api/fail").StatusCode)
+	}
+	assert.Equal(t, breaker.Open, state())
+	resp, err := c.Get("/api/ok")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode, "熔断期间应该返回503")
+	assert.Equal(t, "1", resp.Header.Get("Retry-After"))
+	var body map[string]string
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	resp.Body.Close()
+	assert.Equal(t, map[string]string{"error": "circuit breaker is open", "target": "/api", "state": "open"}, body)
+
+	// 手动恢复
+	assert.NoError(t, c.ResetBreaker("/api"))
+	assert.Equal(t, breaker.Closed, state())
+	assert.Equal(t, http.StatusOK, get("/api/ok").StatusCode, "恢复后应该正常转发")
+	assert.Error(t, c.ResetBreaker("/none"), "没有熔断器的目标前缀应该报错")
+
+	// 上游超时同样计为失败
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, http.StatusGatewayTimeout, get("/api/slow").StatusCode, "上游超时应该返回504")
+	}
+	assert.Equal(t, breaker.Open, state(), "连续超时后应该熔断")
+
+	// 熔断持续时间结束后，探测请求成功时恢复
+	assert.Eventually(t, func() bool { return state() == breaker.HalfOpen }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, http.StatusOK, get("/api/ok").StatusCode, "半开状态应该放行探测请求")
+	assert.Equal(t, breaker.Closed, state(), "探测成功后应该恢复")
+}
+
+// 测试网关整体过载时拒绝新请求
+func TestLoadShedding(t *testing.T) {
+	release := make(chan struct{})
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/slow" {
+			<-release
+		}
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	}))
+	defer testServer.Close()
+
+	gw, err := gateway.New(gateway.Config{
+		ListenAddr: ":0",
+		Targets: map[string]string{
+			"/api": testServer.URL,
+		},
+		Shedding: shedder.Config{
+			MaxInFlight: 1,
+			Exempt:      []string{"/api/status"},
+		},
+	})
+	assert.NoError(t, err)
+	defer gw.Close()
+
+	gwServer := httptest.NewServer(gw.GetHandler())
+	defer gwServer.Close()
+
+	c := client.New(client.Config{
+		GatewayAddr: gwServer.URL,
+		Timeout:     5 * time.Second,
+	})
+
+	snapshot := func() *shedder.Snapshot {
+		snapshot, err := c.GetShedding()
+		assert.NoError(t, err)
+		return snapshot
+	}
+
+	// 一个请求占满在途请求上限
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		resp, err := c.Get("/api/slow")
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			resp.Body.Close()
+		}
+	}()
+	assert.Eventually(t, func() bool { return snapshot().InFlight == 1 }, time.Second, 10*time.Millisecond, "应该有1个在途请求")
+
+	resp, err := c.Get("/api/ok")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode, "过载时应该返回503")
+	assert.Equal(t, "1", resp.Header.Get("Retry-After"))
+	var body map[string]string
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	resp.Body.Close()
+	assert.Equal(t, map[string]string{"error": "gateway overloaded", "reason": "in_flight"}, body)
+
+	// 健康检查和配置的路径前缀不受过载保护
+	for _, path := range []string{"/health", "/api/status"} {
+		resp, err = c.Get(path)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "%s不应该被拒绝", path)
+	}
+
+	current := snapshot()
+	assert.Equal(t, int64(1), current.Admitted)
+	assert.Equal(t, int64(1), current.Shed[shedder.InFlight])
+
+	// 在途请求结束后恢复
+	close(release)
+	<-done
+	resp, err = c.Get("/api/ok")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "在途请求结束后应该放行")
+
+	// 未配置过载保护时没有状态
+	plain, err := gateway.New(gateway.Config{Targets: map[string]string{"/api": testServer.URL}})
+	assert.NoError(t, err)
+	defer plain.Close()
+	plainServer := httptest.NewServer(plain.GetHandler())
+	defer plainServer.Close()
+	_, err = client.New(client.Config{GatewayAddr: plainServer.URL, Timeout: 5 * time.Second}).GetShedding()
+	assert.Error(t, err)
+}
+
+// 测试参数和前缀规则路径
+func TestPatternRateLimit(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	}))
+	defer testServer.Close()
+
+	gw, err := gateway.New(gateway.Config{
+		ListenAddr: ":0",
+		Targets: map[string]string{
+			"/api": testServer.URL,
+		},
+	})
+	assert.NoError(t, err)
+	defer gw.Close()
+
+	gwServer := httptest.NewServer(gw.GetHandler())
+	defer gwServer.Close()
+
+	c := client.New(client.Config{
+		GatewayAddr: gwServer.URL,
+		Timeout:     5 * time.Second,
+	})
+
+	// 每个用户ID单独计数，其他接口共用前缀规则的配额
+	assert.NoError(t, c.SetRule(client.RuleConfig{
+		Path:       "/api/users/:id",
+		Algorithm:  limiter.FixedWindow,
+		WindowSize: time.Minute,
+		Limit:      2,
+		KeyScope:   limiter.PathScope,
+	}))
+	assert.NoError(t, c.SetRule(client.RuleConfig{
+		Path:       "/api/*",
+		Algorithm:  limiter.FixedWindow,
+		WindowSize: time.Minute,
+		Limit:      3,
+	}))
+	assert.Error(t, c.SetRule(client.RuleConfig{
+		Path:       "/api/users/:uid",
+		Algorithm:  limiter.FixedWindow,
+		WindowSize: time.Minute,
+		Limit:      1,
+	}), "参数名不同的相同规则路径应该冲突")
+
+	status := func(path string) int {
+		resp, err := c.Get(path)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	for _, path := range []string{"/api/users/1", "/api/users/2"} {
+		for i := 0; i < 3; i++ {
+			if i < 2 {
+				assert.Equal(t, http.StatusOK, status(path), "%s的前2个请求应该成功", path)
+			} else {
+				assert.Equal(t, http.StatusTooManyRequests, status(path), "%s应该单独限流", path)
+			}
+		}
+	}
+	for i, path := range []string{"/api/orders", "/api/orders/1", "/api/users", "/api/items"} {
+		if i < 3 {
+			assert.Equal(t, http.StatusOK, status(path), "前缀规则的前3个请求应该成功")
+		} else {
+			assert.Equal(t, http.StatusTooManyRequests, status(path), "前缀规则下的所有路径共用配额")
+		}
+	}
+
+	// 可以按具体路径查询匹配的规则
+	rule, err := c.GetRule("/api/users/42")
+	assert.NoError(t, err)
+	if assert.NotNil(t, rule) {
+		assert.Equal(t, int64(2), rule.Limit)
+		assert.Equal(t, limiter.PathScope, rule.KeyScope)
+	}
+}
+
+// 测试按请求方法和主机配置的规则
+func TestMethodHostRateLimit(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	}))
+	defer testServer.Close()
+
+	gw, err := gateway.New(gateway.Config{
+		ListenAddr: ":0",
+		Targets: map[string]string{
+			"/api": testServer.URL,
+		},
+	})
+	assert.NoError(t, err)
+	defer gw.Close()
+
+	gwServer := httptest.NewServer(gw.GetHandler())
+	defer gwServer.Close()
+
+	c := client.New(client.Config{
+		GatewayAddr: gwServer.URL,
+		Timeout:     5 * time.Second,
+	})
+
+	// 查询订单每分钟3次，创建订单每分钟1次，合作方域名每分钟2次
+	for _, rule := range []client.RuleConfig{
+		{Path: "/api/orders", Limit: 3},
+		{Path: "/api/orders", Limit: 1, Methods: []string{http.MethodPost}},
+		{Path: "/api/orders", Limit: 2, Host: "partner.example.com"},
+	} {
+		rule.Algorithm = limiter.FixedWindow
+		rule.WindowSize = time.Minute
+		assert.NoError(t, c.SetRule(rule))
+	}
+
+	send := func(method string, host string) int {
+		req, err := http.NewRequest(method, "/api/orders", nil)
+		assert.NoError(t, err)
+		req.Host = host
+		resp, err := c.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusOK, send(http.MethodPost, ""))
+	assert.Equal(t, http.StatusTooManyRequests, send(http.MethodPost, ""), "创建订单应该更早被限流")
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusOK, send(http.MethodGet, ""), "查询订单不受创建订单的限制")
+	}
+	assert.Equal(t, http.StatusTooManyRequests, send(http.MethodGet, ""))
+	for i := 0; i < 3; i++ {
+		if i < 2 {
+			assert.Equal(t, http.StatusOK, send(http.MethodGet, "partner.example.com"), "合作方域名单独限流")
+		} else {
+			assert.Equal(t, http.StatusTooManyRequests, send(http.MethodGet, "partner.example.com"))
+		}
+	}
+
+	// 按完整的匹配条件查询和删除规则
+	rule, err := c.GetRuleFor("/api/orders", []string{http.MethodPost}, "")
+	assert.NoError(t, err)
+	if assert.NotNil(t, rule) {
+		assert.Equal(t, int64(1), rule.Limit)
+		assert.Equal(t, []string{http.MethodPost}, rule.Methods)
+	}
+	rule, err = c.GetRuleFor("/api/orders", nil, "partner.example.com")
+	assert.NoError(t, err)
+	if assert.NotNil(t, rule) {
+		assert.Equal(t, int64(2), rule.Limit)
+	}
+	assert.NoError(t, c.RemoveRuleFor("/api/orders", []string{http.MethodPost}, ""))
+	rule, err = c.GetRule("/api/orders")
+	assert.NoError(t, err)
+	if assert.NotNil(t, rule) {
+		assert.Equal(t, int64(3), rule.Limit, "删除后不影响同一路径的其他规则")
+	}
+	assert.Equal(t, http.StatusTooManyRequests, send(http.MethodPost, ""), "删除后创建订单按不限制方法的规则限流")
+}
+
+// 测试按API key和JWT声明限流，同一IP之后的客户端分别计数
+func TestKeyRateLimit(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	}))
+	defer testServer.Close()
+
+	gw, err := gateway.New(gateway.Config{
+		ListenAddr: ":0",
+		Targets: map[string]string{
+			"/api": testServer.URL,
+		},
+		JWT: map[string]limiter.JWTConfig{
+			"default": {Algorithm: "HS256", Secret: "secret"},
+		},
+	})
+	assert.NoError(t, err)
+	defer gw.Close()
+
+	gwServer := httptest.NewServer(gw.GetHandler())
+	defer gwServer.Close()
+
+	c := client.New(client.Config{
+		GatewayAddr: gwServer.URL,
+		Timeout:     5 * time.Second,
+	})
+
+	// 按X-API-Key限流，没有API key时按客户端IP；按JWT的sub声明限流
+	for _, rule := range []client.RuleConfig{
+		{Path: "/api/keys", Key: limiter.Key{Source: limiter.HeaderKey, Name: "X-API-Key"}},
+		{Path: "/api/users", Key: limiter.Key{Source: limiter.JWTKey, Name: "sub"}},
+	} {
+		rule.Algorithm = limiter.FixedWindow
+		rule.WindowSize = time.Minute
+		rule.Limit = 2
+		assert.NoError(t, c.SetRule(rule))
+	}
+	assert.Error(t, c.SetRule(client.RuleConfig{
+		Path:       "/api/other",
+		Algorithm:  limiter.FixedWindow,
+		WindowSize: time.Minute,
+		Limit:      2,
+		Key:        limiter.Key{Source: limiter.JWTKey, Name: "sub", Verifier: "unknown"},
+	}), "未注册的JWT验证器")
+
+	rule, err := c.GetRule("/api/keys")
+	assert.NoError(t, err)
+	if assert.NotNil(t, rule) {
+		assert.Equal(t, limiter.HeaderKey, rule.Key.Source)
+		assert.Equal(t, "X-API-Key", rule.Key.Name)
+	}
+
+	send := func(path string, header string, value string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, path, nil)
+		assert.NoError(t, err)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		resp, err := c.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	// 所有请求来自同一个IP，不同的API key分别计数
+	for _, apiKey := range []string{"key-a", "key-b"} {
+		for i := 0; i < 3; i++ {
+			resp := send("/api/keys", "X-API-Key", apiKey)
+			if i < 2 {
+				assert.Equal(t, http.StatusOK, resp.StatusCode, "%s的第%d个请求应该通过", apiKey, i+1)
+				assert.Equal(t, fmt.Sprint(1-i), resp.Header.Get("X-RateLimit-Remaining"), "响应头返回API key的配额")
+			} else {
+				assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode, "%s超过限制", apiKey)
+			}
+		}
+	}
+	assert.Equal(t, http.StatusOK, send("/api/keys", "", "").StatusCode, "没有API key时按客户端IP计数")
+
+	// 按JWT的sub声明计数，token无效时按客户端IP计数
+	for i := 0; i < 3; i++ {
+		resp := send("/api/users", "Authorization", "Bearer "+signHS256(t, "secret", `{"sub":"alice"}`))
+		assert.Equal(t, i < 2, resp.StatusCode == http.StatusOK, "alice的第%d个请求", i+1)
+	}
+	assert.Equal(t, http.StatusOK, send("/api/users", "Authorization", "Bearer "+signHS256(t, "secret", `{"sub":"bob"}`)).StatusCode, "不同用户分别计数")
+	forged := "Bearer " + signHS256(t, "wrong", `{"sub":"bob"}`)
+	assert.Equal(t, http.StatusOK, send("/api/users", "Authorization", forged).StatusCode)
+	assert.Equal(t, http.StatusOK, send("/api/users", "Authorization", forged).StatusCode)
+	assert.Equal(t, http.StatusTooManyRequests, send("/api/users", "Authorization", forged).StatusCode, "签名错误的token按客户端IP计数，不占用bob的配额")
+	assert.Equal(t, http.StatusOK, send("/api/users", "Authorization", "Bearer "+signHS256(t, "secret", `{"sub":"bob"}`)).StatusCode)
+}
+
+// signHS256 使用HS256签名claims，返回JWT
+func signHS256(t *testing.T, secret string, claims string) string {
+	t.Helper()
+	encode := base64.RawURLEncoding.EncodeToString
+	signed := encode([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." + encode([]byte(claims))
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signed))
+	return signed + "." + encode(mac.Sum(nil))
+}
+
+// 测试日历配额返回使用情况和重置时间
+func TestQuotaRateLimit(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	}))
+	defer testServer.Close()
+
+	gw, err := gateway.New(gateway.Config{
+		ListenAddr: ":0",
+		Targets: map[string]string{
+			"/api": testServer.URL,
+		},
+	})
+	assert.NoError(t, err)
+
+	gwServer := httptest.NewServer(gw.GetHandler())
+	defer gwServer.Close()
+
+	c := client.New(client.Config{
+		GatewayAddr: gwServer.URL,
+		Timeout:     5 * time.Second,
+	})
+
+	// 每个自然月3次
+	err = c.SetRule(client.RuleConfig{
+		Path:      "/api/billing",
+		Algorithm: limiter.Quota,
+		Limit:     3,
+		Period:    algorithms.Month,
+		Location:  "UTC",
+	})
+	assert.NoError(t, err)
+
+	rule, err := c.GetRule("/api/billing")
+	assert.NoError(t, err)
+	if assert.NotNil(t, rule) {
+		assert.Equal(t, algorithms.Month, rule.Period, "规则应该包含日历周期")
+	}
+
+	_, reset, err := algorithms.Month.Bounds(time.Now(), time.UTC)
+	assert.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		resp, err := c.Get("/api/billing")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "配额内的请求应该成功")
+		assert.Equal(t, "3", resp.Header.Get("X-RateLimit-Limit"))
+		assert.Equal(t, fmt.Sprint(2-i), resp.Header.Get("X-RateLimit-Remaining"), "剩余配额不符合预期")
+		assert.Equal(t, fmt.Sprint(reset.Unix()), resp.Header.Get("X-RateLimit-Reset"), "应该在下个月1日重置")
+		resp.Body.Close()
+	}
+
+	resp, err := c.Get("/api/billing")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode, "配额用完后应该被限流")
+	assert.Equal(t, "0", resp.Header.Get("X-RateLimit-Remaining"))
+	resp.Body.Close()
+
+	status, err := c.GetQuota("/api/billing", "127.0.0.1")
+	assert.NoError(t, err)
+	if assert.NotNil(t, status) {
+		assert.Equal(t, int64(3), status.Used)
+		assert.Equal(t, int64(0), status.Remaining)
+		assert.True(t, reset.Equal(status.Reset))
+	}
+
+	// 不是日历配额的规则没有使用情况
+	status, err = c.GetQuota("/api/other", "127.0.0.1")
+	assert.NoError(t, err)
+	assert.Nil(t, status)
+}
+
+// 测试通过响应头和管理API查询客户端的配额状态
+func TestKeyStatus(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	}))
+	defer testServer.Close()
+
+	gw, err := gateway.New(gateway.Config{
+		ListenAddr: ":0",
+		Targets: map[string]string{
+			"/api": testServer.URL,
+		},
+	})
+	assert.NoError(t, err)
+
+	gwServer := httptest.NewServer(gw.GetHandler())
+	defer gwServer.Close()
+
+	c := client.New(client.Config{
+		GatewayAddr: gwServer.URL,
+		Timeout:     5 * time.Second,
+	})
+
+	err = c.SetRule(client.RuleConfig{
+		Path:       "/api/status",
+		Algorithm:  limiter.FixedWindow,
+		WindowSize: time.Minute,
+		Limit:      2,
+	})
+	assert.NoError(t, err)
+
+	// 查询不消耗配额
+	for i := 0; i < 3; i++ {
+		statuses, err := c.GetKeyStatus("127.0.0.1")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), statuses["/api/status"].Remaining, "新客户端应该满额可用")
+	}
+
+	resp, err := c.Get("/api/status")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "2", resp.Header.Get("X-RateLimit-Limit"))
+	assert.Equal(t, "1", resp.Header.Get("X-RateLimit-Remaining"))
+	assert.NotEmpty(t, resp.Header.Get("X-RateLimit-Reset"))
+	resp.Body.Close()
+
+	statuses, err := c.GetKeyStatus("127.0.0.1")
+	assert.NoError(t, err)
+	status := statuses["/api/status"]
+	assert.Equal(t, int64(1), status.Remaining)
+	assert.Equal(t, time.Duration(0), status.Wait)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), status.Reset, 5*time.Second, "窗口结束时恢复")
+
+	resp, err = c.Get("/api/status")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "0", resp.Header.Get("X-RateLimit-Remaining"))
+	resp.Body.Close()
+
+	// 用完配额后需要等到窗口结束
+	statuses, err = c.GetKeyStatus("127.0.0.1")
+	assert.NoError(t, err)
+	assert.Greater(t, statuses["/api/status"].Wait, 50*time.Second, "用完配额后应该等到窗口结束")
+
+	// 其他客户端不受影响
+	statuses, err = c.GetKeyStatus("10.0.0.1")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), statuses["/api/status"].Remaining)
+}
+
+// 测试按上游响应状态码归还配额以及手动归还
+func TestRefundRateLimit(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/flaky" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	}))
+	defer testServer.Close()
+
+	gw, err := gateway.New(gateway.Config{
+		ListenAddr: ":0",
+		Targets: map[string]string{
+			"/api": testServer.URL,
+		},
+	})
+	assert.NoError(t, err)
+	defer gw.Close()
+
+	gwServer := httptest.NewServer(gw.GetHandler())
+	defer gwServer.Close()
+
+	c := client.New(client.Config{
+		GatewayAddr: gwServer.URL,
+		Timeout:     5 * time.Second,
+	})
+
+	for _, path := range []string{"/api/flaky", "/api/ok"} {
+		err = c.SetRule(client.RuleConfig{
+			Path:       path,
+			Algorithm:  limiter.FixedWindow,
+			WindowSize: time.Minute,
+			Limit:      2,
+			RefundOn:   []string{"5xx"},
+		})
+		assert.NoError(t, err)
+	}
+
+	rule, err := c.GetRule("/api/flaky")
+	assert.NoError(t, err)
+	if assert.NotNil(t, rule) {
+		assert.Equal(t, []string{"5xx"}, rule.RefundOn, "规则应该包含归还的状态码类别")
+	}
+
+	// 上游返回5xx的请求不计费
+	for i := 0; i < 5; i++ {
+		resp, err := c.Get("/api/flaky")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode, "上游失败的请求不应该被限流")
+		resp.Body.Close()
+	}
+
+	for i := 0; i < 2; i++ {
+		resp, err := c.Get("/api/ok")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		resp.Body.Close()
+	}
+	resp, err := c.Get("/api/ok")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode, "成功的请求应该计费")
+	resp.Body.Close()
+
+	// 手动归还后可以再次请求
+	assert.NoError(t, c.Refund("/api/ok", "127.0.0.1", 1))
+	resp, err = c.Get("/api/ok")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "归还后的配额应该可以使用")
+	resp.Body.Close()
+	assert.Error(t, c.Refund("/api/none", "127.0.0.1", 1), "没有规则的路径不能归还")
+
+	// 客户端在转发前断开的请求不计费
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest(http.MethodGet, "/api/ok", nil).WithContext(ctx)
+	gw.GetHandler().ServeHTTP(httptest.NewRecorder(), req)
+	statuses, err := c.GetKeyStatus("192.0.2.1")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), statuses["/api/ok"].Remaining, "转发前断开的请求应该归还配额")
+
+	// 状态码类别格式错误或用于并发限流时拒绝规则
+	assert.Error(t, c.SetRule(client.RuleConfig{
+		Path:       "/api/bad",
+		Algorithm:  limiter.FixedWindow,
+		WindowSize: time.Second,
+		Limit:      1,
+		RefundOn:   []string{"500"},
+	}))
+	assert.Error(t, c.SetRule(client.RuleConfig{
+		Path:       "/api/bad",
+		Algorithm:  limiter.Concurrency,
+		WindowSize: time.Second,
+		Limit:      1,
+		RefundOn:   []string{"5xx"},
+	}))
+}
+
+// 测试低优先级的请求不能使用为高优先级保留的容量
+func TestPriorityRateLimit(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	}))
+	defer testServer.Close()
+
+	gw, err := gateway.New(gateway.Config{
+		ListenAddr: ":0",
+		Targets: map[string]string{
+			"/api": testServer.URL,
+		},
+	})
+	assert.NoError(t, err)
+	defer gw.Close()
+
+	gwServer := httptest.NewServer(gw.GetHandler())
+	defer gwServer.Close()
+
+	c := client.New(client.Config{
+		GatewayAddr: gwServer.URL,
+		Timeout:     5 * time.Second,
+	})
+
+	// 每分钟10个，批量任务最多使用6个
+	err = c.SetRule(client.RuleConfig{
+		Path:       "/api/orders",
+		Algorithm:  limiter.TokenBucket,
+		WindowSize: time.Minute,
+		Limit:      10,
+		Priority: limiter.Priority{
+			Source: limiter.HeaderPriority,
+			Header: "X-Client-Tier",
+			Classes: []limiter.PriorityClass{
+				{Name: "bulk", Values: []string{"batch"}, Reserve: 0.4},
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	rule, err := c.GetRule("/api/orders")
+	assert.NoError(t, err)
+	if assert.NotNil(t, rule) {
+		assert.Equal(t, limiter.HeaderPriority, rule.Priority.Source, "规则应该包含优先级配置")
+	}
+
+	send := func(tier string) int {
+		req, err := http.NewRequest(http.MethodGet, gwServer.URL+"/api/orders", nil)
+		assert.NoError(t, err)
+		if tier != "" {
+			req.Header.Set("X-Client-Tier", tier)
+		}
+		resp, err := c.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	for i := 0; i < 6; i++ {
+		assert.Equal(t, http.StatusOK, send("batch"), "保留容量以外的配额应该可以使用")
+	}
+	assert.Equal(t, http.StatusTooManyRequests, send("batch"), "批量任务不能使用保留的容量")
+
+	// 交互请求可以使用保留的容量
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, http.StatusOK, send(""), "交互请求应该可以使用保留的容量")
+	}
+	assert.Equal(t, http.StatusTooManyRequests, send(""))
+}
+
+// 测试全局上限在客户端之间公平分配
+func TestFairRateLimit(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	}))
+	defer testServer.Close()
+
+	gw, err := gateway.New(gateway.Config{
+		ListenAddr: ":0",
+		Targets: map[string]string{
+			"/api": testServer.URL,
+		},
+	})
+	assert.NoError(t, err)
+	defer gw.Close()
+
+	gwServer := httptest.NewServer(gw.GetHandler())
+	defer gwServer.Close()
+
+	c := client.New(client.Config{
+		GatewayAddr: gwServer.URL,
+		Timeout:     5 * time.Second,
+	})
+
+	// 上游每小时合计最多6个请求
+	err = c.SetRule(client.RuleConfig{
+		Path:        "/api/upstream",
+		Algorithm:   limiter.Fair,
+		WindowSize:  time.Hour,
+		Limit:       6,
+		GlobalLimit: 6,
+	})
+	assert.NoError(t, err)
+
+	err = c.SetRule(client.RuleConfig{
+		Path:       "/api/bad",
+		Algorithm:  limiter.Fair,
+		WindowSize: time.Hour,
+		Limit:      6,
+	})
+	assert.Error(t, err, "公平分配需要全局上限")
+
+	// 按X-Forwarded-For区分客户端
+	send := func(ip string) int {
+		req, err := http.NewRequest(http.MethodGet, gwServer.URL+"/api/upstream", nil)
+		assert.NoError(t, err)
+		req.Header.Set("X-Forwarded-For", ip)
+		resp, err := c.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusOK, send("10.0.0.1"))
+	assert.Equal(t, http.StatusOK, send("10.0.0.2"))
+
+	// 两个活跃的客户端各自保证一半的容量
+	for i := 0; i < 5; i++ {
+		expected := http.StatusOK
+		if i >= 2 {
+			expected = http.StatusTooManyRequests
+		}
+		assert.Equal(t, expected, send("10.0.0.1"), "重度客户端不能占用其他客户端的份额")
+	}
+
+	statuses, err := c.GetKeyStatus("10.0.0.2")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), statuses["/api/upstream"].Remaining, "其他客户端的份额应该保留")
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, http.StatusOK, send("10.0.0.2"))
+	}
+	assert.Equal(t, http.StatusTooManyRequests, send("10.0.0.2"), "全局上限用完后应该被限流")
+}
+
+// 测试计数草图限流和请求量最大的客户端统计
+func TestHeavyHitters(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	}))
+	defer testServer.Close()
+
+	gw, err := gateway.New(gateway.Config{
+		ListenAddr: ":0",
+		Targets: map[string]string{
+			"/api": testServer.URL,
+		},
+	})
+	assert.NoError(t, err)
+	defer gw.Close()
+
+	gwServer := httptest.NewServer(gw.GetHandler())
+	defer gwServer.Close()
+
+	c := client.New(client.Config{
+		GatewayAddr: gwServer.URL,
+		Timeout:     5 * time.Second,
+	})
+
+	err = c.SetRule(client.RuleConfig{
+		Path:        "/api/search",
+		Algorithm:   limiter.CountMin,
+		WindowSize:  time.Hour,
+		Limit:       3,
+		SketchWidth: 512,
+		SketchDepth: 3,
+		TopK:        5,
+	})
+	assert.NoError(t, err)
+
+	rule, err := c.GetRule("/api/search")
+	assert.NoError(t, err)
+	if assert.NotNil(t, rule) {
+		assert.Equal(t, 512, rule.SketchWidth, "规则应该包含草图配置")
+		assert.Equal(t, 5, rule.TopK)
+	}
+
+	// 按X-Forwarded-For区分客户端
+	send := func(ip string) int {
+		req, err := http.NewRequest(http.MethodGet, gwServer.URL+"/api/search", nil)
+		assert.NoError(t, err)
+		req.Header.Set("X-Forwarded-For", ip)
+		resp, err := c.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	for i := 0; i < 5; i++ {
+		expected := http.StatusOK
+		if i >= 3 {
+			expected = http.StatusTooManyRequests
+		}
+		assert.Equal(t, expected, send("10.0.0.1"))
+	}
+	assert.Equal(t, http.StatusOK, send("10.0.0.2"), "其他客户端不受影响")
+
+	// 统计包括被限流的请求
+	hitters, err := c.GetHeavyHitters("/api/search")
+	assert.NoError(t, err)
+	assert.Equal(t, []algorithms.HeavyHitter{
+		{Key: "10.0.0.1", Count: 5},
+		{Key: "10.0.0.2", Count: 1},
+	}, hitters)
+
+	// 不是计数草图的规则没有统计
+	hitters, err = c.GetHeavyHitters("/api/other")
+	assert.NoError(t, err)
+	assert.Nil(t, hitters)
+}
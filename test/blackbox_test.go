@@ -70,9 +70,9 @@ func TestEndToEnd(t *testing.T) {
 			},
 		},
 		{
-			name:      "Sliding Window Limit",
+			name:      "Fixed Window Limit",
 			path:      "/api/test2",
-			algorithm: limiter.SlidingWindow,
+			algorithm: limiter.FixedWindow,
 			window:    time.Second,
 			limit:     5,
 			requests:  10,
@@ -0,0 +1,125 @@
+package whitebox
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wureny/FluxGo/internal/adaptive"
+)
+
+// saturate 占满当前限制，然后以相同的延迟归还所有配额
+func saturate(l *adaptive.Limiter, rtt time.Duration, dropped bool) {
+	n := 0
+	for l.Acquire() {
+		n++
+	}
+	for i := 0; i < n; i++ {
+		l.Release(rtt, dropped)
+	}
+}
+
+// 测试AIMD算法按延迟和错误调整并发限制
+func TestAIMD(t *testing.T) {
+	l, err := adaptive.NewLimiter(adaptive.Config{
+		Algorithm:        adaptive.AIMD,
+		InitialLimit:     4,
+		MinLimit:         2,
+		MaxLimit:         6,
+		LatencyThreshold: 100 * time.Millisecond,
+	})
+	assert.NoError(t, err)
+
+	for i := 0; i < 4; i++ {
+		assert.True(t, l.Acquire(), "限制内的请求应该被允许")
+	}
+	assert.False(t, l.Acquire(), "超过限制的请求应该被拒绝")
+
+	// 跑满限制时成功的请求让限制加1
+	l.Release(10*time.Millisecond, false)
+	assert.Equal(t, adaptive.Snapshot{Limit: 5, InFlight: 3}, l.Snapshot())
+	assert.True(t, l.Acquire())
+	assert.True(t, l.Acquire())
+	assert.False(t, l.Acquire(), "超过限制的请求应该被拒绝")
+
+	// 延迟超过阈值或出错时按比例缩小
+	l.Release(200*time.Millisecond, false)
+	assert.Equal(t, adaptive.Snapshot{Limit: 4, InFlight: 4}, l.Snapshot())
+	l.Release(10*time.Millisecond, true)
+	assert.Equal(t, adaptive.Snapshot{Limit: 4, InFlight: 3}, l.Snapshot())
+
+	// 在途请求不到限制的一半时成功不会放大限制
+	l.Release(10*time.Millisecond, false)
+	l.Release(10*time.Millisecond, false)
+	l.Release(10*time.Millisecond, false)
+	assert.Equal(t, adaptive.Snapshot{Limit: 5, InFlight: 0}, l.Snapshot())
+
+	// 客户端断开时只归还配额
+	assert.True(t, l.Acquire())
+	l.Cancel()
+	assert.Equal(t, adaptive.Snapshot{Limit: 5, InFlight: 0}, l.Snapshot())
+
+	// 限制保持在上下限之间
+	for i := 0; i < 10; i++ {
+		saturate(l, 10*time.Millisecond, true)
+	}
+	assert.Equal(t, int64(2), l.Snapshot().Limit, "持续出错时限制应该降到下限")
+	for i := 0; i < 10; i++ {
+		saturate(l, 10*time.Millisecond, false)
+	}
+	assert.Equal(t, int64(6), l.Snapshot().Limit, "持续成功时限制应该升到上限")
+}
+
+// 测试梯度算法跟随延迟变化调整并发限制
+func TestGradient(t *testing.T) {
+	l, err := adaptive.NewLimiter(adaptive.Config{
+		Algorithm:    adaptive.Gradient,
+		InitialLimit: 10,
+		MaxLimit:     100,
+	})
+	assert.NoError(t, err)
+
+	// 流量不大时不调整限制
+	for i := 0; i < 10; i++ {
+		assert.True(t, l.Acquire())
+		l.Release(100*time.Millisecond, false)
+	}
+	assert.Equal(t, int64(10), l.Snapshot().Limit, "流量不大时限制不应该变化")
+	assert.Equal(t, 100*time.Millisecond, l.Snapshot().RTT)
+
+	// 延迟稳定时逐步放大
+	for i := 0; i < 5; i++ {
+		saturate(l, 100*time.Millisecond, false)
+	}
+	grown := l.Snapshot().Limit
+	assert.Greater(t, grown, int64(10), "延迟稳定时限制应该放大")
+
+	// 延迟上升时收缩
+	for i := 0; i < 5; i++ {
+		saturate(l, 400*time.Millisecond, false)
+	}
+	assert.Less(t, l.Snapshot().Limit, grown, "延迟上升时限制应该收缩")
+
+	// 出错时按比例缩小
+	before := l.Snapshot().Limit
+	saturate(l, 100*time.Millisecond, true)
+	assert.Less(t, l.Snapshot().Limit, before, "出错时限制应该缩小")
+	assert.Equal(t, int64(0), l.Snapshot().InFlight)
+}
+
+// 测试无效的自适应限流配置
+func TestAdaptiveConfig(t *testing.T) {
+	_, err := adaptive.NewLimiter(adaptive.Config{})
+	assert.NoError(t, err, "空配置应该使用默认值")
+
+	for name, config := range map[string]adaptive.Config{
+		"未知算法":     {Algorithm: "vegas"},
+		"下限超过上限":   {MinLimit: 10, MaxLimit: 5},
+		"下限超过默认上限": {MinLimit: 2000},
+		"缩小比例为负":   {BackoffRatio: -0.5},
+		"缩小比例不小于1": {BackoffRatio: 1},
+	} {
+		_, err := adaptive.NewLimiter(config)
+		assert.Error(t, err, name)
+	}
+}
@@ -5,53 +5,503 @@ import (
 	"testing"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
 	"github.com/wureny/FluxGo/internal/algorithms"
+	"github.com/wureny/FluxGo/internal/algorithms/fixedwindow"
+	"github.com/wureny/FluxGo/internal/algorithms/gcra"
+	"github.com/wureny/FluxGo/internal/algorithms/leakybucket"
 	"github.com/wureny/FluxGo/internal/algorithms/slidinglog"
+	"github.com/wureny/FluxGo/internal/algorithms/slidingwindow"
+	"github.com/wureny/FluxGo/internal/algorithms/tokenbucket"
+	"github.com/wureny/FluxGo/internal/limiter"
+	"github.com/wureny/FluxGo/internal/store/redisstore"
 )
 
+// 测试用的起始时间，按秒对齐，便于计算窗口边界
+var epoch = time.Unix(1700000000, 0)
+
+// step 推进时间后发起一次请求，并断言结果
+type step struct {
+	advance time.Duration
+	allowed bool
+	wait    time.Duration
+}
+
+// admit 连续n次允许
+func admit(n int) []step {
+	steps := make([]step, n)
+	for i := range steps {
+		steps[i] = step{allowed: true}
+	}
+	return steps
+}
+
+func steps(groups ...[]step) []step {
+	var all []step
+	for _, g := range groups {
+		all = append(all, g...)
+	}
+	return all
+}
+
+// 使用虚拟时钟断言各算法精确的放行/拒绝序列
 func TestLimiters(t *testing.T) {
+	config := algorithms.Config{
+		WindowSize: time.Second,
+		Limit:      4,
+	}
+
+	// 令牌桶、漏桶、GCRA在该配置下都是每250ms恢复一个名额
+	smooth := steps(
+		admit(4),
+		[]step{
+			{allowed: false, wait: 250 * time.Millisecond},
+			{advance: 100 * time.Millisecond, allowed: false, wait: 150 * time.Millisecond},
+			{advance: 150 * time.Millisecond, allowed: true},
+			{allowed: false, wait: 250 * time.Millisecond},
+			{advance: time.Second, allowed: true},
+		},
+		admit(3),
+		[]step{{allowed: false, wait: 250 * time.Millisecond}},
+	)
+
 	tests := []struct {
 		name       string
-		newLimiter func(algorithms.Config) algorithms.RateLimiter
-		config     algorithms.Config
+		newLimiter func(algorithms.Config, ...algorithms.Option) algorithms.RateLimiter
+		steps      []step
 	}{
 		{
 			name: "SlidingLog",
-			newLimiter: func(c algorithms.Config) algorithms.RateLimiter {
-				return slidinglog.NewLimiter(c)
+			newLimiter: func(c algorithms.Config, opts ...algorithms.Option) algorithms.RateLimiter {
+				return slidinglog.NewLimiter(c, opts...)
 			},
-			config: algorithms.Config{
-				WindowSize: time.Second,
-				Limit:      10,
+			steps: steps(
+				admit(2),
+				[]step{{advance: 500 * time.Millisecond, allowed: true}},
+				admit(1),
+				[]step{
+					{allowed: false, wait: 500 * time.Millisecond},
+					{advance: 499 * time.Millisecond, allowed: false, wait: time.Millisecond},
+					// 前两条记录过期，后两条要到1.5s才过期
+					{advance: time.Millisecond, allowed: true},
+				},
+				admit(1),
+				[]step{{allowed: false, wait: 500 * time.Millisecond}},
+			),
+		},
+		{
+			name: "FixedWindow",
+			newLimiter: func(c algorithms.Config, opts ...algorithms.Option) algorithms.RateLimiter {
+				return fixedwindow.NewLimiter(c, opts...)
+			},
+			steps: steps(
+				admit(4),
+				[]step{
+					{allowed: false, wait: time.Second},
+					{advance: 999 * time.Millisecond, allowed: false, wait: time.Millisecond},
+					{advance: time.Millisecond, allowed: true},
+				},
+				admit(3),
+				[]step{{allowed: false, wait: time.Second}},
+			),
+		},
+		{
+			name: "SlidingWindowCounter",
+			newLimiter: func(c algorithms.Config, opts ...algorithms.Option) algorithms.RateLimiter {
+				return slidingwindow.NewLimiter(c, opts...)
+			},
+			steps: steps(
+				admit(4),
+				[]step{
+					// 要等到下一个窗口中上一窗口的权重降到3/4
+					{allowed: false, wait: 1250 * time.Millisecond},
+					{advance: time.Second, allowed: false, wait: 250 * time.Millisecond},
+					{advance: 250 * time.Millisecond, allowed: true},
+					{allowed: false, wait: 250 * time.Millisecond},
+				},
+			),
+		},
+		{
+			name: "LeakyBucket",
+			newLimiter: func(c algorithms.Config, opts ...algorithms.Option) algorithms.RateLimiter {
+				return leakybucket.NewLimiter(c, opts...)
+			},
+			steps: smooth,
+		},
+		{
+			name: "TokenBucket",
+			newLimiter: func(c algorithms.Config, opts ...algorithms.Option) algorithms.RateLimiter {
+				return tokenbucket.NewLimiter(c, opts...)
+			},
+			steps: smooth,
+		},
+		{
+			name: "GCRA",
+			newLimiter: func(c algorithms.Config, opts ...algorithms.Option) algorithms.RateLimiter {
+				return gcra.NewLimiter(c, opts...)
 			},
+			steps: smooth,
 		},
-		// ... 其他算法测试用例 ...
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			limiter := tt.newLimiter(tt.config)
+			clock := algorithms.NewManualClock(epoch)
+			limiter := tt.newLimiter(config, algorithms.WithClock(clock))
 			defer limiter.Close()
 
 			ctx := context.Background()
 			key := "test-key"
 
-			// 测试正常请求
-			for i := 0; i < int(tt.config.Limit); i++ {
-				allowed, _ := limiter.Allow(ctx, key)
-				assert.True(t, allowed, "请求应该被允许")
+			for i, s := range tt.steps {
+				clock.Advance(s.advance)
+				allowed, wait := limiter.Allow(ctx, key)
+				assert.Equal(t, s.allowed, allowed, "第%d步的放行结果不符合预期", i)
+				assert.Equal(t, s.wait, wait, "第%d步的等待时间不符合预期", i)
+			}
+		})
+	}
+}
+
+// 测试令牌桶和漏桶的突发容量与初始填充比例
+func TestBurst(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	fill := 0.4
+	empty := 0.0
+	configs := []struct {
+		name   string
+		config algorithms.Config
+		steps  []step
+	}{
+		{
+			// 平均每秒10个，最多突发5个
+			name: "Burst",
+			config: algorithms.Config{
+				WindowSize: time.Minute,
+				Limit:      600,
+				Burst:      5,
+			},
+			steps: steps(
+				admit(5),
+				[]step{
+					{allowed: false, wait: 100 * time.Millisecond},
+					{advance: 100 * time.Millisecond, allowed: true},
+					{allowed: false, wait: 100 * time.Millisecond},
+				},
+			),
+		},
+		{
+			// 新key只有40%的突发容量，之后按速率恢复到完整的突发容量
+			name: "InitialFill",
+			config: algorithms.Config{
+				WindowSize:  time.Minute,
+				Limit:       600,
+				Burst:       5,
+				InitialFill: &fill,
+			},
+			steps: steps(
+				admit(2),
+				[]step{
+					{allowed: false, wait: 100 * time.Millisecond},
+					{advance: 300 * time.Millisecond, allowed: true},
+				},
+				admit(2),
+				[]step{
+					{allowed: false, wait: 100 * time.Millisecond},
+					{advance: time.Second, allowed: true},
+				},
+				admit(4),
+				[]step{
+					{allowed: false, wait: 100 * time.Millisecond},
+					// 空闲更久也只是恢复到完整的突发容量，不会回到初始比例
+					{advance: 10 * time.Second, allowed: true},
+				},
+				admit(4),
+				[]step{{allowed: false, wait: 100 * time.Millisecond}},
+			),
+		},
+		{
+			// 新key没有可用配额，被拒绝的请求也要保存状态，之后按速率恢复
+			name: "InitialFillZero",
+			config: algorithms.Config{
+				WindowSize:  time.Minute,
+				Limit:       600,
+				Burst:       5,
+				InitialFill: &empty,
+			},
+			steps: []step{
+				{allowed: false, wait: 100 * time.Millisecond},
+				{advance: 50 * time.Millisecond, allowed: false, wait: 50 * time.Millisecond},
+				{advance: 50 * time.Millisecond, allowed: true},
+				{allowed: false, wait: 100 * time.Millisecond},
+				{advance: time.Second, allowed: true},
+			},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		newLimiter func(algorithms.Config, ...algorithms.Option) algorithms.RateLimiter
+	}{
+		{
+			name: "TokenBucket",
+			newLimiter: func(c algorithms.Config, opts ...algorithms.Option) algorithms.RateLimiter {
+				return tokenbucket.NewLimiter(c, opts...)
+			},
+		},
+		{
+			name: "LeakyBucket",
+			newLimiter: func(c algorithms.Config, opts ...algorithms.Option) algorithms.RateLimiter {
+				return leakybucket.NewLimiter(c, opts...)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		for _, cc := range configs {
+			for _, storeName := range []string{"Memory", "Redis"} {
+				t.Run(tt.name+"/"+cc.name+"/"+storeName, func(t *testing.T) {
+					clock := algorithms.NewManualClock(epoch)
+					opts := []algorithms.Option{algorithms.WithClock(clock)}
+					if storeName == "Redis" {
+						opts = append(opts, algorithms.WithStore(redisstore.NewFromClient(client, "burst:"+tt.name+":"+cc.name+":")))
+					}
+					limiter := tt.newLimiter(cc.config, opts...)
+					defer limiter.Close()
+
+					ctx := context.Background()
+					for i, s := range cc.steps {
+						clock.Advance(s.advance)
+						allowed, wait := limiter.Allow(ctx, "test-key")
+						assert.Equal(t, s.allowed, allowed, "第%d步的放行结果不符合预期", i)
+						assert.Equal(t, s.wait, wait, "第%d步的等待时间不符合预期", i)
+					}
+
+					// 成本超过突发容量的请求永远不会被允许
+					allowed, _ := limiter.AllowN(ctx, "other-key", cc.config.Burst+1)
+					assert.False(t, allowed, "成本超过突发容量的请求应该被拒绝")
+				})
+			}
+		}
+	}
+	// 规则中的突发容量和初始填充比例需要合法
+	rm := limiter.NewRuleManager()
+	defer rm.Close()
+	invalidFill := 1.5
+	assert.Error(t, rm.AddRule("/api/burst", limiter.Rule{
+		Algorithm: limiter.TokenBucket,
+		Config:    algorithms.Config{WindowSize: time.Second, Limit: 10, InitialFill: &invalidFill},
+	}))
+	assert.Error(t, rm.AddRule("/api/burst", limiter.Rule{
+		Algorithm: limiter.TokenBucket,
+		Config:    algorithms.Config{WindowSize: time.Second, Limit: 10, Burst: -1},
+	}))
+	assert.NoError(t, rm.AddRule("/api/burst", limiter.Rule{
+		Algorithm: limiter.TokenBucket,
+		Config:    algorithms.Config{WindowSize: time.Second, Limit: 10, Burst: 50, InitialFill: &fill},
+	}))
+	// 其他算法不支持突发容量和初始填充比例
+	assert.Error(t, rm.AddRule("/api/burst", limiter.Rule{
+		Algorithm: limiter.FixedWindow,
+		Config:    algorithms.Config{WindowSize: time.Second, Limit: 10, Burst: 50},
+	}))
+	assert.Error(t, rm.AddRule("/api/burst", limiter.Rule{
+		Algorithm: limiter.GCRA,
+		Config:    algorithms.Config{WindowSize: time.Second, Limit: 10, InitialFill: &fill},
+	}))
+}
+
+// 测试令牌桶预热期内速率从1/3线性增长到配置速率，空闲后重新预热
+func TestWarmUp(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	// 配置速率每秒3个，冷启动每秒1个，2秒内线性增长，预热期内共生成4个令牌
+	fill := 0.0
+	config := algorithms.Config{
+		WindowSize:  time.Second,
+		Limit:       3,
+		Burst:       10,
+		InitialFill: &fill,
+		WarmUp:      2 * time.Second,
+	}
+
+	for _, storeName := range []string{"Memory", "Redis"} {
+		t.Run(storeName, func(t *testing.T) {
+			clock := algorithms.NewManualClock(epoch)
+			opts := []algorithms.Option{algorithms.WithClock(clock)}
+			if storeName == "Redis" {
+				opts = append(opts, algorithms.WithStore(redisstore.NewFromClient(client, "warmup:")))
+			}
+			l := tokenbucket.NewLimiter(config, opts...)
+			defer l.Close()
+
+			ctx := context.Background()
+			key := "test-key"
+
+			allowed, wait := l.AllowN(ctx, key, 4)
+			assert.False(t, allowed)
+			assert.Equal(t, 2*time.Second, wait, "预热结束时才能积攒4个令牌")
+
+			// 第1秒平均速率为每秒1.5个，未预热时为每秒3个
+			clock.Advance(time.Second)
+			allowed, _ = l.Allow(ctx, key)
+			assert.True(t, allowed)
+			allowed, _ = l.Allow(ctx, key)
+			assert.False(t, allowed, "预热期内生成的令牌少于配置速率")
+
+			// 第2秒生成2.5个令牌，之后按配置速率生成
+			clock.Advance(time.Second)
+			assert.Equal(t, int64(3), l.Status(ctx, key).Remaining)
+			allowed, _ = l.AllowN(ctx, key, 3)
+			assert.True(t, allowed)
+			allowed, wait = l.AllowN(ctx, key, 3)
+			assert.False(t, allowed)
+			assert.Equal(t, time.Second, wait, "预热结束后按配置速率生成令牌")
+			clock.Advance(time.Second)
+			allowed, _ = l.AllowN(ctx, key, 3)
+			assert.True(t, allowed)
+
+			// 空闲后令牌桶补满到容量，已经预热完成的令牌桶不会重新预热
+			clock.Advance(4 * time.Second)
+			assert.Equal(t, int64(10), l.Status(ctx, key).Remaining, "空闲后应该补满到容量")
+			allowed, _ = l.AllowN(ctx, key, 4)
+			assert.True(t, allowed)
+			allowed, _ = l.AllowN(ctx, key, 6)
+			assert.True(t, allowed)
+			allowed, wait = l.AllowN(ctx, key, 3)
+			assert.False(t, allowed)
+			assert.Equal(t, time.Second, wait, "空闲后仍然按配置速率生成令牌")
+		})
+	}
+
+	// 预热时长不能为负，并且只有令牌桶支持
+	rm := limiter.NewRuleManager()
+	defer rm.Close()
+	assert.Error(t, rm.AddRule("/api/warmup", limiter.Rule{
+		Algorithm: limiter.TokenBucket,
+		Config:    algorithms.Config{WindowSize: time.Second, Limit: 10, WarmUp: -time.Second},
+	}))
+	assert.Error(t, rm.AddRule("/api/warmup", limiter.Rule{
+		Algorithm: limiter.FixedWindow,
+		Config:    algorithms.Config{WindowSize: time.Second, Limit: 10, WarmUp: time.Second},
+	}))
+	assert.NoError(t, rm.AddRule("/api/warmup", limiter.Rule{
+		Algorithm: limiter.TokenBucket,
+		Config:    algorithms.Config{WindowSize: time.Second, Limit: 10, WarmUp: time.Minute},
+	}))
+}
+
+// 测试GCRA的发射间隔不是整微秒时Redis与进程内存储的结果一致
+func TestGCRAInterval(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	// 发射间隔为2.5微秒
+	config := algorithms.Config{
+		WindowSize: time.Second,
+		Limit:      400000,
+	}
+
+	for _, storeName := range []string{"Memory", "Redis"} {
+		t.Run(storeName, func(t *testing.T) {
+			clock := algorithms.NewManualClock(epoch)
+			opts := []algorithms.Option{algorithms.WithClock(clock)}
+			if storeName == "Redis" {
+				opts = append(opts, algorithms.WithStore(redisstore.NewFromClient(client, "gcra:")))
+			}
+			l := gcra.NewLimiter(config, opts...)
+			defer l.Close()
+
+			ctx := context.Background()
+			key := "test-key"
+
+			allowed, _ := l.AllowN(ctx, key, config.Limit)
+			assert.True(t, allowed)
+
+			// 2个单位需要5微秒，截断为2微秒时4微秒就会放行
+			clock.Advance(4 * time.Microsecond)
+			allowed, wait := l.AllowN(ctx, key, 2)
+			assert.False(t, allowed)
+			assert.Equal(t, time.Microsecond, wait)
+			clock.Advance(time.Microsecond)
+			allowed, _ = l.AllowN(ctx, key, 2)
+			assert.True(t, allowed)
+		})
+	}
+
+	// 发射间隔小于1微秒的配置应该被拒绝
+	rm := limiter.NewRuleManager()
+	defer rm.Close()
+	assert.Error(t, rm.AddRule("/api/gcra", limiter.Rule{
+		Algorithm: limiter.GCRA,
+		Config:    algorithms.Config{WindowSize: time.Second, Limit: 2000000},
+	}))
+	assert.NoError(t, rm.AddRule("/api/gcra", limiter.Rule{
+		Algorithm: limiter.GCRA,
+		Config:    algorithms.Config{WindowSize: time.Second, Limit: 1000000},
+	}))
+}
+
+// 测试滑动窗口日志每个请求只记录一条日志，按单位数计算等待时间和归还配额
+func TestSlidingLogCost(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	config := algorithms.Config{
+		WindowSize: time.Second,
+		Limit:      10,
+	}
+
+	for _, storeName := range []string{"Memory", "Redis"} {
+		t.Run(storeName, func(t *testing.T) {
+			clock := algorithms.NewManualClock(epoch)
+			opts := []algorithms.Option{algorithms.WithClock(clock)}
+			if storeName == "Redis" {
+				opts = append(opts, algorithms.WithStore(redisstore.NewFromClient(client, "slidinglog:")))
 			}
+			l := slidinglog.NewLimiter(config, opts...)
+			defer l.Close()
 
-			// 测试超出限制
-			allowed, waitTime := limiter.Allow(ctx, key)
-			assert.False(t, allowed, "超出限制的请求应该被拒绝")
-			assert.True(t, waitTime > 0, "应该返回等待时间")
+			ctx := context.Background()
+			key := "test-key"
+
+			allowed, _ := l.AllowN(ctx, key, 4)
+			assert.True(t, allowed)
+			clock.Advance(100 * time.Millisecond)
+			allowed, _ = l.AllowN(ctx, key, 5)
+			assert.True(t, allowed)
+
+			// 需要过期2个单位，即第一个请求的4个单位
+			clock.Advance(100 * time.Millisecond)
+			allowed, wait := l.AllowN(ctx, key, 3)
+			assert.False(t, allowed)
+			assert.Equal(t, 800*time.Millisecond, wait)
+
+			// 从最近的请求中扣减2个单位
+			l.RefundN(ctx, key, 2)
+			allowed, _ = l.AllowN(ctx, key, 3)
+			assert.True(t, allowed)
+			status := l.Status(ctx, key)
+			assert.Equal(t, int64(0), status.Remaining)
+			assert.Equal(t, 800*time.Millisecond, status.Wait)
+
+			if storeName == "Redis" {
+				// 单位数合计加上3条日志
+				assert.Equal(t, int64(4), client.LLen(ctx, "slidinglog:"+key).Val())
+			}
 
-			// 测试等待后恢复
-			time.Sleep(tt.config.WindowSize)
-			allowed, _ = limiter.Allow(ctx, key)
-			assert.True(t, allowed, "等待窗口期后请求应该被允许")
+			// 第一个请求过期后释放4个单位
+			clock.Advance(800 * time.Millisecond)
+			assert.Equal(t, int64(4), l.Status(ctx, key).Remaining)
 		})
 	}
 }
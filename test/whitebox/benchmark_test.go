@@ -0,0 +1,94 @@
+package whitebox
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wureny/FluxGo/internal/algorithms"
+	"github.com/wureny/FluxGo/internal/algorithms/tokenbucket"
+	"github.com/wureny/FluxGo/internal/limiter"
+	"github.com/wureny/FluxGo/internal/store/memory"
+)
+
+// 对比单锁与分片内存存储在不同并发下的吞吐量
+// go test -bench=MemoryStore -run=^$ ./test/whitebox/
+func BenchmarkMemoryStore(b *testing.B) {
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("192.168.%d.%d", i/256, i%256)
+	}
+
+	stores := []struct {
+		name   string
+		shards int
+	}{
+		{"SingleLock", 1},
+		{"Sharded", 0},
+	}
+
+	for _, st := range stores {
+		for _, goroutines := range []int{1, 8, 64} {
+			b.Run(fmt.Sprintf("%s/goroutines=%d", st.name, goroutines), func(b *testing.B) {
+				limiter := tokenbucket.NewLimiter(algorithms.Config{
+					WindowSize: time.Second,
+					Limit:      1 << 30,
+				}, algorithms.WithStore(memory.New(memory.Config{Shards: st.shards})))
+				defer limiter.Close()
+
+				ctx := context.Background()
+				perGoroutine := b.N/goroutines + 1
+
+				b.ResetTimer()
+				var wg sync.WaitGroup
+				for g := 0; g < goroutines; g++ {
+					wg.Add(1)
+					go func(g int) {
+						defer wg.Done()
+						for i := 0; i < perGoroutine; i++ {
+							limiter.Allow(ctx, keys[(g*perGoroutine+i)%len(keys)])
+						}
+					}(g)
+				}
+				wg.Wait()
+			})
+		}
+	}
+}
+
+// 规则数量增加时按路径查找规则的耗时
+// go test -bench=RuleLookup -run=^$ ./test/whitebox/
+func BenchmarkRuleLookup(b *testing.B) {
+	for _, rules := range []int{10, 1000, 5000} {
+		b.Run(fmt.Sprintf("rules=%d", rules), func(b *testing.B) {
+			rm := limiter.NewRuleManager()
+			defer rm.Close()
+
+			// 一半为参数规则，一半为前缀规则
+			for i := 0; i < rules; i++ {
+				path := fmt.Sprintf("/api/service%d/users/:id", i/2)
+				if i%2 == 1 {
+					path = fmt.Sprintf("/api/service%d/*", i/2)
+				}
+				if err := rm.AddRule(path, limiter.Rule{
+					Algorithm: limiter.FixedWindow,
+					Config:    algorithms.Config{WindowSize: time.Second, Limit: 1},
+				}); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			paths := make([]string, 1024)
+			for i := range paths {
+				paths[i] = fmt.Sprintf("/api/service%d/users/%d/orders", i%(rules/2), i)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				rm.GetRule(paths[i%len(paths)])
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package whitebox
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wureny/FluxGo/internal/algorithms"
+	"github.com/wureny/FluxGo/internal/breaker"
+)
+
+// 测试熔断器在正常、熔断和半开状态之间的切换
+func TestBreaker(t *testing.T) {
+	clock := algorithms.NewManualClock(epoch)
+	b := breaker.NewBreaker(breaker.Config{
+		FailureThreshold: 3,
+		OpenTimeout:      10 * time.Second,
+		HalfOpenProbes:   2,
+		Now:              clock.Now,
+	})
+
+	// 成功的请求清空连续失败次数
+	for _, failed := range []bool{true, true, false, true, true} {
+		generation, ok := b.Acquire()
+		assert.True(t, ok, "正常状态应该放行")
+		b.Release(generation, failed)
+	}
+	assert.Equal(t, breaker.Snapshot{State: breaker.Closed, Failures: 2, Since: epoch}, b.Snapshot())
+
+	// 熔断前放行的请求的结果不再计入
+	stale, ok := b.Acquire()
+	assert.True(t, ok)
+	generation, _ := b.Acquire()
+	b.Release(generation, true)
+	assert.Equal(t, breaker.Snapshot{State: breaker.Open, Failures: 3, Since: epoch, RetryAt: epoch.Add(10 * time.Second)}, b.Snapshot())
+	_, ok = b.Acquire()
+	assert.False(t, ok, "熔断期间应该直接拒绝")
+	b.Release(stale, false)
+	assert.Equal(t, breaker.Open, b.Snapshot().State, "熔断前放行的请求不影响熔断状态")
+
+	// 熔断持续时间结束后放行有限的探测请求
+	clock.Advance(10 * time.Second)
+	assert.Equal(t, breaker.HalfOpen, b.Snapshot().State)
+	probe1, ok := b.Acquire()
+	assert.True(t, ok, "半开状态应该放行探测请求")
+	probe2, ok := b.Acquire()
+	assert.True(t, ok)
+	_, ok = b.Acquire()
+	assert.False(t, ok, "探测请求数已满时应该拒绝")
+
+	// 客户端断开的探测请求不计入结果，可以重新探测
+	b.Cancel(probe2)
+	probe2, ok = b.Acquire()
+	assert.True(t, ok, "取消的探测请求应该释放名额")
+
+	// 任意一个探测请求失败时重新熔断
+	b.Release(probe1, false)
+	b.Release(probe2, true)
+	snapshot := b.Snapshot()
+	assert.Equal(t, breaker.Open, snapshot.State, "探测失败时应该重新熔断")
+	assert.Equal(t, epoch.Add(20*time.Second), snapshot.RetryAt)
+
+	// 所有探测请求成功后恢复
+	clock.Advance(10 * time.Second)
+	for i := 0; i < 2; i++ {
+		generation, ok := b.Acquire()
+		assert.True(t, ok)
+		b.Release(generation, false)
+	}
+	assert.Equal(t, breaker.Snapshot{State: breaker.Closed, Since: epoch.Add(20 * time.Second)}, b.Snapshot())
+
+	// 手动恢复
+	for i := 0; i < 3; i++ {
+		generation, _ := b.Acquire()
+		b.Release(generation, true)
+	}
+	assert.Equal(t, breaker.Open, b.Snapshot().State)
+	b.Reset()
+	assert.Equal(t, breaker.Closed, b.Snapshot().State, "手动恢复后应该是正常状态")
+	_, ok = b.Acquire()
+	assert.True(t, ok)
+}
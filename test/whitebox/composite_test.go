@@ -0,0 +1,161 @@
+package whitebox
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/wureny/FluxGo/internal/algorithms"
+	"github.com/wureny/FluxGo/internal/algorithms/composite"
+	"github.com/wureny/FluxGo/internal/algorithms/fixedwindow"
+	"github.com/wureny/FluxGo/internal/limiter"
+	"github.com/wureny/FluxGo/internal/store/redisstore"
+)
+
+// 测试归还配额后可以再次放行
+func TestRefundN(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	for _, tt := range allAlgorithms {
+		for _, storeName := range []string{"Memory", "Redis"} {
+			t.Run(tt.name+"/"+storeName, func(t *testing.T) {
+				clock := algorithms.NewManualClock(epoch)
+				opts := []algorithms.Option{algorithms.WithClock(clock)}
+				if storeName == "Redis" {
+					opts = append(opts, algorithms.WithStore(redisstore.NewFromClient(client, "refund:"+tt.name+":")))
+				}
+				config := algorithms.Config{
+					WindowSize: time.Second,
+					Limit:      10,
+				}
+				l := tt.algorithm(config, opts...)
+				defer l.Close()
+
+				ctx := context.Background()
+				key := "test-key"
+
+				allowed, _ := l.AllowN(ctx, key, config.Limit)
+				assert.True(t, allowed, "请求应该被允许")
+
+				l.(algorithms.Refunder).RefundN(ctx, key, 3)
+				allowed, _ = l.AllowN(ctx, key, 3)
+				assert.True(t, allowed, "归还的配额应该可以再次使用")
+				allowed, _ = l.Allow(ctx, key)
+				assert.False(t, allowed, "只能使用归还的配额")
+
+				// 没有状态的key归还配额不会超过初始状态
+				l.(algorithms.Refunder).RefundN(ctx, "other-key", 5)
+				allowed, _ = l.AllowN(ctx, "other-key", config.Limit)
+				assert.True(t, allowed, "请求应该被允许")
+				allowed, _ = l.Allow(ctx, "other-key")
+				assert.False(t, allowed, "归还不应该超过初始配额")
+			})
+		}
+	}
+}
+
+// 测试组合限流在某个限流器拒绝时不会泄漏其他限流器的配额
+func TestCompositeLimiter(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	for _, tt := range allAlgorithms {
+		for _, storeName := range []string{"Memory", "Redis"} {
+			t.Run(tt.name+"/"+storeName, func(t *testing.T) {
+				clock := algorithms.NewManualClock(epoch)
+				withStore := func(prefix string) []algorithms.Option {
+					opts := []algorithms.Option{algorithms.WithClock(clock)}
+					if storeName == "Redis" {
+						opts = append(opts, algorithms.WithStore(redisstore.NewFromClient(client, "composite:"+tt.name+":"+prefix)))
+					}
+					return opts
+				}
+
+				// 每秒2个并且每分钟3个
+				perSecond := tt.algorithm(algorithms.Config{WindowSize: time.Second, Limit: 2}, withStore("second:")...)
+				perMinute := fixedwindow.NewLimiter(algorithms.Config{WindowSize: time.Minute, Limit: 3}, withStore("minute:")...)
+				l := composite.NewLimiter(perSecond, perMinute)
+				defer l.Close()
+
+				ctx := context.Background()
+				key := "test-key"
+
+				for i := 0; i < 2; i++ {
+					allowed, _ := l.Allow(ctx, key)
+					assert.True(t, allowed, "请求应该被允许")
+				}
+				allowed, wait := l.Allow(ctx, key)
+				assert.False(t, allowed, "超过每秒限制的请求应该被拒绝")
+				assert.NotZero(t, wait, "应该有等待时间")
+
+				// 两秒后每秒的限制已经恢复，每分钟只剩1个
+				clock.Advance(2 * time.Second)
+				allowed, _ = l.Allow(ctx, key)
+				assert.True(t, allowed, "请求应该被允许")
+
+				allowed, wait = l.Allow(ctx, key)
+				assert.False(t, allowed, "超过每分钟限制的请求应该被拒绝")
+				assert.Equal(t, 58*time.Second, wait, "应该返回拒绝的限流器的等待时间")
+
+				// 每秒限制已经放行的配额应该被归还
+				allowed, _ = perSecond.Allow(ctx, key)
+				assert.True(t, allowed, "被拒绝的请求不应该消耗每秒的配额")
+				allowed, _ = perSecond.Allow(ctx, key)
+				assert.False(t, allowed, "每秒的配额已用完")
+			})
+		}
+	}
+}
+
+// 测试规则配置多个限流
+func TestRuleLimits(t *testing.T) {
+	mr := miniredis.RunT(t)
+
+	rm := limiter.NewRuleManager()
+	defer rm.Close()
+	rm.RegisterStore(limiter.RedisStore, redisstore.New(redisstore.Config{Addr: mr.Addr()}))
+
+	for _, storeType := range []limiter.StoreType{limiter.MemoryStore, limiter.RedisStore} {
+		t.Run(string(storeType), func(t *testing.T) {
+			path := "/api/" + string(storeType)
+			rule := limiter.Rule{
+				Algorithm: limiter.TokenBucket,
+				Limits: []algorithms.Config{
+					{WindowSize: time.Second, Limit: 5},
+					{WindowSize: time.Hour, Limit: 3},
+				},
+				Store: storeType,
+			}
+			assert.NoError(t, rm.AddRule(path, rule))
+			assert.Len(t, rule.Configs(), 2)
+
+			ctx := context.Background()
+			for i := 0; i < 3; i++ {
+				allowed, _ := rm.Allow(ctx, path, "client")
+				assert.True(t, allowed, "请求应该被允许")
+			}
+			allowed, wait := rm.Allow(ctx, path, "client")
+			assert.False(t, allowed, "所有限流都需要满足")
+			assert.True(t, wait > time.Second, "应该返回每小时限制的等待时间")
+		})
+	}
+
+	// 各个限流的状态分别保存
+	assert.Len(t, mr.Keys(), 2)
+
+	// 每个限流配置都需要合法
+	err := rm.AddRule("/api/invalid", limiter.Rule{
+		Algorithm: limiter.TokenBucket,
+		Limits: []algorithms.Config{
+			{WindowSize: time.Second, Limit: 5},
+			{WindowSize: time.Hour},
+		},
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,183 @@
+package whitebox
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/wureny/FluxGo/internal/algorithms"
+	"github.com/wureny/FluxGo/internal/algorithms/concurrency"
+	"github.com/wureny/FluxGo/internal/limiter"
+	"github.com/wureny/FluxGo/internal/store/redisstore"
+)
+
+// 测试按key和全局限制在途请求数
+func TestConcurrencyLimiter(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	for _, storeName := range []string{"Memory", "Redis"} {
+		t.Run(storeName, func(t *testing.T) {
+			clock := algorithms.NewManualClock(epoch)
+			opts := []algorithms.Option{algorithms.WithClock(clock)}
+			if storeName == "Redis" {
+				opts = append(opts, algorithms.WithStore(redisstore.NewFromClient(client, "concurrency:")))
+			}
+			l := concurrency.NewLimiter(algorithms.Config{
+				WindowSize:  10 * time.Second,
+				Limit:       2,
+				GlobalLimit: 3,
+			}, opts...)
+			defer l.Close()
+
+			ctx := context.Background()
+
+			// 每个key最多2个在途请求
+			leases := make([]string, 2)
+			for i := range leases {
+				var allowed bool
+				leases[i], allowed, _ = l.AcquireN(ctx, "a", 1, 0)
+				assert.True(t, allowed, "请求应该被允许")
+			}
+			allowed, wait := l.Allow(ctx, "a")
+			assert.False(t, allowed, "超过key的并发上限应该被拒绝")
+			assert.Equal(t, 10*time.Second, wait, "最长等待到租约到期")
+			count, global := l.InFlight(ctx, "a")
+			assert.Equal(t, int64(2), count)
+			assert.Equal(t, int64(2), global)
+
+			// 全局最多3个在途请求，全局拒绝时不占用key的配额
+			allowed, _ = l.Allow(ctx, "b")
+			assert.True(t, allowed, "请求应该被允许")
+			allowed, _ = l.Allow(ctx, "b")
+			assert.False(t, allowed, "超过全局并发上限应该被拒绝")
+			count, global = l.InFlight(ctx, "b")
+			assert.Equal(t, int64(1), count, "被拒绝的请求不应该占用key的配额")
+			assert.Equal(t, int64(3), global)
+
+			// 请求完成后释放配额
+			l.ReleaseN(ctx, "a", leases[0], 1)
+			count, global = l.InFlight(ctx, "a")
+			assert.Equal(t, int64(1), count)
+			assert.Equal(t, int64(2), global)
+			allowed, _ = l.Allow(ctx, "b")
+			assert.True(t, allowed, "释放后请求应该被允许")
+
+			// 重复释放不会释放其他请求的配额
+			l.ReleaseN(ctx, "a", leases[0], 1)
+			count, _ = l.InFlight(ctx, "a")
+			assert.Equal(t, int64(1), count, "重复释放不应该影响其他租约")
+			l.ReleaseN(ctx, "a", leases[1], 5)
+			count, _ = l.InFlight(ctx, "a")
+			assert.Zero(t, count)
+
+			// 没有释放的配额在租约到期后自动释放
+			clock.Advance(10 * time.Second)
+			count, global = l.InFlight(ctx, "b")
+			assert.Zero(t, count, "租约到期后配额应该被释放")
+			assert.Zero(t, global, "租约到期后全局配额应该被释放")
+		})
+	}
+}
+
+// 测试释放租约时只释放请求自己占用的配额
+func TestConcurrencyLease(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	for _, storeName := range []string{"Memory", "Redis"} {
+		t.Run(storeName, func(t *testing.T) {
+			clock := algorithms.NewManualClock(epoch)
+			opts := []algorithms.Option{algorithms.WithClock(clock)}
+			if storeName == "Redis" {
+				opts = append(opts, algorithms.WithStore(redisstore.NewFromClient(client, "lease:")))
+			}
+			l := concurrency.NewLimiter(algorithms.Config{
+				WindowSize:  10 * time.Second,
+				Limit:       5,
+				GlobalLimit: 5,
+			}, opts...)
+			defer l.Close()
+
+			ctx := context.Background()
+
+			slow, allowed, _ := l.AcquireN(ctx, "a", 2, 0)
+			assert.True(t, allowed)
+			clock.Advance(5 * time.Second)
+			fast, allowed, _ := l.AcquireN(ctx, "a", 2, 0)
+			assert.True(t, allowed)
+			assert.NotEqual(t, slow, fast, "每个请求应该有不同的租约ID")
+
+			// 后放行的请求先完成，不能释放先放行的请求的租约
+			l.ReleaseN(ctx, "a", fast, 2)
+			count, global := l.InFlight(ctx, "a")
+			assert.Equal(t, int64(2), count)
+			assert.Equal(t, int64(2), global)
+			clock.Advance(5 * time.Second)
+			count, global = l.InFlight(ctx, "a")
+			assert.Zero(t, count, "先放行的请求的租约应该按时到期")
+			assert.Zero(t, global)
+		})
+	}
+}
+
+// 测试规则管理器中的并发限流
+func TestRuleConcurrency(t *testing.T) {
+	rm := limiter.NewRuleManager()
+	defer rm.Close()
+
+	config := algorithms.Config{
+		WindowSize: time.Minute,
+		Limit:      1,
+	}
+	assert.NoError(t, rm.AddRule("/api/concurrency", limiter.Rule{
+		Algorithm: limiter.Concurrency,
+		Config:    config,
+	}))
+	assert.NoError(t, rm.AddRule("/api/token", limiter.Rule{
+		Algorithm: limiter.TokenBucket,
+		Config:    config,
+	}))
+
+	ctx := context.Background()
+	match := func(path string) *limiter.Match {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		m, ok := rm.Match(limiter.Route(req.Method, req.Host, path), req, "client")
+		assert.True(t, ok, path)
+		return m
+	}
+	m := match("/api/concurrency")
+	decision := m.Admit(ctx, 1, 0)
+	assert.True(t, decision.Allowed)
+	assert.NotEmpty(t, decision.Lease, "并发限流应该返回租约ID")
+	allowed, _ := rm.Allow(ctx, "/api/concurrency", "client")
+	assert.False(t, allowed, "超过并发上限应该被拒绝")
+
+	inFlight, ok := rm.InFlight(ctx, "/api/concurrency", "client")
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), inFlight.Count)
+
+	m.Release(ctx, decision, 1)
+	allowed, _ = rm.Allow(ctx, "/api/concurrency", "client")
+	assert.True(t, allowed, "释放后请求应该被允许")
+
+	// 其他算法不需要释放，也没有在途请求数
+	m = match("/api/token")
+	decision = m.Admit(ctx, 1, 0)
+	assert.Empty(t, decision.Lease)
+	m.Release(ctx, decision, 1)
+	_, ok = rm.InFlight(ctx, "/api/token", "client")
+	assert.False(t, ok)
+
+	assert.Error(t, rm.AddRule("/api/limits", limiter.Rule{
+		Algorithm: limiter.Concurrency,
+		Limits:    []algorithms.Config{config, config},
+	}), "并发限流不支持多个限流配置")
+}
@@ -0,0 +1,157 @@
+package whitebox
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/wureny/FluxGo/internal/algorithms"
+	"github.com/wureny/FluxGo/internal/algorithms/countmin"
+	"github.com/wureny/FluxGo/internal/algorithms/slidingwindow"
+	"github.com/wureny/FluxGo/internal/limiter"
+	"github.com/wureny/FluxGo/internal/store/redisstore"
+)
+
+// 测试没有哈希冲突时计数草图与滑动窗口计数的结果一致
+func TestCountMinLimiter(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	config := algorithms.Config{
+		WindowSize: time.Second,
+		Limit:      4,
+	}
+
+	for _, storeName := range []string{"Memory", "Redis"} {
+		t.Run(storeName, func(t *testing.T) {
+			clock := algorithms.NewManualClock(epoch)
+			opts := []algorithms.Option{algorithms.WithClock(clock)}
+			if storeName == "Redis" {
+				opts = append(opts, algorithms.WithStore(redisstore.NewFromClient(client, "countmin:")))
+			}
+			l := countmin.NewLimiter(config, opts...)
+			defer l.Close()
+			exact := slidingwindow.NewLimiter(config, algorithms.WithClock(clock))
+			defer exact.Close()
+
+			ctx := context.Background()
+			offsets := []time.Duration{0, 300, 700, 1200, 1500, 2400, 4000}
+			for i, offset := range offsets {
+				clock.Set(epoch.Add(offset * time.Millisecond))
+				for _, key := range []string{"a", "b"} {
+					for j := 0; j < 3; j++ {
+						allowed, wait := l.AllowN(ctx, key, int64(j+1))
+						expectedAllowed, expectedWait := exact.AllowN(ctx, key, int64(j+1))
+						assert.Equal(t, expectedAllowed, allowed, "第%d次判断结果应该与滑动窗口计数一致: key=%s", i, key)
+						assert.Equal(t, expectedWait, wait, "第%d次等待时间应该与滑动窗口计数一致: key=%s", i, key)
+					}
+					if i == 2 {
+						l.RefundN(ctx, key, 2)
+						exact.RefundN(ctx, key, 2)
+					}
+					assert.Equal(t, exact.Status(ctx, key), l.Status(ctx, key), "第%d次状态应该与滑动窗口计数一致: key=%s", i, key)
+				}
+				if storeName == "Redis" && i == 3 {
+					// 窗口切换时草图整体改名为上一个窗口的草图，当前窗口只包含本窗口写入的计数器
+					assert.True(t, mr.Exists("countmin:*:prev"))
+					fields, err := mr.HKeys("countmin:*")
+					assert.NoError(t, err)
+					assert.LessOrEqual(t, len(fields), 1+2*4)
+				}
+			}
+
+			allowed, wait := l.AllowN(ctx, "c", 5)
+			assert.False(t, allowed, "超过限制的请求永远不会被允许")
+			assert.Equal(t, time.Duration(0), wait)
+		})
+	}
+
+	// 只有一个计数器时所有key共用计数，冲突的key会被提前限流
+	clock := algorithms.NewManualClock(epoch)
+	l := countmin.NewLimiter(algorithms.Config{WindowSize: time.Second, Limit: 4, SketchWidth: 1, SketchDepth: 1}, algorithms.WithClock(clock))
+	defer l.Close()
+
+	ctx := context.Background()
+	allowed, _ := l.AllowN(ctx, "a", 4)
+	assert.True(t, allowed)
+	allowed, _ = l.Allow(ctx, "b")
+	assert.False(t, allowed, "草图只会高估计数")
+}
+
+// 测试请求量最大的key的统计
+func TestHeavyHitters(t *testing.T) {
+	clock := algorithms.NewManualClock(epoch)
+	l := countmin.NewLimiter(algorithms.Config{WindowSize: time.Second, Limit: 4, TopK: 2}, algorithms.WithClock(clock))
+	defer l.Close()
+
+	ctx := context.Background()
+	assert.Empty(t, l.TopK(ctx))
+
+	l.Allow(ctx, "c")
+	for i := 0; i < 5; i++ {
+		l.Allow(ctx, "a")
+	}
+	for i := 0; i < 3; i++ {
+		l.Allow(ctx, "b")
+	}
+
+	// 被拒绝的请求同样计数，新key替换计数最小的key并继承其计数
+	assert.Equal(t, []algorithms.HeavyHitter{
+		{Key: "a", Count: 5},
+		{Key: "b", Count: 4, Error: 1},
+	}, l.TopK(ctx))
+
+	// 上一个窗口的计数按重叠比例加权
+	clock.Advance(1500 * time.Millisecond)
+	assert.Equal(t, []algorithms.HeavyHitter{
+		{Key: "a", Count: 3},
+		{Key: "b", Count: 2, Error: 1},
+	}, l.TopK(ctx))
+
+	clock.Advance(time.Second)
+	assert.Empty(t, l.TopK(ctx), "两个窗口之后不再有计数")
+
+	// 被替换的总是计数最小的key
+	for i, key := range []string{"a", "a", "a", "a", "a", "b", "b", "c", "c", "d"} {
+		clock.Set(epoch.Add(10*time.Second + time.Duration(i)*time.Millisecond))
+		l.Allow(ctx, key)
+	}
+	assert.Equal(t, []algorithms.HeavyHitter{
+		{Key: "a", Count: 5},
+		{Key: "d", Count: 5, Error: 4},
+	}, l.TopK(ctx))
+
+	// 规则管理器只对计数草图规则返回统计
+	rm := limiter.NewRuleManager()
+	defer rm.Close()
+	assert.Error(t, rm.AddRule("/api/bad", limiter.Rule{
+		Algorithm: limiter.FixedWindow,
+		Config:    algorithms.Config{WindowSize: time.Second, Limit: 10, TopK: 5},
+	}), "只有计数草图支持统计请求量最大的key")
+	assert.Error(t, rm.AddRule("/api/bad", limiter.Rule{
+		Algorithm: limiter.CountMin,
+		Config:    algorithms.Config{WindowSize: time.Second, Limit: 10, SketchWidth: -1},
+	}))
+	for _, config := range []algorithms.Config{
+		{WindowSize: time.Second, Limit: 10, SketchWidth: countmin.MaxWidth + 1},
+		{WindowSize: time.Second, Limit: 10, SketchDepth: countmin.MaxDepth + 1},
+		{WindowSize: time.Second, Limit: 10, TopK: countmin.MaxTopK + 1},
+	} {
+		assert.Error(t, rm.AddRule("/api/bad", limiter.Rule{Algorithm: limiter.CountMin, Config: config}), "草图大小不能超过上限")
+	}
+	assert.NoError(t, rm.AddRule("/api/search", limiter.Rule{
+		Algorithm: limiter.CountMin,
+		Config:    algorithms.Config{WindowSize: time.Second, Limit: 10, SketchWidth: 256, SketchDepth: 3},
+	}))
+
+	rm.AllowN(ctx, "/api/search", "a", 3)
+	hitters, ok := rm.TopK(ctx, "/api/search")
+	assert.True(t, ok)
+	assert.Equal(t, []algorithms.HeavyHitter{{Key: "a", Count: 3}}, hitters)
+	_, ok = rm.TopK(ctx, "/api/none")
+	assert.False(t, ok)
+}
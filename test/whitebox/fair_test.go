@@ -0,0 +1,131 @@
+package whitebox
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/wureny/FluxGo/internal/algorithms"
+	"github.com/wureny/FluxGo/internal/algorithms/fair"
+	"github.com/wureny/FluxGo/internal/limiter"
+	"github.com/wureny/FluxGo/internal/store/redisstore"
+)
+
+// 测试全局上限在key之间的公平分配
+func TestFairLimiter(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	config := algorithms.Config{
+		WindowSize:  time.Second,
+		Limit:       10,
+		GlobalLimit: 10,
+	}
+
+	for _, storeName := range []string{"Memory", "Redis"} {
+		t.Run(storeName, func(t *testing.T) {
+			clock := algorithms.NewManualClock(epoch)
+			opts := []algorithms.Option{algorithms.WithClock(clock)}
+			if storeName == "Redis" {
+				opts = append(opts, algorithms.WithStore(redisstore.NewFromClient(client, "fair:")))
+			}
+			l := fair.NewLimiter(config, opts...)
+			defer l.Close()
+
+			ctx := context.Background()
+			assert.Equal(t, algorithms.Status{Limit: 10, Remaining: 10, Reset: epoch}, l.Status(ctx, "heavy"))
+
+			// 没有其他活跃key时可以使用全部容量
+			for i := 0; i < 10; i++ {
+				allowed, _ := l.Allow(ctx, "heavy")
+				assert.True(t, allowed, "没有竞争时应该可以借用其他key的份额")
+			}
+			allowed, wait := l.Allow(ctx, "heavy")
+			assert.False(t, allowed, "超过全局上限的请求应该被拒绝")
+			assert.Equal(t, time.Second, wait, "应该等到下一个窗口")
+			allowed, _ = l.Allow(ctx, "light")
+			assert.False(t, allowed, "全局上限用完后其他key也被拒绝")
+			status := l.Status(ctx, "light")
+			assert.Equal(t, int64(0), status.Remaining)
+			assert.Equal(t, time.Second, status.Wait)
+
+			// 被拒绝的key不是活跃的，不保留份额
+			clock.Advance(time.Second)
+			assert.Equal(t, int64(10), l.Status(ctx, "heavy").Remaining, "被拒绝的key不应该成为活跃key")
+			if storeName == "Redis" {
+				for _, key := range []string{"a", "b", "c"} {
+					l.AllowN(ctx, key, 11)
+				}
+				fields, err := mr.HKeys("fair:*")
+				assert.NoError(t, err)
+				assert.Len(t, fields, 5, "被拒绝的key不应该写入状态")
+			}
+
+			// light有请求被放行后两个key都是活跃的，各自保证一半的容量
+			allowed, _ = l.Allow(ctx, "light")
+			assert.True(t, allowed)
+			if storeName == "Redis" {
+				assert.True(t, mr.Exists("fair:*:prev"), "窗口切换后上一个窗口的用量应该保存在单独的key中")
+			}
+			for i := 0; i < 10; i++ {
+				allowed, _ = l.Allow(ctx, "heavy")
+				assert.Equal(t, i < 5, allowed, "重度客户端不能占用其他活跃key的份额")
+			}
+			assert.Equal(t, int64(0), l.Status(ctx, "heavy").Remaining)
+			assert.Equal(t, int64(4), l.Status(ctx, "light").Remaining, "活跃key应该保留公平份额")
+			allowed, _ = l.AllowN(ctx, "light", 4)
+			assert.True(t, allowed)
+
+			// 归还后可以再次使用
+			l.RefundN(ctx, "heavy", 2)
+			allowed, _ = l.AllowN(ctx, "heavy", 2)
+			assert.True(t, allowed, "归还后的份额应该可以使用")
+
+			// light在一个窗口内没有请求后不再活跃，heavy可以使用全部容量
+			clock.Advance(time.Second)
+			allowed, _ = l.AllowN(ctx, "heavy", 6)
+			assert.False(t, allowed, "上一个窗口活跃的key仍然保留份额")
+			clock.Advance(time.Second)
+			allowed, _ = l.AllowN(ctx, "heavy", 10)
+			assert.True(t, allowed, "不活跃的key不再保留份额")
+
+			// 超过全局上限的请求永远不会被允许
+			allowed, wait = l.AllowN(ctx, "other", 11)
+			assert.False(t, allowed)
+			assert.Equal(t, time.Duration(0), wait)
+		})
+	}
+
+	// 每个key的上限小于全局上限时，单个key不能使用全部容量
+	clock := algorithms.NewManualClock(epoch)
+	l := fair.NewLimiter(algorithms.Config{WindowSize: time.Second, Limit: 4, GlobalLimit: 10}, algorithms.WithClock(clock))
+	defer l.Close()
+
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		allowed, _ := l.Allow(ctx, "a")
+		assert.Equal(t, i < 4, allowed, "不能超过每个key的上限")
+	}
+	allowed, _ := l.AllowN(ctx, "b", 4)
+	assert.True(t, allowed)
+	allowed, _ = l.AllowN(ctx, "c", 3)
+	assert.False(t, allowed, "剩余的全局容量不足")
+	allowed, _ = l.AllowN(ctx, "c", 2)
+	assert.True(t, allowed)
+
+	// 规则必须设置全局上限
+	rm := limiter.NewRuleManager()
+	defer rm.Close()
+	assert.Error(t, rm.AddRule("/api/fair", limiter.Rule{
+		Algorithm: limiter.Fair,
+		Config:    algorithms.Config{WindowSize: time.Second, Limit: 10},
+	}), "公平分配需要全局上限")
+	assert.NoError(t, rm.AddRule("/api/fair", limiter.Rule{
+		Algorithm: limiter.Fair,
+		Config:    config,
+	}))
+}
@@ -0,0 +1,234 @@
+package whitebox
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wureny/FluxGo/internal/algorithms"
+	"github.com/wureny/FluxGo/internal/limiter"
+)
+
+// 测试从请求头、查询参数、Cookie和组合中提取限流key
+func TestRuleKey(t *testing.T) {
+	rm := limiter.NewRuleManager()
+	defer rm.Close()
+
+	add := func(path string, key limiter.Key) error {
+		return rm.AddRule(path, limiter.Rule{
+			Algorithm: limiter.FixedWindow,
+			Config:    algorithms.Config{WindowSize: time.Minute, Limit: 1},
+			Key:       key,
+		})
+	}
+	apiKey := limiter.Key{Source: limiter.HeaderKey, Name: "X-API-Key"}
+	assert.NoError(t, add("/ip", limiter.Key{}))
+	assert.NoError(t, add("/header", apiKey))
+	assert.NoError(t, add("/query", limiter.Key{Source: limiter.QueryKey, Name: "tenant"}))
+	assert.NoError(t, add("/cookie", limiter.Key{Source: limiter.CookieKey, Name: "session"}))
+	assert.NoError(t, add("/composite", limiter.Key{
+		Source: limiter.CompositeKey,
+		Parts:  []limiter.Key{apiKey, {Source: limiter.QueryKey, Name: "user"}},
+	}))
+	assert.NoError(t, add("/fallback", limiter.Key{
+		Source:   limiter.CookieKey,
+		Name:     "session",
+		Fallback: &apiKey,
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/?tenant=acme&user=42", nil)
+	r.Header.Set("X-API-Key", "abc")
+	r.AddCookie(&http.Cookie{Name: "session", Value: "s1"})
+	cases := map[string]string{
+		"/ip":        "10.0.0.1",
+		"/header":    "header:abc",
+		"/query":     "query:acme",
+		"/cookie":    "cookie:s1",
+		"/composite": "composite:10:header:abc|8:query:42",
+		"/fallback":  "cookie:s1",
+		"/none":      "", // 没有匹配的规则
+	}
+	for path, want := range cases {
+		assert.Equal(t, want, matchKey(rm, path, r, "10.0.0.1"), path)
+	}
+
+	// 来源缺失时依次回退，最后使用客户端IP
+	bare := httptest.NewRequest(http.MethodGet, "/?user=42", nil)
+	bare.Header.Set("X-API-Key", "abc")
+	assert.Equal(t, "header:abc", matchKey(rm, "/fallback", bare, "10.0.0.1"), "Cookie缺失时使用API key")
+	assert.Equal(t, "10.0.0.1", matchKey(rm, "/cookie", bare, "10.0.0.1"), "Cookie缺失时使用客户端IP")
+	bare.Header.Del("X-API-Key")
+	assert.Equal(t, "10.0.0.1", matchKey(rm, "/fallback", bare, "10.0.0.1"), "回退的来源也缺失时使用客户端IP")
+	assert.Equal(t, "10.0.0.1", matchKey(rm, "/composite", bare, "10.0.0.1"), "组合的任意一部分缺失时整体回退")
+
+	// 部分的值包含分隔符时不会与其他组合相同
+	collide := func(apiKey, user string) string {
+		r := httptest.NewRequest(http.MethodGet, "/?user="+user, nil)
+		r.Header.Set("X-API-Key", apiKey)
+		return matchKey(rm, "/composite", r, "10.0.0.1")
+	}
+	assert.NotEqual(t, collide("a|query:b", "c"), collide("a", "b|query:c"), "组合的各部分不能互相冲突")
+
+	// 相同的值来自不同来源时不会共用配额
+	spoofed := httptest.NewRequest(http.MethodGet, "/", nil)
+	spoofed.Header.Set("X-API-Key", "10.0.0.1")
+	assert.NotEqual(t, "10.0.0.1", matchKey(rm, "/header", spoofed, "10.0.0.2"), "API key不能冒充其他客户端的IP")
+
+	// 自定义提取器
+	assert.Error(t, rm.RegisterExtractor("header", limiter.KeyExtractorFunc(nil)), "不能覆盖内置的来源")
+	assert.Error(t, add("/tenant", limiter.Key{Source: "tenant"}), "未注册的来源")
+	assert.NoError(t, rm.RegisterExtractor("tenant", limiter.KeyExtractorFunc(func(r *http.Request, name string) (string, bool) {
+		return r.Header.Get(name), r.Header.Get(name) != ""
+	})))
+	assert.NoError(t, add("/tenant", limiter.Key{Source: "tenant", Name: "X-Tenant"}))
+	r.Header.Set("X-Tenant", "acme")
+	assert.Equal(t, "tenant:acme", matchKey(rm, "/tenant", r, "10.0.0.1"))
+
+	// key配置的校验
+	assert.Error(t, add("/invalid", limiter.Key{Source: limiter.HeaderKey}), "请求头来源需要名称")
+	assert.Error(t, add("/invalid", limiter.Key{Source: limiter.CompositeKey}), "组合需要至少一部分")
+	assert.Error(t, add("/invalid", limiter.Key{
+		Source: limiter.CompositeKey,
+		Parts:  []limiter.Key{{Source: limiter.HeaderKey, Name: "X-API-Key", Fallback: &limiter.Key{}}},
+	}), "组合的部分不能单独回退")
+	assert.Error(t, add("/invalid", limiter.Key{Source: limiter.JWTKey, Name: "sub"}), "未注册的JWT验证器")
+	assert.Error(t, add("/invalid", limiter.Key{Source: limiter.IPKey, Fallback: &limiter.Key{Source: "unknown"}}), "回退配置同样需要校验")
+
+	// 不同key分别计数
+	ctx := r.Context()
+	allowed, _ := rm.Allow(ctx, "/header", matchKey(rm, "/header", r, "10.0.0.1"))
+	assert.True(t, allowed)
+	allowed, _ = rm.Allow(ctx, "/header", matchKey(rm, "/header", r, "10.0.0.1"))
+	assert.False(t, allowed, "同一API key超过限制")
+	allowed, _ = rm.Allow(ctx, "/header", matchKey(rm, "/header", spoofed, "10.0.0.1"))
+	assert.True(t, allowed, "同一IP的其他API key不受影响")
+}
+
+// matchKey 返回请求在匹配规则下的限流key，没有匹配的规则时返回空字符串
+func matchKey(rm *limiter.RuleManager, path string, r *http.Request, clientIP string) string {
+	match, ok := rm.Match(limiter.Route(r.Method, r.Host, path), r, clientIP)
+	if !ok {
+		return ""
+	}
+	return match.Key()
+}
+
+// 测试验证JWT并按声明提取限流key
+func TestJWTKey(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	rm := limiter.NewRuleManager()
+	defer rm.Close()
+
+	assert.Error(t, rm.RegisterJWT("default", limiter.JWTConfig{Algorithm: "none"}), "不支持的算法")
+	assert.Error(t, rm.RegisterJWT("default", limiter.JWTConfig{Algorithm: "HS256"}), "HS算法需要密钥")
+	assert.Error(t, rm.RegisterJWT("default", limiter.JWTConfig{Algorithm: "RS256", PublicKey: "invalid"}), "RS算法需要PEM格式的公钥")
+	assert.NoError(t, rm.RegisterJWT("default", limiter.JWTConfig{
+		Algorithm: "HS256",
+		Secret:    "secret",
+		Issuer:    "fluxgo",
+		Audience:  "api",
+		Leeway:    time.Minute,
+		Now:       func() time.Time { return now },
+	}))
+	assert.NoError(t, rm.AddRule("/users", limiter.Rule{
+		Algorithm: limiter.FixedWindow,
+		Config:    algorithms.Config{WindowSize: time.Minute, Limit: 1},
+		Key:       limiter.Key{Source: limiter.JWTKey, Name: "sub"},
+	}))
+
+	keyOf := func(token string) string {
+		r := httptest.NewRequest(http.MethodGet, "/users", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		return matchKey(rm, "/users", r, "10.0.0.1")
+	}
+	hs256 := func(secret string, header string, claims string) string {
+		encode := base64.RawURLEncoding.EncodeToString
+		signed := encode([]byte(header)) + "." + encode([]byte(claims))
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(signed))
+		return signed + "." + encode(mac.Sum(nil))
+	}
+	header := `{"alg":"HS256","typ":"JWT"}`
+
+	cases := []struct {
+		name  string
+		token string
+		want  string
+	}{
+		{"有效的token", hs256("secret", header, `{"sub":"alice","iss":"fluxgo","aud":"api","exp":1700000060}`), "jwt:alice"},
+		{"数字声明", hs256("secret", header, `{"sub":12345678901,"iss":"fluxgo","aud":["web","api"]}`), "jwt:12345678901"},
+		{"时钟偏差内的过期时间", hs256("secret", header, `{"sub":"alice","iss":"fluxgo","aud":"api","exp":1699999990}`), "jwt:alice"},
+		{"签名错误", hs256("wrong", header, `{"sub":"alice","iss":"fluxgo","aud":"api"}`), "10.0.0.1"},
+		{"已过期", hs256("secret", header, `{"sub":"alice","iss":"fluxgo","aud":"api","exp":1699999900}`), "10.0.0.1"},
+		{"尚未生效", hs256("secret", header, `{"sub":"alice","iss":"fluxgo","aud":"api","nbf":1700000100}`), "10.0.0.1"},
+		{"过期时间不是数字", hs256("secret", header, `{"sub":"alice","iss":"fluxgo","aud":"api","exp":"0"}`), "10.0.0.1"},
+		{"过期时间为null", hs256("secret", header, `{"sub":"alice","iss":"fluxgo","aud":"api","exp":null}`), "10.0.0.1"},
+		{"生效时间不是数字", hs256("secret", header, `{"sub":"alice","iss":"fluxgo","aud":"api","nbf":"1700000100"}`), "10.0.0.1"},
+		{"签发者错误", hs256("secret", header, `{"sub":"alice","iss":"other","aud":"api"}`), "10.0.0.1"},
+		{"受众错误", hs256("secret", header, `{"sub":"alice","iss":"fluxgo","aud":"web"}`), "10.0.0.1"},
+		{"缺少声明", hs256("secret", header, `{"iss":"fluxgo","aud":"api"}`), "10.0.0.1"},
+		{"算法不一致", hs256("secret", `{"alg":"HS512"}`, `{"sub":"alice","iss":"fluxgo","aud":"api"}`), "10.0.0.1"},
+		{"alg为none", base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." +
+			base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"alice","iss":"fluxgo","aud":"api"}`)) + ".", "10.0.0.1"},
+		{"格式错误", "not-a-token", "10.0.0.1"},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.want, keyOf(c.token), c.name)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/users", nil)
+	r.Header.Set("Authorization", hs256("secret", header, `{"sub":"alice","iss":"fluxgo","aud":"api"}`))
+	assert.Equal(t, "10.0.0.1", matchKey(rm, "/users", r, "10.0.0.1"), "缺少Bearer前缀")
+	for _, scheme := range []string{"bearer", "BEARER"} {
+		r.Header.Set("Authorization", scheme+" "+hs256("secret", header, `{"sub":"alice","iss":"fluxgo","aud":"api"}`))
+		assert.Equal(t, "jwt:alice", matchKey(rm, "/users", r, "10.0.0.1"), "认证方案不区分大小写: %s", scheme)
+	}
+}
+
+// 测试使用RSA公钥验证JWT
+func TestJWTKeyRS256(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	assert.NoError(t, err)
+	publicKey := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	verifier, err := limiter.NewJWTVerifier(limiter.JWTConfig{Algorithm: "RS256", PublicKey: publicKey})
+	assert.NoError(t, err)
+
+	rs256 := func(key *rsa.PrivateKey, header string, claims string) string {
+		encode := base64.RawURLEncoding.EncodeToString
+		signed := encode([]byte(header)) + "." + encode([]byte(claims))
+		digest := sha256.Sum256([]byte(signed))
+		signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		assert.NoError(t, err)
+		return signed + "." + encode(signature)
+	}
+
+	sub, err := verifier.Claim(rs256(privateKey, `{"alg":"RS256"}`, `{"sub":"alice"}`), "sub")
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", sub)
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	_, err = verifier.Claim(rs256(otherKey, `{"alg":"RS256"}`, `{"sub":"alice"}`), "sub")
+	assert.Error(t, err, "其他私钥签名的token")
+
+	// 使用公钥作为HMAC密钥伪造的token
+	encode := base64.RawURLEncoding.EncodeToString
+	signed := encode([]byte(`{"alg":"HS256"}`)) + "." + encode([]byte(`{"sub":"alice"}`))
+	mac := hmac.New(sha256.New, []byte(publicKey))
+	mac.Write([]byte(signed))
+	_, err = verifier.Claim(signed+"."+encode(mac.Sum(nil)), "sub")
+	assert.Error(t, err, "不接受与配置不同的算法")
+}
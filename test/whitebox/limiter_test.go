@@ -2,72 +2,87 @@ package whitebox
 
 import (
 	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
 	"github.com/wureny/FluxGo/internal/algorithms"
+	"github.com/wureny/FluxGo/internal/algorithms/fixedwindow"
+	"github.com/wureny/FluxGo/internal/algorithms/gcra"
 	"github.com/wureny/FluxGo/internal/algorithms/leakybucket"
 	"github.com/wureny/FluxGo/internal/algorithms/slidinglog"
 	"github.com/wureny/FluxGo/internal/algorithms/slidingwindow"
 	"github.com/wureny/FluxGo/internal/algorithms/tokenbucket"
+	"github.com/wureny/FluxGo/internal/limiter"
+	"github.com/wureny/FluxGo/internal/store/redisstore"
 )
 
-// 测试所有限流算法的基本功能
-func TestRateLimiters(t *testing.T) {
-	tests := []struct {
-		name      string
-		algorithm func(algorithms.Config) algorithms.RateLimiter
-		limit     int64
-		window    time.Duration
-	}{
-		{
-			name: "SlidingLog",
-			algorithm: func(c algorithms.Config) algorithms.RateLimiter {
-				return slidinglog.NewLimiter(c)
-			},
-			limit:  10,
-			window: time.Second,
+// 所有限流算法的构造函数
+var allAlgorithms = []struct {
+	name      string
+	algorithm func(algorithms.Config, ...algorithms.Option) algorithms.RateLimiter
+}{
+	{
+		name: "SlidingLog",
+		algorithm: func(c algorithms.Config, opts ...algorithms.Option) algorithms.RateLimiter {
+			return slidinglog.NewLimiter(c, opts...)
 		},
-		{
-			name: "SlidingWindow",
-			algorithm: func(c algorithms.Config) algorithms.RateLimiter {
-				return slidingwindow.NewLimiter(c)
-			},
-			limit:  10,
-			window: time.Second,
+	},
+	{
+		name: "FixedWindow",
+		algorithm: func(c algorithms.Config, opts ...algorithms.Option) algorithms.RateLimiter {
+			return fixedwindow.NewLimiter(c, opts...)
 		},
-		{
-			name: "LeakyBucket",
-			algorithm: func(c algorithms.Config) algorithms.RateLimiter {
-				return leakybucket.NewLimiter(c)
-			},
-			limit:  10,
-			window: time.Second,
+	},
+	{
+		name: "SlidingWindowCounter",
+		algorithm: func(c algorithms.Config, opts ...algorithms.Option) algorithms.RateLimiter {
+			return slidingwindow.NewLimiter(c, opts...)
 		},
-		{
-			name: "TokenBucket",
-			algorithm: func(c algorithms.Config) algorithms.RateLimiter {
-				return tokenbucket.NewLimiter(c)
-			},
-			limit:  10,
-			window: time.Second,
+	},
+	{
+		name: "LeakyBucket",
+		algorithm: func(c algorithms.Config, opts ...algorithms.Option) algorithms.RateLimiter {
+			return leakybucket.NewLimiter(c, opts...)
 		},
+	},
+	{
+		name: "TokenBucket",
+		algorithm: func(c algorithms.Config, opts ...algorithms.Option) algorithms.RateLimiter {
+			return tokenbucket.NewLimiter(c, opts...)
+		},
+	},
+	{
+		name: "GCRA",
+		algorithm: func(c algorithms.Config, opts ...algorithms.Option) algorithms.RateLimiter {
+			return gcra.NewLimiter(c, opts...)
+		},
+	},
+}
+
+// 测试所有限流算法的基本功能
+func TestRateLimiters(t *testing.T) {
+	config := algorithms.Config{
+		WindowSize: time.Second,
+		Limit:      10,
 	}
 
-	for _, tt := range tests {
+	for _, tt := range allAlgorithms {
 		t.Run(tt.name, func(t *testing.T) {
-			limiter := tt.algorithm(algorithms.Config{
-				WindowSize: tt.window,
-				Limit:      tt.limit,
-			})
+			clock := algorithms.NewManualClock(epoch)
+			limiter := tt.algorithm(config, algorithms.WithClock(clock))
 			defer limiter.Close()
 
 			ctx := context.Background()
 			key := "test-key"
 
 			// 测试允许的请求
-			for i := 0; i < int(tt.limit); i++ {
+			for i := 0; i < int(config.Limit); i++ {
 				allowed, wait := limiter.Allow(ctx, key)
 				assert.True(t, allowed, "请求应该被允许")
 				assert.Zero(t, wait, "不应该有等待时间")
@@ -78,13 +93,222 @@ func TestRateLimiters(t *testing.T) {
 			assert.False(t, allowed, "超出限制的请求应该被拒绝")
 			assert.NotZero(t, wait, "应该有等待时间")
 
-			// 等待时间窗口过期
-			time.Sleep(tt.window)
+			// 等待时间之前仍然被拒绝
+			clock.Advance(wait - time.Microsecond)
+			allowed, _ = limiter.Allow(ctx, key)
+			assert.False(t, allowed, "等待时间之前请求应该被拒绝")
 
 			// 测试恢复后的请求
+			clock.Advance(time.Microsecond)
 			allowed, wait = limiter.Allow(ctx, key)
 			assert.True(t, allowed, "等待后请求应该被允许")
 			assert.Zero(t, wait, "不应该有等待时间")
 		})
 	}
 }
+
+// 测试滑动窗口计数在窗口边界不会放行2倍的请求，并且返回准确的等待时间
+func TestSlidingWindowCounter(t *testing.T) {
+	config := algorithms.Config{
+		WindowSize: time.Second,
+		Limit:      10,
+	}
+	clock := algorithms.NewManualClock(epoch)
+	limiter := slidingwindow.NewLimiter(config, algorithms.WithClock(clock))
+	defer limiter.Close()
+
+	ctx := context.Background()
+	key := "test-key"
+
+	// 在窗口末尾用完配额
+	clock.Advance(850 * time.Millisecond)
+	for i := 0; i < int(config.Limit); i++ {
+		allowed, _ := limiter.Allow(ctx, key)
+		assert.True(t, allowed, "请求应该被允许")
+	}
+
+	// 刚进入下一个窗口时，上一个窗口的计数仍然占据大部分配额
+	clock.Set(epoch.Add(config.WindowSize + 10*time.Millisecond))
+	allowed, wait := limiter.Allow(ctx, key)
+	assert.False(t, allowed, "跨越窗口边界的突发请求应该被拒绝")
+	assert.Equal(t, 90*time.Millisecond, wait, "上一个窗口权重降到9/10时才能放行")
+
+	// 按返回的等待时间等待后请求应该被允许
+	clock.Advance(wait)
+	allowed, _ = limiter.Allow(ctx, key)
+	assert.True(t, allowed, "等待后请求应该被允许")
+}
+
+// 测试按成本扣减配额，内存存储和Redis存储行为应该一致
+func TestAllowN(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	for _, tt := range allAlgorithms {
+		for _, storeName := range []string{"Memory", "Redis"} {
+			t.Run(tt.name+"/"+storeName, func(t *testing.T) {
+				var opts []algorithms.Option
+				if storeName == "Redis" {
+					opts = append(opts, algorithms.WithStore(redisstore.NewFromClient(client, "allow_n:"+tt.name+":")))
+				}
+				limiter := tt.algorithm(algorithms.Config{
+					WindowSize: time.Minute,
+					Limit:      10,
+				}, opts...)
+				defer limiter.Close()
+
+				ctx := context.Background()
+				key := "test-key"
+
+				allowed, wait := limiter.AllowN(ctx, key, 6)
+				assert.True(t, allowed, "成本在剩余配额内的请求应该被允许")
+				assert.Zero(t, wait, "不应该有等待时间")
+
+				allowed, wait = limiter.AllowN(ctx, key, 5)
+				assert.False(t, allowed, "成本超过剩余配额的请求应该被拒绝")
+				assert.NotZero(t, wait, "应该有等待时间")
+
+				allowed, _ = limiter.AllowN(ctx, key, 4)
+				assert.True(t, allowed, "被拒绝的请求不应该消耗配额")
+
+				allowed, _ = limiter.Allow(ctx, key)
+				assert.False(t, allowed, "配额已用完")
+
+				allowed, wait = limiter.AllowN(ctx, "other-key", 11)
+				assert.False(t, allowed, "成本超过容量的请求永远不会被允许")
+				assert.Zero(t, wait, "成本超过容量时不返回等待时间")
+			})
+		}
+	}
+}
+
+// 测试请求成本的计算
+func TestRuleCost(t *testing.T) {
+	newRequest := func(header string, body string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/api/export", strings.NewReader(body))
+		if header != "" {
+			r.Header.Set("X-Cost", header)
+		}
+		return r
+	}
+	// 分块传输的请求体大小未知
+	chunked := newRequest("", "0123456789")
+	chunked.ContentLength = -1
+
+	tests := []struct {
+		name     string
+		cost     limiter.Cost
+		request  *http.Request
+		expected int64
+	}{
+		{"Default", limiter.Cost{}, newRequest("", ""), 1},
+		{"Fixed", limiter.Cost{Source: limiter.FixedCost, Value: 5}, newRequest("", ""), 5},
+		{"Header", limiter.Cost{Source: limiter.HeaderCost, Header: "X-Cost"}, newRequest("7", ""), 7},
+		{"HeaderMissing", limiter.Cost{Source: limiter.HeaderCost, Header: "X-Cost", Value: 2}, newRequest("", ""), 2},
+		{"HeaderInvalid", limiter.Cost{Source: limiter.HeaderCost, Header: "X-Cost"}, newRequest("-3", ""), 1},
+		{"ContentLength", limiter.Cost{Source: limiter.ContentLengthCost, Unit: 4}, newRequest("", "0123456789"), 3},
+		{"ContentLengthEmpty", limiter.Cost{Source: limiter.ContentLengthCost, Value: 2}, newRequest("", ""), 2},
+		{"ContentLengthUnknown", limiter.Cost{Source: limiter.ContentLengthCost, Unit: 4, Max: 100}, chunked, 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cost, err := tt.cost.Calculate(tt.request)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, cost)
+		})
+	}
+
+	// 没有配置Max时拒绝请求体大小未知的请求，不能按默认成本放行
+	_, err := limiter.Cost{Source: limiter.ContentLengthCost, Value: 1}.Calculate(chunked)
+	assert.ErrorIs(t, err, limiter.ErrUnknownLength)
+
+	// 成本超过容量的请求永远不会被放行，规则无效
+	rm := limiter.NewRuleManager()
+	defer rm.Close()
+
+	add := func(config algorithms.Config, cost limiter.Cost) error {
+		return rm.AddRule("/api/export", limiter.Rule{Algorithm: limiter.TokenBucket, Config: config, Cost: cost})
+	}
+	config := algorithms.Config{WindowSize: time.Minute, Limit: 100}
+	assert.Error(t, add(config, limiter.Cost{Source: limiter.ContentLengthCost, Max: 101}), "Max不能超过容量")
+	assert.Error(t, add(config, limiter.Cost{Value: 101}), "Value不能超过容量")
+	assert.Error(t, add(algorithms.Config{WindowSize: time.Minute, Limit: 100, Burst: 10}, limiter.Cost{Value: 20}), "容量按突发大小计算")
+	assert.NoError(t, add(config, limiter.Cost{Source: limiter.ContentLengthCost, Max: 100}))
+}
+
+// 测试按响应状态码类别归还配额的规则
+func TestRuleRefundOn(t *testing.T) {
+	rule := limiter.Rule{RefundOn: []string{"5xx", "4XX"}}
+	assert.True(t, rule.Refunds(http.StatusBadGateway), "5xx应该归还")
+	assert.True(t, rule.Refunds(http.StatusNotFound), "类别不区分大小写")
+	assert.False(t, rule.Refunds(http.StatusOK), "2xx不应该归还")
+	assert.False(t, limiter.Rule{}.Refunds(http.StatusInternalServerError), "未配置时不归还")
+
+	rm := limiter.NewRuleManager()
+	defer rm.Close()
+
+	config := algorithms.Config{WindowSize: time.Second, Limit: 1}
+	for _, class := range []string{"500", "6xx", "5x", "xxx"} {
+		assert.Error(t, rm.AddRule("/api/bad", limiter.Rule{
+			Algorithm: limiter.FixedWindow,
+			Config:    config,
+			RefundOn:  []string{class},
+		}), "格式错误的状态码类别应该报错: %s", class)
+	}
+	assert.Error(t, rm.AddRule("/api/bad", limiter.Rule{
+		Algorithm: limiter.Concurrency,
+		Config:    config,
+		RefundOn:  []string{"5xx"},
+	}), "并发限流不支持归还")
+	assert.NoError(t, rm.AddRule("/api/ok", limiter.Rule{
+		Algorithm: limiter.FixedWindow,
+		Config:    config,
+		RefundOn:  []string{"5xx"},
+	}))
+}
+
+// 测试请求匹配到的规则在处理期间被替换时，归还仍然作用于判断时的限流器
+func TestRuleMatch(t *testing.T) {
+	rm := limiter.NewRuleManager()
+	defer rm.Close()
+
+	rule := limiter.Rule{
+		Algorithm: limiter.FixedWindow,
+		Config:    algorithms.Config{WindowSize: time.Minute, Limit: 1},
+	}
+	assert.NoError(t, rm.AddRule("/api/test", rule))
+
+	ctx := context.Background()
+	req := httptest.NewRequest(http.MethodGet, "/api/test", nil)
+	route := limiter.Route(req.Method, req.Host, req.URL.Path)
+	match, ok := rm.Match(route, req, "client")
+	if !assert.True(t, ok) {
+		return
+	}
+	decision := match.Admit(ctx, 1, 0)
+	assert.True(t, decision.Allowed)
+	if assert.NotNil(t, decision.Status, "应该返回判断后的配额状态") {
+		assert.Equal(t, int64(0), decision.Status.Remaining)
+	}
+
+	// 规则被替换后新规则独立计数，替换前的请求归还的配额不能进入新规则
+	assert.NoError(t, rm.AddRule("/api/test", rule))
+	allowed, _ := rm.Allow(ctx, "/api/test", "client")
+	assert.True(t, allowed)
+	match.RefundN(ctx, 1)
+	allowed, _ = rm.Allow(ctx, "/api/test", "client")
+	assert.False(t, allowed, "替换前的请求归还的配额不应该进入新规则")
+
+	// 省略配额响应头的规则不查询状态
+	rule.OmitHeaders = true
+	assert.NoError(t, rm.AddRule("/api/test", rule))
+	match, _ = rm.Match(route, req, "client")
+	decision = match.Admit(ctx, 1, 0)
+	assert.True(t, decision.Allowed)
+	assert.Nil(t, decision.Status)
+
+	_, ok = rm.Match(limiter.Route(http.MethodGet, "", "/other"), req, "client")
+	assert.False(t, ok, "没有规则的路径不应该匹配")
+}
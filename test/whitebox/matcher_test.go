@@ -0,0 +1,212 @@
+package whitebox
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wureny/FluxGo/internal/algorithms"
+	"github.com/wureny/FluxGo/internal/limiter"
+)
+
+// 测试参数、前缀和正则规则路径的匹配优先级
+func TestRulePatterns(t *testing.T) {
+	rm := limiter.NewRuleManager()
+	defer rm.Close()
+
+	// 每个规则的上限不同，通过Status的Limit判断匹配到的规则
+	limits := map[string]int64{
+		"/*":                        1,
+		"/api/v1/*":                 2,
+		"/api/v1/users/:id":         3,
+		"/api/v1/users/me":          4,
+		"/api/v1/users/:id/*":       5,
+		"/api/v1/orders":            6,
+		"~^/api/v[0-9]+/reports":    7,
+		"~^/api/v2/reports/[0-9]+$": 8,
+	}
+	for path, limit := range limits {
+		assert.NoError(t, rm.AddRule(path, limiter.Rule{
+			Algorithm: limiter.FixedWindow,
+			Config:    algorithms.Config{WindowSize: time.Minute, Limit: limit},
+		}), path)
+	}
+
+	ctx := context.Background()
+	cases := []struct {
+		path  string
+		limit int64
+	}{
+		{"/api/v1/users/me", 4},          // 静态段优先于参数
+		{"/api/v1/users/42", 3},          // 参数匹配任意一段
+		{"/api/v1/users/42/posts/7", 5},  // 参数之后的前缀
+		{"/api/v1/users", 2},             // 没有更具体的规则时按前缀
+		{"/api/v1", 2},                   // 前缀匹配自身
+		{"/api/v1/orders", 6},            // 静态路径
+		{"/api/v1/orders/1", 2},          // 静态路径不匹配子路径
+		{"/api/v2/reports/1", 1},         // 路径树中的规则优先于正则
+		{"/health", 1},                   // 根前缀匹配所有路径
+		{"/api/v1/users/:id", 3},         // 规则路径本身可以直接查询
+		{"/api/v1/users/me/settings", 5}, // 静态分支没有匹配时回溯到参数分支
+		{"/api/v1/users/", 2},            // 参数不匹配空的段
+		{"/api/v1/users//posts", 2},      // 中间的空段同样不匹配参数
+	}
+	for _, c := range cases {
+		status, exists := rm.Status(ctx, c.path, "client")
+		if assert.True(t, exists, c.path) {
+			assert.Equal(t, c.limit, status.Limit, "%s应该匹配上限为%d的规则", c.path, c.limit)
+		}
+	}
+
+	// 删除根前缀后，路径树中没有匹配的规则时按字典序尝试正则
+	rm.RemoveRule("/*")
+	for path, limit := range map[string]int64{
+		"/api/v2/reports/1":     8,
+		"/api/v3/reports/daily": 7,
+	} {
+		status, exists := rm.Status(ctx, path, "client")
+		if assert.True(t, exists, path) {
+			assert.Equal(t, limit, status.Limit, path)
+		}
+	}
+	_, exists := rm.Status(ctx, "/health", "client")
+	assert.False(t, exists, "删除规则后不再匹配")
+
+	// 删除规则后路径树中不再保留空的节点，重新添加后照常匹配
+	rm.RemoveRule("/api/v1/users/:id/*")
+	rm.RemoveRule("/api/v1/users/:id")
+	status, exists := rm.Status(ctx, "/api/v1/users/42/posts/7", "client")
+	if assert.True(t, exists) {
+		assert.Equal(t, int64(2), status.Limit, "删除参数规则后按前缀匹配")
+	}
+	assert.NoError(t, rm.AddRule("/api/v1/users/:id", limiter.Rule{
+		Algorithm: limiter.FixedWindow,
+		Config:    algorithms.Config{WindowSize: time.Minute, Limit: 3},
+	}))
+
+	// 规则路径的校验
+	rule := limiter.Rule{Algorithm: limiter.FixedWindow, Config: algorithms.Config{WindowSize: time.Minute, Limit: 1}}
+	assert.Error(t, rm.AddRule("/api/*/users", rule), "前缀只能是最后一段")
+	assert.Error(t, rm.AddRule("/users/:", rule), "参数必须有名称")
+	assert.Error(t, rm.AddRule("~^/api/(", rule), "正则必须合法")
+	assert.Error(t, rm.AddRule("/api/v1/users/:uid", rule), "参数名不同的相同规则路径冲突")
+	assert.NoError(t, rm.AddRule("/api/v1/users/:id", rule), "可以替换相同的规则路径")
+	assert.Error(t, rm.AddRule("/api/v1/orders", limiter.Rule{
+		Algorithm: limiter.FixedWindow,
+		Config:    algorithms.Config{WindowSize: time.Minute, Limit: 1},
+		KeyScope:  "host",
+	}), "不支持的key范围")
+}
+
+// 测试规则匹配多个具体路径时key的范围
+func TestRuleKeyScope(t *testing.T) {
+	rm := limiter.NewRuleManager()
+	defer rm.Close()
+
+	config := algorithms.Config{WindowSize: time.Minute, Limit: 2}
+	assert.NoError(t, rm.AddRule("/shared/:id", limiter.Rule{Algorithm: limiter.FixedWindow, Config: config}))
+	assert.NoError(t, rm.AddRule("/separate/:id", limiter.Rule{Algorithm: limiter.FixedWindow, Config: config, KeyScope: limiter.PathScope}))
+
+	ctx := context.Background()
+	// 默认所有路径共用配额
+	for i, path := range []string{"/shared/1", "/shared/2", "/shared/3"} {
+		allowed, _ := rm.Allow(ctx, path, "client")
+		assert.Equal(t, i < 2, allowed, "所有路径应该共用配额")
+	}
+
+	// 按具体路径区分时各自计数
+	for _, path := range []string{"/separate/1", "/separate/2"} {
+		for i := 0; i < 3; i++ {
+			allowed, _ := rm.Allow(ctx, path, "client")
+			assert.Equal(t, i < 2, allowed, "%s应该单独计数", path)
+		}
+	}
+	status, _ := rm.Status(ctx, "/separate/3", "client")
+	assert.Equal(t, int64(2), status.Remaining, "没有请求过的路径配额不受影响")
+
+	// 归还和查询同样按具体路径
+	rm.RefundN(ctx, "/separate/1", "client", 1)
+	status, _ = rm.Status(ctx, "/separate/1", "client")
+	assert.Equal(t, int64(1), status.Remaining)
+	status, _ = rm.Status(ctx, "/separate/2", "client")
+	assert.Equal(t, int64(0), status.Remaining)
+
+	// 按具体路径区分的规则不在key的汇总状态中
+	statuses := rm.KeyStatus(ctx, "client")
+	assert.Contains(t, statuses, "/shared/:id")
+	assert.NotContains(t, statuses, "/separate/:id")
+}
+
+// 测试同一路径按请求方法和主机配置的规则
+func TestRuleMethodsAndHosts(t *testing.T) {
+	rm := limiter.NewRuleManager()
+	defer rm.Close()
+
+	add := func(path string, methods []string, host string, limit int64) error {
+		return rm.AddRule(path, limiter.Rule{
+			Algorithm: limiter.FixedWindow,
+			Config:    algorithms.Config{WindowSize: time.Minute, Limit: limit},
+			Methods:   methods,
+			Host:      host,
+		})
+	}
+	assert.NoError(t, add("/api/orders", nil, "", 1))
+	assert.NoError(t, add("/api/orders", []string{"post"}, "", 2))
+	assert.NoError(t, add("/api/orders", nil, "shop.example.com", 3))
+	assert.NoError(t, add("/api/orders", []string{"POST"}, "Shop.Example.com", 4))
+	assert.NoError(t, add("/api/orders", nil, "*.example.com", 5))
+	assert.NoError(t, add("/api/*", []string{"DELETE"}, "", 6))
+
+	ctx := context.Background()
+	limitOf := func(route string) int64 {
+		status, exists := rm.Status(ctx, route, "client")
+		if !exists {
+			return 0
+		}
+		return status.Limit
+	}
+	cases := []struct {
+		method, host, path string
+		limit              int64
+	}{
+		{"GET", "other.com", "/api/orders", 1},             // 不限制方法和主机的规则
+		{"POST", "other.com", "/api/orders", 2},            // 限制方法的规则优先
+		{"GET", "shop.example.com:8080", "/api/orders", 3}, // 忽略端口
+		{"POST", "SHOP.example.com", "/api/orders", 4},     // 主机和方法都匹配的规则最具体
+		{"GET", "a.example.com", "/api/orders", 5},         // 通配主机
+		{"POST", "a.example.com", "/api/orders", 5},        // 主机优先于方法
+		{"DELETE", "other.com", "/api/orders", 1},          // 更具体的路径优先
+		{"DELETE", "other.com", "/api/items", 6},
+		{"GET", "other.com", "/api/items", 0}, // 路径匹配但方法不匹配
+		{"", "", "/api/orders", 1},            // 不指定方法和主机时只匹配不限制的规则
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.limit, limitOf(limiter.Route(c.method, c.host, c.path)), "%s %s %s", c.method, c.host, c.path)
+	}
+
+	// 按规则标识查询，方法和主机会被规范化
+	id := limiter.RuleID("/api/orders", []string{"post"}, "")
+	assert.Equal(t, "POST * /api/orders", id)
+	rule, exists := rm.GetRule(id)
+	assert.True(t, exists)
+	assert.Equal(t, []string{"POST"}, rule.Methods)
+	assert.Equal(t, int64(2), rule.Config.Limit)
+	assert.Equal(t, "/api/orders", limiter.RuleID("/api/orders", nil, ""), "不限制方法和主机时标识就是路径")
+	assert.Contains(t, rm.KeyStatus(ctx, "client"), "POST shop.example.com /api/orders")
+
+	// 会匹配同一请求且具体程度相同的规则冲突
+	assert.Error(t, add("/api/orders", []string{"PUT", "POST"}, "", 7), "方法重叠的规则冲突")
+	assert.NoError(t, add("/api/orders", []string{"PUT"}, "", 7))
+	assert.NoError(t, add("/api/orders", []string{"POST"}, "", 8), "可以替换相同标识的规则")
+	assert.Equal(t, int64(8), limitOf(limiter.Route("POST", "other.com", "/api/orders")))
+	assert.Error(t, add("/api/orders", []string{""}, "", 1), "方法不能为空")
+	assert.Error(t, add("/api/orders", nil, "bad host", 1), "主机不合法")
+
+	// 删除规则后按剩余的规则匹配
+	rm.RemoveRule(id)
+	assert.Equal(t, int64(1), limitOf(limiter.Route("POST", "other.com", "/api/orders")))
+	rm.RemoveRule("/api/orders")
+	assert.Equal(t, int64(0), limitOf(limiter.Route("GET", "other.com", "/api/orders")), "路径下只剩限制主机的规则")
+	assert.Equal(t, int64(3), limitOf(limiter.Route("GET", "shop.example.com", "/api/orders")), "同一路径的其他规则不受影响")
+}
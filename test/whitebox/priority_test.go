@@ -0,0 +1,186 @@
+package whitebox
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/wureny/FluxGo/internal/algorithms"
+	"github.com/wureny/FluxGo/internal/algorithms/composite"
+	"github.com/wureny/FluxGo/internal/algorithms/concurrency"
+	"github.com/wureny/FluxGo/internal/algorithms/fixedwindow"
+	"github.com/wureny/FluxGo/internal/algorithms/quota"
+	"github.com/wureny/FluxGo/internal/limiter"
+	"github.com/wureny/FluxGo/internal/store/redisstore"
+)
+
+// 测试各算法为高优先级请求保留容量
+func TestPrioritizer(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	tests := append(allAlgorithms[:len(allAlgorithms):len(allAlgorithms)], []struct {
+		name      string
+		algorithm func(algorithms.Config, ...algorithms.Option) algorithms.RateLimiter
+	}{
+		{
+			name: "Concurrency",
+			algorithm: func(c algorithms.Config, opts ...algorithms.Option) algorithms.RateLimiter {
+				return concurrency.NewLimiter(c, opts...)
+			},
+		},
+		{
+			name: "Quota",
+			algorithm: func(c algorithms.Config, opts ...algorithms.Option) algorithms.RateLimiter {
+				c.Period = algorithms.Day
+				return quota.NewLimiter(c, opts...)
+			},
+		},
+	}...)
+
+	config := algorithms.Config{
+		WindowSize: time.Second,
+		Limit:      10,
+	}
+
+	for _, tt := range tests {
+		for _, storeName := range []string{"Memory", "Redis"} {
+			t.Run(tt.name+"/"+storeName, func(t *testing.T) {
+				clock := algorithms.NewManualClock(epoch)
+				opts := []algorithms.Option{algorithms.WithClock(clock)}
+				if storeName == "Redis" {
+					opts = append(opts, algorithms.WithStore(redisstore.NewFromClient(client, "priority:"+tt.name+":")))
+				}
+				l := tt.algorithm(config, opts...)
+				defer l.Close()
+
+				p, ok := l.(algorithms.Prioritizer)
+				if !assert.True(t, ok, "限流器应该支持保留容量") {
+					return
+				}
+
+				ctx := context.Background()
+				key := "test-key"
+
+				// 低优先级保留30%的容量，最多使用7个
+				for i := 0; i < 7; i++ {
+					allowed, _ := p.AllowNReserved(ctx, key, 1, 0.3)
+					assert.True(t, allowed, "保留容量以外的配额应该可以使用")
+				}
+				allowed, wait := p.AllowNReserved(ctx, key, 1, 0.3)
+				assert.False(t, allowed, "低优先级的请求不能使用保留的容量")
+				assert.Greater(t, wait, time.Duration(0))
+
+				// 高优先级可以使用保留的容量
+				for i := 0; i < 3; i++ {
+					allowed, _ = l.Allow(ctx, key)
+					assert.True(t, allowed, "高优先级的请求应该可以使用保留的容量")
+				}
+				allowed, _ = l.Allow(ctx, key)
+				assert.False(t, allowed)
+
+				// 超过低优先级可用容量的请求永远不会被允许
+				allowed, wait = p.AllowNReserved(ctx, "other-key", 8, 0.3)
+				assert.False(t, allowed)
+				assert.Equal(t, time.Duration(0), wait)
+			})
+		}
+	}
+
+	// 组合限流的每个限流器都保留容量
+	clock := algorithms.NewManualClock(epoch)
+	perSecond := fixedwindow.NewLimiter(algorithms.Config{WindowSize: time.Second, Limit: 10}, algorithms.WithClock(clock))
+	perMinute := fixedwindow.NewLimiter(algorithms.Config{WindowSize: time.Minute, Limit: 12}, algorithms.WithClock(clock))
+	l := composite.NewLimiter(perSecond, perMinute)
+	defer l.Close()
+
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		allowed, _ := l.AllowNReserved(ctx, "a", 2, 0.5)
+		assert.Equal(t, i < 2, allowed, "每秒的限流器只剩余一半容量可用")
+	}
+	clock.Advance(time.Second)
+	for i := 0; i < 2; i++ {
+		allowed, _ := l.AllowNReserved(ctx, "a", 2, 0.5)
+		assert.Equal(t, i < 1, allowed, "每分钟的限流器只剩余一半容量可用")
+	}
+	allowed, _ := l.AllowN(ctx, "a", 2)
+	assert.True(t, allowed, "高优先级的请求不受保留容量的限制")
+}
+
+// 测试请求的优先级分类和规则校验
+func TestPriority(t *testing.T) {
+	headerPriority := limiter.Priority{
+		Source: limiter.HeaderPriority,
+		Header: "X-Client-Tier",
+		Classes: []limiter.PriorityClass{
+			{Name: "interactive", Values: []string{"interactive"}},
+			{Name: "bulk", Values: []string{"batch", "bulk"}, Reserve: 0.3},
+		},
+		DefaultReserve: 0.1,
+	}
+
+	req := httptest.NewRequest("GET", "/api/orders", nil)
+	req.Header.Set("X-Client-Tier", "batch")
+	class, ok := headerPriority.Classify(req)
+	assert.True(t, ok)
+	assert.Equal(t, "bulk", class.Name)
+	assert.Equal(t, 0.3, headerPriority.Reserve(req))
+
+	req.Header.Set("X-Client-Tier", "interactive")
+	assert.Equal(t, 0.0, headerPriority.Reserve(req), "最高优先级可以使用全部容量")
+	req.Header.Del("X-Client-Tier")
+	assert.Equal(t, 0.1, headerPriority.Reserve(req), "未匹配的请求使用默认比例")
+
+	pathPriority := limiter.Priority{
+		Source: limiter.PathPriority,
+		Classes: []limiter.PriorityClass{
+			{Name: "export", Values: []string{"/api/orders/export"}, Reserve: 0.5},
+		},
+	}
+	assert.Equal(t, 0.5, pathPriority.Reserve(httptest.NewRequest("GET", "/api/orders/export/csv", nil)))
+	assert.Equal(t, 0.0, pathPriority.Reserve(httptest.NewRequest("GET", "/api/orders", nil)))
+
+	rm := limiter.NewRuleManager()
+	defer rm.Close()
+
+	config := algorithms.Config{WindowSize: time.Second, Limit: 10}
+	assert.Error(t, rm.AddRule("/api/bad", limiter.Rule{
+		Algorithm: limiter.FixedWindow,
+		Config:    config,
+		Priority:  limiter.Priority{Source: limiter.HeaderPriority},
+	}), "来源为请求头时需要指定请求头名称")
+	assert.Error(t, rm.AddRule("/api/bad", limiter.Rule{
+		Algorithm: limiter.FixedWindow,
+		Config:    config,
+		Priority:  limiter.Priority{Source: limiter.PathPriority, DefaultReserve: 1.5},
+	}), "保留比例不能超过1")
+	assert.Error(t, rm.AddRule("/api/bad", limiter.Rule{
+		Algorithm: limiter.LeakyBucket,
+		Config:    config,
+		MaxDelay:  time.Second,
+		Priority:  headerPriority,
+	}), "整形模式不支持优先级")
+	assert.NoError(t, rm.AddRule("/api/orders", limiter.Rule{
+		Algorithm: limiter.FixedWindow,
+		Config:    config,
+		Priority:  headerPriority,
+	}))
+
+	ctx := context.Background()
+	req = httptest.NewRequest("GET", "/api/orders", nil)
+	match, ok := rm.Match(limiter.Route(req.Method, req.Host, req.URL.Path), req, "a")
+	if !assert.True(t, ok) {
+		return
+	}
+	for i := 0; i < 7; i++ {
+		assert.True(t, match.Admit(ctx, 1, 0.3).Allowed)
+	}
+	assert.False(t, match.Admit(ctx, 1, 0.3).Allowed)
+	assert.True(t, match.Admit(ctx, 1, 0).Allowed)
+}
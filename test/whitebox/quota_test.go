@@ -0,0 +1,229 @@
+package whitebox
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/wureny/FluxGo/internal/algorithms"
+	"github.com/wureny/FluxGo/internal/algorithms/quota"
+	"github.com/wureny/FluxGo/internal/limiter"
+	"github.com/wureny/FluxGo/internal/store/redisstore"
+)
+
+// 测试各日历周期在不同时区下的边界
+func TestPeriodBounds(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	assert.NoError(t, err)
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	assert.NoError(t, err)
+	newYork, err := time.LoadLocation("America/New_York")
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		period   algorithms.Period
+		t        time.Time
+		location *time.Location
+		start    time.Time
+		end      time.Time
+	}{
+		{
+			// 小时按UTC对齐，半小时时差的时区不在本地整点开始
+			name:     "Hour",
+			period:   algorithms.Hour,
+			t:        time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC),
+			location: kolkata,
+			start:    time.Date(2023, 11, 14, 22, 0, 0, 0, time.UTC),
+			end:      time.Date(2023, 11, 14, 23, 0, 0, 0, time.UTC),
+		},
+		{
+			// 夏令时结束时本地1点重复一次，两次各自是一个一小时的周期
+			name:     "HourDaylightSaving",
+			period:   algorithms.Hour,
+			t:        time.Date(2023, 11, 5, 5, 30, 0, 0, time.UTC),
+			location: newYork,
+			start:    time.Date(2023, 11, 5, 5, 0, 0, 0, time.UTC),
+			end:      time.Date(2023, 11, 5, 6, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "HourDaylightSavingRepeated",
+			period:   algorithms.Hour,
+			t:        time.Date(2023, 11, 5, 6, 30, 0, 0, time.UTC),
+			location: newYork,
+			start:    time.Date(2023, 11, 5, 6, 0, 0, 0, time.UTC),
+			end:      time.Date(2023, 11, 5, 7, 0, 0, 0, time.UTC),
+		},
+		{
+			// UTC的14日22点在上海已经是15日
+			name:     "Day",
+			period:   algorithms.Day,
+			t:        time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC),
+			location: shanghai,
+			start:    time.Date(2023, 11, 14, 16, 0, 0, 0, time.UTC),
+			end:      time.Date(2023, 11, 15, 16, 0, 0, 0, time.UTC),
+		},
+		{
+			// 周日属于周一开始的那一周
+			name:     "Week",
+			period:   algorithms.Week,
+			t:        time.Date(2023, 11, 19, 23, 0, 0, 0, time.UTC),
+			location: time.UTC,
+			start:    time.Date(2023, 11, 13, 0, 0, 0, 0, time.UTC),
+			end:      time.Date(2023, 11, 20, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "Month",
+			period:   algorithms.Month,
+			t:        time.Date(2024, 1, 31, 10, 0, 0, 0, time.UTC),
+			location: time.UTC,
+			start:    time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			end:      time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end, err := tt.period.Bounds(tt.t, tt.location)
+			assert.NoError(t, err)
+			assert.True(t, tt.start.Equal(start), "周期开始时间不符合预期: %v", start)
+			assert.True(t, tt.end.Equal(end), "周期结束时间不符合预期: %v", end)
+		})
+	}
+
+	_, _, err = algorithms.Period("year").Bounds(epoch, time.UTC)
+	assert.Error(t, err, "不支持的周期应该报错")
+}
+
+// 测试日历配额的计数、重置和归还
+func TestQuotaLimiter(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	config := algorithms.Config{
+		Limit:    3,
+		Period:   algorithms.Day,
+		Location: "Asia/Shanghai",
+	}
+	// epoch为上海时间15日6点13分20秒，配额在16日0点重置
+	reset := time.Date(2023, 11, 16, 0, 0, 0, 0, time.FixedZone("CST", 8*3600))
+
+	for _, storeName := range []string{"Memory", "Redis"} {
+		t.Run(storeName, func(t *testing.T) {
+			clock := algorithms.NewManualClock(epoch)
+			opts := []algorithms.Option{algorithms.WithClock(clock)}
+			if storeName == "Redis" {
+				opts = append(opts, algorithms.WithStore(redisstore.NewFromClient(client, "quota:")))
+			}
+			l := quota.NewLimiter(config, opts...)
+			defer l.Close()
+
+			ctx := context.Background()
+
+			for i := int64(1); i <= 3; i++ {
+				allowed, status := l.ConsumeN(ctx, "a", 1)
+				assert.True(t, allowed, "配额内的请求应该被允许")
+				assert.Equal(t, i, status.Used)
+				assert.Equal(t, 3-i, status.Remaining)
+				assert.True(t, reset.Equal(status.Reset), "重置时间不符合预期: %v", status.Reset)
+			}
+
+			// 配额用完后拒绝到重置为止
+			allowed, status := l.ConsumeN(ctx, "a", 1)
+			assert.False(t, allowed, "配额用完后应该被拒绝")
+			assert.Equal(t, int64(3), status.Used, "被拒绝的请求不应该计数")
+			assert.Equal(t, int64(0), status.Remaining)
+			allowed, wait := l.Allow(ctx, "a")
+			assert.False(t, allowed)
+			assert.Equal(t, reset.Sub(epoch), wait, "应该等到配额重置")
+			assert.Equal(t, algorithms.Status{Limit: 3, Remaining: 0, Reset: status.Reset, Wait: wait}, l.Status(ctx, "a"))
+
+			// 查询不消耗配额，不同key独立计数
+			assert.Equal(t, int64(3), l.Usage(ctx, "a").Used)
+			assert.Equal(t, int64(0), l.Usage(ctx, "b").Used)
+			allowed, _ = l.ConsumeN(ctx, "b", 4)
+			assert.False(t, allowed, "超过配额的请求永远不会被允许")
+
+			// 归还后可以再次使用
+			l.RefundN(ctx, "a", 1)
+			assert.Equal(t, int64(2), l.Usage(ctx, "a").Used)
+			allowed, _ = l.Allow(ctx, "a")
+			assert.True(t, allowed, "归还后的配额应该可以使用")
+
+			// 到达周期边界时重置
+			clock.Set(reset.Add(-time.Microsecond))
+			allowed, _ = l.Allow(ctx, "a")
+			assert.False(t, allowed, "周期结束前配额不会重置")
+			clock.Set(reset)
+			allowed, status = l.ConsumeN(ctx, "a", 1)
+			assert.True(t, allowed, "新周期的请求应该被允许")
+			assert.Equal(t, int64(1), status.Used)
+			assert.True(t, reset.AddDate(0, 0, 1).Equal(status.Reset), "重置时间应该是下一个0点")
+		})
+	}
+
+	// Redis中的配额在网关重启后保留
+	clock := algorithms.NewManualClock(epoch)
+	l := quota.NewLimiter(config, algorithms.WithClock(clock), algorithms.WithStore(redisstore.NewFromClient(client, "restart:")))
+	allowed, _ := l.ConsumeN(context.Background(), "a", 2)
+	assert.True(t, allowed)
+	l.Close()
+	l = quota.NewLimiter(config, algorithms.WithClock(clock), algorithms.WithStore(redisstore.NewFromClient(client, "restart:")))
+	defer l.Close()
+	assert.Equal(t, int64(2), l.Usage(context.Background(), "a").Used, "重启后应该保留已使用的配额")
+
+	// 调小配额后已用量超过配额时剩余配额为0
+	shrunk := quota.NewLimiter(algorithms.Config{Limit: 1, Period: algorithms.Day, Location: "Asia/Shanghai"},
+		algorithms.WithClock(clock), algorithms.WithStore(redisstore.NewFromClient(client, "restart:")))
+	defer shrunk.Close()
+	assert.Equal(t, int64(0), shrunk.Usage(context.Background(), "a").Remaining, "剩余配额不能为负数")
+	assert.Equal(t, int64(0), shrunk.Status(context.Background(), "a").Remaining)
+}
+
+// 测试日历配额规则的配置校验
+func TestRuleQuota(t *testing.T) {
+	rm := limiter.NewRuleManager()
+	defer rm.Close()
+
+	assert.Error(t, rm.AddRule("/api/quota", limiter.Rule{
+		Algorithm: limiter.Quota,
+		Config:    algorithms.Config{Limit: 10, Period: "year"},
+	}), "不支持的周期应该报错")
+	assert.Error(t, rm.AddRule("/api/quota", limiter.Rule{
+		Algorithm: limiter.Quota,
+		Config:    algorithms.Config{Limit: 10, Period: algorithms.Month, Location: "Mars/Olympus"},
+	}), "未知时区应该报错")
+	assert.NoError(t, rm.AddRule("/api/quota", limiter.Rule{
+		Algorithm: limiter.Quota,
+		Config:    algorithms.Config{Limit: 10, Period: algorithms.Month},
+	}), "日历配额不需要窗口大小")
+
+	ctx := context.Background()
+	req := httptest.NewRequest(http.MethodGet, "/api/quota", nil)
+	match, ok := rm.Match(limiter.Route(req.Method, req.Host, req.URL.Path), req, "a")
+	if !assert.True(t, ok) {
+		return
+	}
+	decision := match.Admit(ctx, 4, 0)
+	assert.True(t, decision.Allowed)
+	if assert.NotNil(t, decision.Status) {
+		assert.Equal(t, int64(6), decision.Status.Remaining)
+	}
+	usage, ok := rm.Usage(ctx, "/api/quota", "a")
+	assert.True(t, ok)
+	assert.Equal(t, int64(4), usage.Used)
+
+	// 其他算法的规则没有配额使用情况
+	assert.NoError(t, rm.AddRule("/api/other", limiter.Rule{
+		Algorithm: limiter.FixedWindow,
+		Config:    algorithms.Config{WindowSize: time.Second, Limit: 10},
+	}))
+	_, ok = rm.Usage(ctx, "/api/other", "a")
+	assert.False(t, ok)
+}
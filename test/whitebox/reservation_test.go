@@ -0,0 +1,171 @@
+package whitebox
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/wureny/FluxGo/internal/algorithms"
+	"github.com/wureny/FluxGo/internal/algorithms/fixedwindow"
+	"github.com/wureny/FluxGo/internal/algorithms/gcra"
+	"github.com/wureny/FluxGo/internal/algorithms/leakybucket"
+	"github.com/wureny/FluxGo/internal/algorithms/tokenbucket"
+	"github.com/wureny/FluxGo/internal/store/redisstore"
+)
+
+// 测试预留配额及取消后归还配额
+func TestReserve(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	tests := []struct {
+		name     string
+		reserver func(algorithms.Config, ...algorithms.Option) algorithms.Reserver
+	}{
+		{
+			name: "TokenBucket",
+			reserver: func(c algorithms.Config, opts ...algorithms.Option) algorithms.Reserver {
+				return tokenbucket.NewLimiter(c, opts...)
+			},
+		},
+		{
+			name: "LeakyBucket",
+			reserver: func(c algorithms.Config, opts ...algorithms.Option) algorithms.Reserver {
+				return leakybucket.NewLimiter(c, opts...)
+			},
+		},
+		{
+			name: "GCRA",
+			reserver: func(c algorithms.Config, opts ...algorithms.Option) algorithms.Reserver {
+				return gcra.NewLimiter(c, opts...)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		for _, storeName := range []string{"Memory", "Redis"} {
+			t.Run(tt.name+"/"+storeName, func(t *testing.T) {
+				clock := algorithms.NewManualClock(epoch)
+				opts := []algorithms.Option{algorithms.WithClock(clock)}
+				if storeName == "Redis" {
+					opts = append(opts, algorithms.WithStore(redisstore.NewFromClient(client, "reserve:"+tt.name+":")))
+				}
+				reserver := tt.reserver(algorithms.Config{
+					WindowSize: time.Second,
+					Limit:      10,
+				}, opts...)
+
+				ctx := context.Background()
+				key := "test-key"
+
+				// 容量内的预留不需要等待
+				r := reserver.ReserveN(ctx, key, 10)
+				assert.True(t, r.OK(), "预留应该成功")
+				assert.Zero(t, r.Delay(), "不应该有等待时间")
+
+				// 超出容量后预留仍然成功，但需要等待
+				r = reserver.Reserve(ctx, key)
+				assert.True(t, r.OK(), "预留应该成功")
+				assert.Equal(t, 100*time.Millisecond, r.Delay(), "应该等待一个发射间隔")
+
+				r2 := reserver.Reserve(ctx, key)
+				assert.Equal(t, 200*time.Millisecond, r2.Delay(), "后续预留排在之后")
+
+				// 时间推进后等待时间相应减少
+				clock.Advance(50 * time.Millisecond)
+				assert.Equal(t, 50*time.Millisecond, r.Delay(), "等待时间应该随时间减少")
+				assert.Equal(t, 150*time.Millisecond, r2.Delay(), "等待时间应该随时间减少")
+
+				// 取消后归还配额，下一次预留的等待时间回退
+				r2.Cancel()
+				r2.Cancel()
+				r3 := reserver.Reserve(ctx, key)
+				assert.Equal(t, 150*time.Millisecond, r3.Delay(), "取消的配额应该被归还且只归还一次")
+
+				// 到达执行时间后不能再取消
+				clock.Advance(r.Delay())
+				r.Cancel()
+				assert.Equal(t, 200*time.Millisecond, reserver.Reserve(ctx, key).Delay(), "已到期的预留不应该归还配额")
+
+				// 成本超过容量的预留失败
+				assert.False(t, reserver.ReserveN(ctx, key, 11).OK(), "成本超过容量的预留应该失败")
+			})
+		}
+	}
+}
+
+// 测试阻塞等待直到请求被允许
+func TestWait(t *testing.T) {
+	tests := []struct {
+		name    string
+		limiter algorithms.RateLimiter
+	}{
+		// 支持预留的限流器
+		{"TokenBucket", tokenbucket.NewLimiter(algorithms.Config{WindowSize: time.Second, Limit: 10})},
+		// 不支持预留的限流器按等待时间重试
+		{"FixedWindow", fixedwindow.NewLimiter(algorithms.Config{WindowSize: 100 * time.Millisecond, Limit: 10})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer tt.limiter.Close()
+
+			ctx := context.Background()
+			key := "test-key"
+
+			allowed, _ := tt.limiter.AllowN(ctx, key, 10)
+			assert.True(t, allowed, "请求应该被允许")
+
+			// 截止时间早于等待结束时立即返回错误
+			shortCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			assert.Error(t, algorithms.Wait(shortCtx, tt.limiter, key), "超过截止时间应该返回错误")
+			assert.Less(t, time.Since(start), 10*time.Millisecond, "不应该白白等待")
+
+			// 等待后请求被允许
+			start = time.Now()
+			assert.NoError(t, algorithms.Wait(ctx, tt.limiter, key))
+			assert.Greater(t, time.Since(start), 50*time.Millisecond, "应该阻塞等待")
+
+			// 成本超过容量时返回错误
+			assert.ErrorIs(t, algorithms.WaitN(ctx, tt.limiter, key, 11), algorithms.ErrExceedsCapacity)
+		})
+	}
+}
+
+// 测试等待时按限流器的时钟判断是否会超过截止时间
+func TestWaitClock(t *testing.T) {
+	// 限流器的时钟比系统时间快1小时，按限流器的时间截止时间已经过去
+	clock := algorithms.NewManualClock(time.Now().Add(time.Hour))
+	config := algorithms.Config{WindowSize: 100 * time.Millisecond, Limit: 10}
+	tests := []struct {
+		name    string
+		limiter algorithms.RateLimiter
+	}{
+		{"TokenBucket", tokenbucket.NewLimiter(config, algorithms.WithClock(clock))},
+		{"FixedWindow", fixedwindow.NewLimiter(config, algorithms.WithClock(clock))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer tt.limiter.Close()
+			assert.Equal(t, clock, algorithms.ClockOf(tt.limiter))
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			key := "test-key"
+
+			allowed, _ := tt.limiter.AllowN(ctx, key, 10)
+			assert.True(t, allowed, "请求应该被允许")
+
+			start := time.Now()
+			assert.Error(t, algorithms.Wait(ctx, tt.limiter, key), "按限流器的时钟等待会超过截止时间")
+			assert.Less(t, time.Since(start), 50*time.Millisecond, "不应该白白等待")
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package whitebox
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/wureny/FluxGo/internal/algorithms"
+	"github.com/wureny/FluxGo/internal/algorithms/leakybucket"
+	"github.com/wureny/FluxGo/internal/limiter"
+	"github.com/wureny/FluxGo/internal/store/redisstore"
+)
+
+// 测试漏桶整形模式按漏水速率排队放行
+func TestLeakyBucketShaping(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	for _, storeName := range []string{"Memory", "Redis"} {
+		t.Run(storeName, func(t *testing.T) {
+			clock := algorithms.NewManualClock(epoch)
+			opts := []algorithms.Option{algorithms.WithClock(clock)}
+			if storeName == "Redis" {
+				opts = append(opts, algorithms.WithStore(redisstore.NewFromClient(client, "shaping:")))
+			}
+			// 每100ms漏出一个请求
+			l := leakybucket.NewLimiter(algorithms.Config{
+				WindowSize: time.Second,
+				Limit:      10,
+			}, opts...)
+			defer l.Close()
+
+			ctx := context.Background()
+			key := "test-key"
+			maxDelay := 300 * time.Millisecond
+
+			// 突发请求依次排队，不会同时放行
+			for i := 0; i < 4; i++ {
+				allowed, delay := l.ShapeN(ctx, key, 1, maxDelay)
+				assert.True(t, allowed, "排队时间内的请求应该被允许")
+				assert.Equal(t, time.Duration(i)*100*time.Millisecond, delay, "请求应该按漏水速率依次放行")
+			}
+
+			// 排队时间超过上限时拒绝，且不占用队列
+			allowed, wait := l.ShapeN(ctx, key, 1, maxDelay)
+			assert.False(t, allowed, "排队时间超过上限的请求应该被拒绝")
+			assert.Equal(t, 100*time.Millisecond, wait, "应该返回排队时间降到上限以内的等待时间")
+
+			clock.Advance(wait)
+			allowed, delay := l.ShapeN(ctx, key, 1, maxDelay)
+			assert.True(t, allowed, "等待后请求应该被允许")
+			assert.Equal(t, maxDelay, delay)
+
+			// 取消排队的请求归还配额
+			l.RefundN(ctx, key, 1)
+			allowed, delay = l.ShapeN(ctx, key, 1, maxDelay)
+			assert.True(t, allowed, "归还后请求应该被允许")
+			assert.Equal(t, maxDelay, delay)
+		})
+	}
+}
+
+// 测试只有漏桶规则可以开启整形模式
+func TestRuleShaping(t *testing.T) {
+	rm := limiter.NewRuleManager()
+	defer rm.Close()
+
+	config := algorithms.Config{
+		WindowSize: time.Second,
+		Limit:      10,
+	}
+	assert.NoError(t, rm.AddRule("/api/shaping", limiter.Rule{
+		Algorithm: limiter.LeakyBucket,
+		Config:    config,
+		MaxDelay:  time.Second,
+	}))
+
+	ctx := context.Background()
+	admit := func(path string) limiter.Decision {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		match, ok := rm.Match(limiter.Route(req.Method, req.Host, path), req, "client")
+		assert.True(t, ok, path)
+		return match.Admit(ctx, 1, 0)
+	}
+	decision := admit("/api/shaping")
+	assert.True(t, decision.Allowed)
+	assert.Zero(t, decision.Wait, "第一个请求不需要排队")
+	decision = admit("/api/shaping")
+	assert.True(t, decision.Allowed)
+	assert.NotZero(t, decision.Wait, "后续请求需要排队")
+
+	// 未开启整形模式的规则等价于AllowN
+	assert.NoError(t, rm.AddRule("/api/plain", limiter.Rule{
+		Algorithm: limiter.LeakyBucket,
+		Config:    config,
+	}))
+	for i := 0; i < int(config.Limit); i++ {
+		decision = admit("/api/plain")
+		assert.True(t, decision.Allowed)
+		assert.Zero(t, decision.Wait, "未开启整形模式时不排队")
+	}
+
+	assert.Error(t, rm.AddRule("/api/token", limiter.Rule{
+		Algorithm: limiter.TokenBucket,
+		Config:    config,
+		MaxDelay:  time.Second,
+	}), "令牌桶不支持整形模式")
+	assert.Error(t, rm.AddRule("/api/limits", limiter.Rule{
+		Algorithm: limiter.LeakyBucket,
+		Limits:    []algorithms.Config{config, config},
+		MaxDelay:  time.Second,
+	}), "多个限流配置不支持整形模式")
+}
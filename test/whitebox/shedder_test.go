@@ -0,0 +1,61 @@
+package whitebox
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wureny/FluxGo/internal/shedder"
+)
+
+// 测试按在途请求数、goroutine数量和调度延迟判断过载
+func TestShedder(t *testing.T) {
+	assert.False(t, shedder.Config{LatencyInterval: time.Second}.Enabled(), "没有设置上限时不启用")
+
+	// 在途请求数达到上限后拒绝，释放后恢复
+	s := shedder.NewShedder(shedder.Config{MaxInFlight: 2})
+	defer s.Close()
+	for i := 0; i < 2; i++ {
+		_, ok := s.Acquire()
+		assert.True(t, ok)
+	}
+	reason, ok := s.Acquire()
+	assert.False(t, ok, "在途请求数达到上限后应该拒绝")
+	assert.Equal(t, shedder.InFlight, reason)
+	s.Release()
+	_, ok = s.Acquire()
+	assert.True(t, ok, "释放后应该放行")
+
+	snapshot := s.Snapshot()
+	assert.Equal(t, int64(2), snapshot.InFlight, "被拒绝的请求不占用在途请求")
+	assert.Equal(t, int64(3), snapshot.Admitted)
+	assert.Equal(t, map[shedder.Reason]int64{shedder.InFlight: 1, shedder.Goroutines: 0, shedder.Latency: 0}, snapshot.Shed)
+
+	// goroutine数量达到上限后拒绝
+	g := shedder.NewShedder(shedder.Config{MaxGoroutines: runtime.NumGoroutine() + 10})
+	defer g.Close()
+	_, ok = g.Acquire()
+	assert.True(t, ok)
+	stop := make(chan struct{})
+	// 多启动一些，避免其他测试遗留的goroutine退出后数量回落
+	for i := 0; i < 20; i++ {
+		go func() { <-stop }()
+	}
+	reason, ok = g.Acquire()
+	assert.False(t, ok, "goroutine数量达到上限后应该拒绝")
+	assert.Equal(t, shedder.Goroutines, reason)
+	close(stop)
+
+	// 调度延迟超过上限后拒绝，延迟上限极小时任何测量结果都会超过
+	l := shedder.NewShedder(shedder.Config{MaxLatency: time.Nanosecond, LatencyInterval: time.Millisecond})
+	defer l.Close()
+	assert.Eventually(t, func() bool {
+		reason, ok := l.Acquire()
+		if ok {
+			l.Release()
+		}
+		return reason == shedder.Latency
+	}, time.Second, time.Millisecond, "调度延迟超过上限后应该拒绝")
+	assert.Positive(t, l.Snapshot().Latency)
+}
@@ -0,0 +1,192 @@
+package whitebox
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/wureny/FluxGo/internal/algorithms"
+	"github.com/wureny/FluxGo/internal/algorithms/composite"
+	"github.com/wureny/FluxGo/internal/algorithms/concurrency"
+	"github.com/wureny/FluxGo/internal/algorithms/fixedwindow"
+	"github.com/wureny/FluxGo/internal/algorithms/gcra"
+	"github.com/wureny/FluxGo/internal/algorithms/leakybucket"
+	"github.com/wureny/FluxGo/internal/algorithms/slidinglog"
+	"github.com/wureny/FluxGo/internal/algorithms/slidingwindow"
+	"github.com/wureny/FluxGo/internal/algorithms/tokenbucket"
+	"github.com/wureny/FluxGo/internal/limiter"
+	"github.com/wureny/FluxGo/internal/store/redisstore"
+)
+
+// 测试各算法查询状态不消耗配额，并且与实际的判断结果一致
+func TestStatus(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	config := algorithms.Config{
+		WindowSize: time.Second,
+		Limit:      4,
+	}
+
+	tests := []struct {
+		name       string
+		newLimiter func(algorithms.Config, ...algorithms.Option) algorithms.RateLimiter
+		// 用完配额后恢复到新key状态的时间
+		reset time.Duration
+	}{
+		{
+			name: "SlidingLog",
+			newLimiter: func(c algorithms.Config, opts ...algorithms.Option) algorithms.RateLimiter {
+				return slidinglog.NewLimiter(c, opts...)
+			},
+			reset: time.Second,
+		},
+		{
+			name: "FixedWindow",
+			newLimiter: func(c algorithms.Config, opts ...algorithms.Option) algorithms.RateLimiter {
+				return fixedwindow.NewLimiter(c, opts...)
+			},
+			reset: time.Second,
+		},
+		{
+			// 当前窗口的计数要到下一个窗口结束才不再有影响
+			name: "SlidingWindowCounter",
+			newLimiter: func(c algorithms.Config, opts ...algorithms.Option) algorithms.RateLimiter {
+				return slidingwindow.NewLimiter(c, opts...)
+			},
+			reset: 2 * time.Second,
+		},
+		{
+			name: "LeakyBucket",
+			newLimiter: func(c algorithms.Config, opts ...algorithms.Option) algorithms.RateLimiter {
+				return leakybucket.NewLimiter(c, opts...)
+			},
+			reset: time.Second,
+		},
+		{
+			name: "TokenBucket",
+			newLimiter: func(c algorithms.Config, opts ...algorithms.Option) algorithms.RateLimiter {
+				return tokenbucket.NewLimiter(c, opts...)
+			},
+			reset: time.Second,
+		},
+		{
+			name: "GCRA",
+			newLimiter: func(c algorithms.Config, opts ...algorithms.Option) algorithms.RateLimiter {
+				return gcra.NewLimiter(c, opts...)
+			},
+			reset: time.Second,
+		},
+		{
+			// 租约时长为WindowSize
+			name: "Concurrency",
+			newLimiter: func(c algorithms.Config, opts ...algorithms.Option) algorithms.RateLimiter {
+				return concurrency.NewLimiter(c, opts...)
+			},
+			reset: time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		for _, storeName := range []string{"Memory", "Redis"} {
+			t.Run(tt.name+"/"+storeName, func(t *testing.T) {
+				clock := algorithms.NewManualClock(epoch)
+				opts := []algorithms.Option{algorithms.WithClock(clock)}
+				prefix := "status:" + tt.name + ":"
+				if storeName == "Redis" {
+					opts = append(opts, algorithms.WithStore(redisstore.NewFromClient(client, prefix)))
+				}
+				l := tt.newLimiter(config, opts...)
+				defer l.Close()
+
+				ctx := context.Background()
+				key := "test-key"
+
+				// 新key满额可用，查询不会创建状态
+				status := l.Status(ctx, key)
+				assert.Equal(t, algorithms.Status{Limit: 4, Remaining: 4, Reset: epoch}, status)
+				assert.False(t, mr.Exists(prefix+key), "查询状态不应该创建key")
+
+				for i := 0; i < 2; i++ {
+					allowed, _ := l.Allow(ctx, key)
+					assert.True(t, allowed)
+				}
+				for i := 0; i < 3; i++ {
+					status = l.Status(ctx, key)
+					assert.Equal(t, int64(2), status.Remaining, "查询状态不应该消耗配额")
+					assert.Equal(t, time.Duration(0), status.Wait, "有剩余配额时不需要等待")
+				}
+
+				for i := 0; i < 2; i++ {
+					allowed, _ := l.Allow(ctx, key)
+					assert.True(t, allowed, "查询状态后剩余的配额应该可以使用")
+				}
+				status = l.Status(ctx, key)
+				assert.Equal(t, int64(0), status.Remaining)
+				assert.True(t, epoch.Add(tt.reset).Equal(status.Reset), "恢复时间不符合预期: %v", status.Reset)
+
+				// 等待时间与实际拒绝时返回的一致
+				allowed, wait := l.Allow(ctx, key)
+				assert.False(t, allowed)
+				assert.Greater(t, status.Wait, time.Duration(0))
+				assert.Equal(t, wait, status.Wait, "查询的等待时间应该与拒绝时返回的一致")
+
+				// 等待后可以放行
+				clock.Advance(status.Wait)
+				status = l.Status(ctx, key)
+				assert.Equal(t, time.Duration(0), status.Wait)
+				assert.GreaterOrEqual(t, status.Remaining, int64(1))
+			})
+		}
+	}
+}
+
+// 测试组合限流器和规则管理器的状态查询
+func TestRuleStatus(t *testing.T) {
+	clock := algorithms.NewManualClock(epoch)
+	perSecond := fixedwindow.NewLimiter(algorithms.Config{WindowSize: time.Second, Limit: 4}, algorithms.WithClock(clock))
+	perMinute := fixedwindow.NewLimiter(algorithms.Config{WindowSize: time.Minute, Limit: 6}, algorithms.WithClock(clock))
+	l := composite.NewLimiter(perSecond, perMinute)
+	defer l.Close()
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		allowed, _ := l.Allow(ctx, "a")
+		assert.True(t, allowed)
+	}
+
+	// 剩余配额最少的限流器决定剩余配额，恢复时间取最长的
+	status := l.Status(ctx, "a")
+	assert.Equal(t, algorithms.Status{Limit: 4, Remaining: 1, Reset: epoch.Add(time.Minute)}, status)
+
+	clock.Advance(time.Second)
+	status = l.Status(ctx, "a")
+	assert.Equal(t, algorithms.Status{Limit: 6, Remaining: 3, Reset: epoch.Add(time.Minute)}, status)
+
+	rm := limiter.NewRuleManager()
+	defer rm.Close()
+	assert.NoError(t, rm.AddRule("/api/a", limiter.Rule{
+		Algorithm: limiter.TokenBucket,
+		Config:    algorithms.Config{WindowSize: time.Second, Limit: 10},
+	}))
+	assert.NoError(t, rm.AddRule("/api/b", limiter.Rule{
+		Algorithm: limiter.FixedWindow,
+		Config:    algorithms.Config{WindowSize: time.Second, Limit: 5},
+	}))
+
+	rm.AllowN(ctx, "/api/a", "client", 3)
+	s, exists := rm.Status(ctx, "/api/a", "client")
+	assert.True(t, exists)
+	assert.Equal(t, int64(7), s.Remaining)
+	_, exists = rm.Status(ctx, "/api/none", "client")
+	assert.False(t, exists, "没有规则的路径没有状态")
+
+	statuses := rm.KeyStatus(ctx, "client")
+	assert.Len(t, statuses, 2)
+	assert.Equal(t, int64(7), statuses["/api/a"].Remaining)
+	assert.Equal(t, int64(5), statuses["/api/b"].Remaining)
+}
@@ -0,0 +1,212 @@
+package whitebox
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/wureny/FluxGo/internal/algorithms"
+	"github.com/wureny/FluxGo/internal/limiter"
+	"github.com/wureny/FluxGo/internal/store/memory"
+	"github.com/wureny/FluxGo/internal/store/redisstore"
+)
+
+// 测试所有限流算法在Redis存储上的行为，并验证多个副本共享同一份计数
+func TestRedisStore(t *testing.T) {
+	mr := miniredis.RunT(t)
+
+	for _, tt := range allAlgorithms {
+		t.Run(tt.name, func(t *testing.T) {
+			client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+			defer client.Close()
+
+			config := algorithms.Config{
+				WindowSize: time.Second,
+				Limit:      10,
+			}
+			// 模拟两个网关副本使用同一个Redis
+			s := redisstore.NewFromClient(client, "test:"+tt.name+":")
+			clock := algorithms.NewManualClock(epoch)
+			replicaA := tt.algorithm(config, algorithms.WithStore(s), algorithms.WithClock(clock))
+			replicaB := tt.algorithm(config, algorithms.WithStore(s), algorithms.WithClock(clock))
+			defer replicaA.Close()
+
+			ctx := context.Background()
+			key := "test-key"
+
+			// 两个副本交替请求，总数不能超过限制
+			for i := 0; i < int(config.Limit); i++ {
+				replica := replicaA
+				if i%2 == 1 {
+					replica = replicaB
+				}
+				allowed, wait := replica.Allow(ctx, key)
+				assert.True(t, allowed, "请求应该被允许")
+				assert.Zero(t, wait, "不应该有等待时间")
+			}
+
+			allowed, wait := replicaA.Allow(ctx, key)
+			assert.False(t, allowed, "超出限制的请求应该被拒绝")
+			assert.NotZero(t, wait, "应该有等待时间")
+			allowed, _ = replicaB.Allow(ctx, key)
+			assert.False(t, allowed, "另一个副本也应该拒绝请求")
+
+			// 不同的key互不影响
+			allowed, _ = replicaB.Allow(ctx, "other-key")
+			assert.True(t, allowed, "其他key的请求应该被允许")
+
+			// 按返回的等待时间等待
+			clock.Advance(wait)
+
+			allowed, wait = replicaB.Allow(ctx, key)
+			assert.True(t, allowed, "等待后请求应该被允许")
+			assert.Zero(t, wait, "不应该有等待时间")
+		})
+	}
+}
+
+// 测试规则管理器按规则选择存储
+func TestRuleManagerStore(t *testing.T) {
+	mr := miniredis.RunT(t)
+
+	rm := limiter.NewRuleManager()
+	defer rm.Close()
+	rm.RegisterStore(limiter.RedisStore, redisstore.New(redisstore.Config{Addr: mr.Addr()}))
+
+	ctx := context.Background()
+	rule := limiter.Rule{
+		Algorithm: limiter.TokenBucket,
+		Config: algorithms.Config{
+			WindowSize: time.Minute,
+			Limit:      1,
+		},
+		Store: limiter.RedisStore,
+	}
+	assert.NoError(t, rm.AddRule("/api/test", rule))
+
+	allowed, _ := rm.Allow(ctx, "/api/test", "client")
+	assert.True(t, allowed, "第一个请求应该被允许")
+	assert.NotEmpty(t, mr.Keys(), "状态应该写入Redis")
+
+	// 重建规则后状态仍然保存在Redis中
+	assert.NoError(t, rm.AddRule("/api/test", rule))
+	allowed, _ = rm.Allow(ctx, "/api/test", "client")
+	assert.False(t, allowed, "共享状态下第二个请求应该被拒绝")
+
+	// 未注册的存储类型
+	rule.Store = "unknown"
+	assert.Error(t, rm.AddRule("/api/other", rule))
+}
+
+// 测试内存存储清理已经回到初始状态的key
+func TestMemoryStoreExpire(t *testing.T) {
+	for _, tt := range allAlgorithms {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := algorithms.NewManualClock(epoch)
+			s := memory.New(memory.Config{Now: clock.Now})
+			config := algorithms.Config{
+				WindowSize: time.Second,
+				Limit:      10,
+			}
+			limiter := tt.algorithm(config, algorithms.WithStore(s), algorithms.WithClock(clock))
+			defer limiter.Close()
+
+			ctx := context.Background()
+			for i := 0; i < int(config.Limit); i++ {
+				limiter.Allow(ctx, "test-key")
+			}
+			assert.Equal(t, 1, s.Stats().Keys)
+
+			// 状态尚未恢复时不能清理
+			s.Cleanup(clock.Now())
+			assert.Equal(t, 1, s.Stats().Keys, "未恢复的状态不应该被清理")
+
+			// 两个窗口之后所有算法的状态都已恢复
+			clock.Advance(2 * config.WindowSize)
+			s.Cleanup(clock.Now())
+			stats := s.Stats()
+			assert.Equal(t, 0, stats.Keys, "恢复到初始状态的key应该被清理")
+			assert.Equal(t, int64(1), stats.Expired)
+		})
+	}
+}
+
+// 测试内存存储按LRU淘汰超过容量的key
+func TestMemoryStoreMaxKeys(t *testing.T) {
+	rm := limiter.NewRuleManager()
+	defer rm.Close()
+
+	err := rm.AddRule("/api/test", limiter.Rule{
+		Algorithm: limiter.TokenBucket,
+		Config: algorithms.Config{
+			WindowSize: time.Minute,
+			Limit:      1,
+		},
+		MaxKeys: 2,
+	})
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	for _, key := range []string{"a", "b"} {
+		allowed, _ := rm.Allow(ctx, "/api/test", key)
+		assert.True(t, allowed)
+	}
+
+	// 访问a使b成为最久未访问的key
+	allowed, _ := rm.Allow(ctx, "/api/test", "a")
+	assert.False(t, allowed, "a的配额已用完")
+
+	allowed, _ = rm.Allow(ctx, "/api/test", "c")
+	assert.True(t, allowed)
+
+	stats := rm.Stats()["/api/test"]
+	assert.Equal(t, 2, stats.Keys, "key数量不应该超过上限")
+	assert.Equal(t, int64(1), stats.Evicted)
+
+	// b已被淘汰，重新获得配额；a仍然保留
+	allowed, _ = rm.Allow(ctx, "/api/test", "b")
+	assert.True(t, allowed, "被淘汰的key重新开始计数")
+	allowed, _ = rm.Allow(ctx, "/api/test", "c")
+	assert.False(t, allowed, "未被淘汰的key保留状态")
+}
+
+// 测试存储不可用时按规则的策略放行或拒绝请求
+func TestStoreFailurePolicy(t *testing.T) {
+	mr := miniredis.RunT(t)
+
+	rm := limiter.NewRuleManager()
+	defer rm.Close()
+	rm.RegisterStore(limiter.RedisStore, redisstore.New(redisstore.Config{Addr: mr.Addr()}))
+
+	config := algorithms.Config{WindowSize: time.Minute, Limit: 10}
+	for path, policy := range map[string]algorithms.FailurePolicy{
+		"/api/default": "",
+		"/api/open":    algorithms.FailOpen,
+		"/api/closed":  algorithms.FailClosed,
+	} {
+		assert.NoError(t, rm.AddRule(path, limiter.Rule{
+			Algorithm:    limiter.TokenBucket,
+			Config:       config,
+			Store:        limiter.RedisStore,
+			StoreFailure: policy,
+		}))
+	}
+	assert.Error(t, rm.AddRule("/api/unknown", limiter.Rule{
+		Algorithm:    limiter.TokenBucket,
+		Config:       config,
+		StoreFailure: "unknown",
+	}), "不支持的策略应该被拒绝")
+
+	mr.Close()
+
+	ctx := context.Background()
+	allowed, _ := rm.Allow(ctx, "/api/default", "client")
+	assert.True(t, allowed, "默认放行请求")
+	allowed, _ = rm.Allow(ctx, "/api/open", "client")
+	assert.True(t, allowed, "open策略放行请求")
+	allowed, _ = rm.Allow(ctx, "/api/closed", "client")
+	assert.False(t, allowed, "closed策略拒绝请求")
+}